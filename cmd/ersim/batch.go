@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+	"github.com/lars-sto/error-recovery-simulation/internal/sim"
+)
+
+func batchCmd(args []string) error {
+	var (
+		sf scenarioFlags
+		ef engineFlags
+	)
+	fs := newFlagSet("batch", "")
+	sf.register(fs, "scenario name filter (substring)")
+	ef.register(fs)
+	var (
+		runs    = fs.Int("runs", 30, "repeats per scenario/mode")
+		outPath = fs.String("out", "results/summary.csv", "output summary CSV file")
+		csvDir  = fs.String("csvdir", "", "optional: write per-run time series CSV into this directory (empty disables)")
+		pcapDir = fs.String("pcapdir", "", "optional: write per-run pcapng captures of virtual-clock runs into this directory (empty disables)")
+		tsOnly  = fs.String("timeseries", "", "optional: comma-separated scenario substrings to write time series and captures for (requires -csvdir or -pcapdir)")
+		fecDec  = decoderFlag(fs)
+		workers = fs.Int("workers", runtime.NumCPU(), "parallel runs (output order and content match -workers 1); wall-clock runs use 1")
+		sweep   = fs.String("sweep", "", "optional: YAML/JSON file of controller parameter sets; each set runs as its own adaptive mode")
+		modes   = fs.String("modes", "static,adaptive", "comma-separated modes: static | adaptive | oracle | proportional | external | static_sweep (static R=0..-static-max-r)")
+		maxR    = fs.Int("static-max-r", -1, "largest R of static_sweep (-1: K x max_overhead of the controller config)")
+		polCmd  = policyCmdFlag(fs)
+		clock   = clockFlag(fs, true)
+	)
+	if err := parseFlags(fs, args, false); err != nil {
+		return err
+	}
+
+	decoderMode, err := parseDecoder(*fecDec)
+	if err != nil {
+		return err
+	}
+	clocks, err := parseClock(*clock, true)
+	if err != nil {
+		return err
+	}
+	if *pcapDir != "" && clocks[0] != clockVirtual {
+		return usagef("-pcapdir needs -clock virtual or both")
+	}
+	engine, err := ef.config()
+	if err != nil {
+		return err
+	}
+	// without -sweep the adaptive mode runs once with the -engine config
+	variants := []engineVariant{{cfg: engine}}
+	if *sweep != "" {
+		base := sim.DefaultEngineConfig()
+		if engine != nil {
+			base = *engine
+		}
+		sv, err := sim.LoadEngineSweep(*sweep, base)
+		if err != nil {
+			return err
+		}
+		variants = variants[:0]
+		for _, v := range sv {
+			variants = append(variants, engineVariant{label: v.Label, cfg: &v.Config})
+		}
+	}
+	runModes, err := parseModes(*modes)
+	if err != nil {
+		return err
+	}
+	external := externalPolicy(*polCmd)
+	for _, m := range runModes {
+		if m == sim.ModeExternal && external == nil {
+			return usagef("mode %s needs -policy-cmd", m)
+		}
+	}
+	scenarios, err := sf.load()
+	if err != nil {
+		return err
+	}
+
+	if clocks[len(clocks)-1] != clockVirtual {
+		for _, sc := range scenarios {
+			if err := sim.CheckRealtime(sc); err != nil {
+				return err
+			}
+		}
+		// wall-clock runs measure real time, parallel runs would disturb each other's timing
+		if *workers > 1 {
+			if flagSet(fs, "workers") {
+				fmt.Fprintf(os.Stderr, "ersim batch: -clock %s runs one job at a time, ignoring -workers %d\n", *clock, *workers)
+			}
+			*workers = 1
+		}
+	}
+	// a loss model without its own per-run copy would be shared by parallel runs
+	if *workers > 1 {
+		for _, sc := range scenarios {
+			if !sc.CopiesLossModels() {
+				fmt.Fprintf(os.Stderr, "ersim batch: scenario %q shares a loss model between runs, running one job at a time\n", sc.Name)
+				*workers = 1
+				break
+			}
+		}
+	}
+
+	w, err := sim.NewSummaryCSVWriter(*outPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = w.Close() }()
+
+	allowTS := parseCSVList(*tsOnly)
+
+	// Enumerate runs in the sequential order; workers may finish them out of order
+	var jobs []runJob
+	for _, sc := range scenarios {
+		for _, mode := range runModes {
+			// one job template per variant of the mode: sweep sets for adaptive, R values for static_sweep
+			var tmpls []runJob
+			switch mode {
+			case sim.ModeAdaptive:
+				for _, v := range variants {
+					tmpls = append(tmpls, runJob{sc: sc, mode: mode, engine: v.cfg, label: v.label})
+				}
+			case modeStaticSweep:
+				last := *maxR
+				if last < 0 {
+					cfg := sim.DefaultEngineConfig()
+					if engine != nil {
+						cfg = *engine
+					}
+					last = int(float64(sc.K)*cfg.MaxOverhead + 1e-9)
+				}
+				for r := 0; r <= last; r++ {
+					t := runJob{sc: sc, mode: sim.ModeStatic, label: fmt.Sprintf("r=%d", r)}
+					t.sc.StaticR = uint32(r)
+					tmpls = append(tmpls, t)
+				}
+			default:
+				// baselines share the controller config (max_overhead, protection_scale)
+				tmpls = append(tmpls, runJob{sc: sc, mode: mode, engine: engine})
+				if mode == sim.ModeExternal {
+					tmpls[0].policy = external
+				}
+			}
+			for _, t := range tmpls {
+				for _, clock := range clocks {
+					for i := 0; i < *runs; i++ {
+						j := t
+						j.clock = clock
+						j.seed = sf.seed + int64(i)
+						if *csvDir != "" && wantTimeseries(sc.Name, allowTS) {
+							j.tsPath = filepath.Join(*csvDir, fmt.Sprintf("%s__%s__seed%d.csv", sc.Name, fileLabel(j.modeLabel()), j.seed))
+						}
+						if *pcapDir != "" && clock == clockVirtual && wantTimeseries(sc.Name, allowTS) {
+							j.pcapPath = filepath.Join(*pcapDir, fmt.Sprintf("%s__%s__seed%d.pcapng", sc.Name, fileLabel(j.modeLabel()), j.seed))
+						}
+						jobs = append(jobs, j)
+					}
+				}
+			}
+		}
+	}
+
+	run := func(j runJob) (sim.SummaryRow, error) {
+		row, _, err := runOne(j, decoderMode)
+		return row, err
+	}
+	if err := runOrdered(jobs, *workers, run, w.WriteRow); err != nil {
+		return err
+	}
+
+	// ensure flushed
+	return w.Close()
+}
+
+// modeStaticSweep is the batch-only mode that expands into static runs with R=0..N
+const modeStaticSweep sim.Mode = "static_sweep"
+
+// parseModes parses the -modes list
+func parseModes(s string) ([]sim.Mode, error) {
+	var out []sim.Mode
+	for _, name := range parseCSVList(s) {
+		if sim.Mode(name) == modeStaticSweep {
+			out = append(out, modeStaticSweep)
+			continue
+		}
+		m, err := sim.ParseMode(name)
+		if err != nil {
+			return nil, usageError{err: err}
+		}
+		out = append(out, m)
+	}
+	if len(out) == 0 {
+		return nil, usagef("-modes: no modes given")
+	}
+	return out, nil
+}
+
+type runJob struct {
+	sc   sim.Scenario
+	mode sim.Mode
+	seed int64
+	// engine configures the adaptive controller (nil = default), label names a sweep variant
+	engine *recovery.Config
+	label  string
+	// policy drives mode external
+	policy sim.PolicyFactory
+	// clock is virtual, realtime or webrtc
+	clock string
+	// tsPath is the time series CSV to write (empty disables)
+	tsPath string
+	// pcapPath is the pcapng capture to write (empty disables, virtual clock only)
+	pcapPath string
+}
+
+// modeLabel is the summary mode column: the mode, with the sweep or R label and
+// a wall clock appended
+func (j runJob) modeLabel() sim.Mode {
+	m := j.mode
+	if j.label != "" {
+		m += ":" + sim.Mode(j.label)
+	}
+	if j.clock != "" && j.clock != clockVirtual {
+		m += ":" + sim.Mode(j.clock)
+	}
+	return m
+}
+
+type engineVariant struct {
+	label string
+	cfg   *recovery.Config
+}
+
+// fileLabel makes a mode label safe for file names
+func fileLabel(m sim.Mode) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.', r == '=':
+			return r
+		}
+		return '_'
+	}, string(m))
+}
+
+// runOne executes a single scenario/mode/seed run with its own recorders
+func runOne(j runJob, decoderMode sim.FECDecoderMode) (sim.SummaryRow, sim.RunResult, error) {
+	// summary recorder (always)
+	sumRec := sim.NewSummaryRecorder()
+
+	// optional time series CSV recorder
+	var rec sim.Recorder = sumRec
+	if j.tsPath != "" {
+		tsRec, err := sim.NewCSVRecorder(j.tsPath)
+		if err != nil {
+			return sim.SummaryRow{}, sim.RunResult{}, err
+		}
+		rec = sim.MultiRecorder(sumRec, tsRec)
+	}
+	var pcap *sim.PcapWriter
+	if j.pcapPath != "" {
+		var err error
+		if pcap, err = sim.NewPcapWriter(j.pcapPath); err != nil {
+			return sim.SummaryRow{}, sim.RunResult{}, err
+		}
+	}
+
+	run := sim.RunScenario
+	switch j.clock {
+	case clockRealtime:
+		run = sim.RunRealtime
+	case clockWebRTC:
+		run = sim.RunWebRTC
+	}
+	res, err := run(j.sc, sim.RunOptions{
+		Mode:     j.mode,
+		Seed:     j.seed,
+		Recorder: rec,
+
+		FECDecoder: decoderMode,
+		Engine:     j.engine,
+		Policy:     j.policy,
+		Pcap:       pcap,
+	})
+	if err != nil {
+		return sim.SummaryRow{}, res, err
+	}
+
+	return sim.SummaryRow{
+		Scenario:   j.sc.Name,
+		Mode:       j.modeLabel(),
+		Seed:       j.seed,
+		DurationMs: res.Duration.Milliseconds(),
+
+		FinalLossDeadline:   res.FinalLossDeadline,
+		FinalLossNoDeadline: res.FinalLossNoDeadline,
+
+		OverheadRatioBytes: res.OverheadRatioBytes,
+		OverheadRatioPkts:  res.OverheadRatioPkts,
+
+		MeanQueueDelayMs: sumRec.MeanQueueDelayMs(),
+
+		MeanPolicyR:        sumRec.MeanPolicyR(),
+		MaxPolicyR:         sumRec.MaxPolicyR(),
+		MeanPolicyOverhead: sumRec.MeanPolicyOverhead(),
+
+		MeanLossWindow: sumRec.MeanLossWindow(),
+		MaxLossWindow:  sumRec.MaxLossWindow(),
+
+		SentMediaPkts: res.SentMediaPkts,
+		SentFECPkts:   res.SentFECPkts,
+		DroppedMedia:  res.DroppedMediaPkts,
+		DroppedFEC:    res.DroppedFECPkts,
+		QueueDrops:    res.DroppedQueuePkts,
+		WireDrops:     res.DroppedWirePkts,
+		AQMDrops:      res.DroppedAQMPkts,
+
+		RecoveredPkts:      res.RecoveredPkts,
+		UniquePkts:         res.UniquePkts,
+		GoodWithinDeadline: res.GoodWithinDeadline,
+
+		DuplicatePkts:   res.DuplicatePkts,
+		ReorderedPkts:   res.ReorderedPkts,
+		MaxReorderDepth: res.MaxReorderDepth,
+
+		NACKedPkts:         res.NACKedPkts,
+		RetransmittedPkts:  res.RetransmittedPkts,
+		RetransmittedBytes: res.RetransmittedBytes,
+		RecoveredRTXPkts:   res.RecoveredRTXPkts,
+
+		DecodableFrameRatio: res.DecodableFrameRatio,
+		FreezeCount:         res.FreezeCount,
+		TotalFreezeMs:       float64(res.TotalFreeze) / float64(time.Millisecond),
+		MaxFreezeMs:         float64(res.MaxFreeze) / float64(time.Millisecond),
+
+		CrossThroughputBps: crossThroughputBps(res.CrossTraffic),
+
+		Latency:       res.Latency.Percentiles(),
+		DirectLatency: res.DirectLatency.Percentiles(),
+		FECLatency:    res.FECLatency.Percentiles(),
+		RecoveryDelay: res.RecoveryDelay.Percentiles(),
+	}, res, nil
+}
+
+// crossThroughputBps sums the delivered throughput of all cross traffic flows
+func crossThroughputBps(flows []sim.CrossFlowResult) float64 {
+	var sum float64
+	for _, f := range flows {
+		sum += f.ThroughputBps
+	}
+	return sum
+}
+
+func parseCSVList(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func wantTimeseries(name string, allow []string) bool {
+	// empty allowlist => never write timeseries
+	if len(allow) == 0 {
+		return false
+	}
+	for _, sub := range allow {
+		if strings.Contains(name, sub) {
+			return true
+		}
+	}
+	return false
+}
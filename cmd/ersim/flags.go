@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+	"github.com/lars-sto/error-recovery-simulation/internal/sim"
+)
+
+// usageError marks errors caused by the command line (exit code exitUsage)
+type usageError struct {
+	err     error
+	printed bool
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+func usagef(format string, args ...any) error {
+	return usageError{err: fmt.Errorf(format, args...)}
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet("ersim "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), strings.TrimSpace(fmt.Sprintf("usage: ersim %s [flags] %s", name, args)))
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args; without allowArgs positional arguments are an error
+func parseFlags(fs *flag.FlagSet, args []string, allowArgs bool) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return usageError{err: err, printed: true}
+	}
+	if !allowArgs && fs.NArg() > 0 {
+		return usagef("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+// flagSet reports whether the flag name was given on the command line
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// scenarioFlags selects the scenarios a command works on
+type scenarioFlags struct {
+	seed   int64
+	dir    string
+	filter string
+}
+
+func (f *scenarioFlags) register(fs *flag.FlagSet, filterUsage string) {
+	fs.Int64Var(&f.seed, "seed", 1, "base seed (run seed = seed + i)")
+	fs.StringVar(&f.dir, "scenarios", "", "optional: directory of YAML/JSON scenario files (empty uses built-in defaults)")
+	fs.StringVar(&f.filter, "scenario", "", filterUsage)
+}
+
+// load returns the built-in or file scenarios whose name contains the filter
+func (f *scenarioFlags) load() ([]sim.Scenario, error) {
+	scenarios := sim.DefaultScenarios(f.seed)
+	if f.dir != "" {
+		loaded, err := sim.LoadScenarioDir(f.dir, f.seed)
+		if err != nil {
+			return nil, err
+		}
+		scenarios = loaded
+	}
+	out := scenarios[:0]
+	for _, sc := range scenarios {
+		if strings.Contains(sc.Name, f.filter) {
+			out = append(out, sc)
+		}
+	}
+	return out, nil
+}
+
+// decoderFlag registers -fec-decoder
+func decoderFlag(fs *flag.FlagSet) *string {
+	return fs.String("fec-decoder", "peeling", "receiver FlexFEC recovery: peeling | gaussian")
+}
+
+func parseDecoder(s string) (sim.FECDecoderMode, error) {
+	m, err := sim.ParseFECDecoderMode(s)
+	if err != nil {
+		return "", usageError{err: err}
+	}
+	return m, nil
+}
+
+// engineFlags configures the adaptive controller
+type engineFlags struct {
+	file string
+	set  string
+}
+
+func (f *engineFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.file, "engine", "", "optional: YAML/JSON file of controller config fields (unset fields keep their defaults)")
+	fs.StringVar(&f.set, "engine-set", "", "optional: comma-separated controller fields, e.g. enable_loss=0.02,max_overhead=0.3 (applied after -engine)")
+}
+
+// config returns the controller config, or nil when neither flag is set
+func (f *engineFlags) config() (*recovery.Config, error) {
+	if f.file == "" && f.set == "" {
+		return nil, nil
+	}
+	cfg := sim.DefaultEngineConfig()
+	if f.file != "" {
+		var err error
+		if cfg, err = sim.LoadEngineConfig(f.file, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if f.set != "" {
+		fields, err := sim.ParseEngineSet(f.set)
+		if err != nil {
+			return nil, usageError{err: err}
+		}
+		if cfg, err = sim.OverlayEngineConfig(cfg, fields); err != nil {
+			return nil, usageError{err: err}
+		}
+	}
+	return &cfg, nil
+}
+
+// policyCmdFlag registers -policy-cmd
+func policyCmdFlag(fs *flag.FlagSet) *string {
+	return fs.String("policy-cmd", "", "controller command for mode external, split on spaces; one process per run speaking JSON lines on stdin/stdout")
+}
+
+// externalPolicy returns the factory for mode external (nil without -policy-cmd)
+func externalPolicy(cmd string) sim.PolicyFactory {
+	argv := strings.Fields(cmd)
+	if len(argv) == 0 {
+		return nil
+	}
+	return sim.NewProcessPolicy(argv)
+}
+
+// Clocks of a run: simulated time (sim.RunScenario), wall clock over loopback UDP
+// (sim.RunRealtime) or wall clock between two pion/webrtc PeerConnections (sim.RunWebRTC)
+const (
+	clockVirtual  = "virtual"
+	clockRealtime = "realtime"
+	clockWebRTC   = "webrtc"
+)
+
+// clockFlag registers -clock; both is only offered where runs are compared (batch)
+func clockFlag(fs *flag.FlagSet, both bool) *string {
+	usage := "virtual (simulated time) | realtime (wall clock, RTP over UDP on loopback) | webrtc (wall clock between two pion PeerConnections, needs -tags webrtc)"
+	if both {
+		usage += " | both (every run in both, realtime runs labelled <mode>:realtime)"
+	}
+	return fs.String("clock", "virtual", usage)
+}
+
+// parseClock returns the clocks every job runs in
+func parseClock(s string, both bool) ([]string, error) {
+	switch s {
+	case clockVirtual, clockRealtime:
+		return []string{s}, nil
+	case clockWebRTC:
+		if !sim.WebRTCSupported {
+			return nil, usagef("-clock webrtc needs a build with -tags webrtc")
+		}
+		return []string{s}, nil
+	case "both":
+		if both {
+			return []string{clockVirtual, clockRealtime}, nil
+		}
+	}
+	return nil, usagef("unknown -clock %q", s)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func listScenariosCmd(args []string) error {
+	var sf scenarioFlags
+	fs := newFlagSet("list-scenarios", "")
+	sf.register(fs, "scenario name filter (substring)")
+	if err := parseFlags(fs, args, false); err != nil {
+		return err
+	}
+	scenarios, err := sf.load()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDURATION\tK\tSTATIC_R\tSENDER\tFEATURES")
+	for _, sc := range scenarios {
+		sender := fmt.Sprintf("%d pkt/s", sc.Sender.PacketRateHz)
+		if v := sc.Sender.Video; v != nil {
+			sender = fmt.Sprintf("video %d fps", v.FPS)
+		}
+		if r := sc.Sender.Replay; r != nil {
+			sender = fmt.Sprintf("replay %d pkts", len(r.Packets))
+		}
+		var features []string
+		if len(sc.Path) > 0 {
+			features = append(features, fmt.Sprintf("path(%d)", len(sc.Path)))
+		}
+		if sc.Link.Trace != nil {
+			features = append(features, "trace")
+		}
+		if sc.Feedback != nil {
+			features = append(features, "rtcp")
+		}
+		if sc.RTX != nil {
+			features = append(features, "rtx")
+		}
+		if len(sc.CrossTraffic) > 0 {
+			features = append(features, fmt.Sprintf("cross(%d)", len(sc.CrossTraffic)))
+		}
+		if len(features) == 0 {
+			features = []string{"-"}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", sc.Name, sc.Duration, sc.K, sc.StaticR, sender, strings.Join(features, ","))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,89 @@
+// Command ersim runs the error recovery simulations
+//
+//	ersim <command> [flags]
+//
+// Run "ersim help" for the list of commands and "ersim <command> -h" for their flags
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1 // a run, validation or check failed
+	exitUsage = 2 // unknown command, bad flags or arguments
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"run", "run one scenario/mode/seed and print its summary", runCmd},
+	{"batch", "run scenarios x modes x seeds into a summary CSV", batchCmd},
+	{"report", "compare the modes of a summary CSV with confidence intervals and paired tests", reportCmd},
+	{"policy", "replay a synthetic stats series through the engine offline", policyCmd},
+	{"list-scenarios", "list built-in or file scenarios", listScenariosCmd},
+	{"validate", "validate scenario files and directories", validateCmd},
+	{"smoke", "check that runtime config updates reach the FlexFEC interceptor", smokeCmd},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+func run(args []string, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(stderr)
+		return exitOK
+	}
+
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.run(args[1:])
+		var uerr usageError
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.As(err, &uerr):
+			// the flag package already printed parse errors
+			if !uerr.printed {
+				fmt.Fprintf(stderr, "ersim %s: %v\n", c.name, err)
+			}
+			return exitUsage
+		default:
+			fmt.Fprintf(stderr, "ersim %s: %v\n", c.name, err)
+			return exitError
+		}
+	}
+
+	fmt.Fprintf(stderr, "ersim: unknown command %q\n\n", args[0])
+	usage(stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: ersim <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-15s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `run "ersim <command> -h" for the flags of a command`)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+)
+
+type nopSink struct{}
+
+func (nopSink) Publish(recovery.PolicyDecision) {}
+
+func policyCmd(args []string) error {
+	fs := newFlagSet("policy", "")
+	var (
+		name    = fs.String("scenario", "03_bwe_bottleneck", "stats series to replay (see -list)")
+		outPath = fs.String("out", "", "output CSV (default simdata/<scenario>.csv)")
+		list    = fs.Bool("list", false, "list the available stats series and exit")
+	)
+	if err := parseFlags(fs, args, false); err != nil {
+		return err
+	}
+	if *list {
+		for _, p := range policyScenarios {
+			fmt.Println(p.name)
+		}
+		return nil
+	}
+
+	build, ok := pickScenario(*name)
+	if !ok {
+		return usagef("unknown scenario %q (see ersim policy -list)", *name)
+	}
+	if *outPath == "" {
+		*outPath = fmt.Sprintf("simdata/%s.csv", *name)
+	}
+	series := build(time.Now())
+
+	cfg := recovery.DefaultConfig()
+
+	obs, err := NewCSVObserver(*outPath)
+	if err != nil {
+		return err
+	}
+
+	statsCh := make(chan recovery.NetworkStats, len(series))
+	for _, s := range series {
+		statsCh <- s
+	}
+	close(statsCh)
+
+	src := recovery.NewChanSource(statsCh)
+
+	eng := recovery.NewEngine(cfg, src, nopSink{}, obs)
+	eng.Run(context.Background())
+	return obs.Close()
+}
+
+// policyScenarios are the synthetic stats series for offline replay
+var policyScenarios = []struct {
+	name  string
+	build func(start time.Time) []recovery.NetworkStats
+}{
+	{"01_loss_increase", scenario01IncreasingLoss},
+	{"02_loss_threshold_oscillation", scenario02LossAroundEnable},
+	{"03_bwe_bottleneck", scenario03BWEBottleneck},
+}
+
+func pickScenario(name string) (func(time.Time) []recovery.NetworkStats, bool) {
+	for _, p := range policyScenarios {
+		if p.name == name {
+			return p.build, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/lars-sto/error-recovery-simulation/internal/report"
+	"github.com/lars-sto/error-recovery-simulation/internal/sim"
+)
+
+func reportCmd(args []string) error {
+	fs := newFlagSet("report", "")
+	var (
+		inPath   = fs.String("in", "results/summary.csv", "summary CSV written by batch")
+		outPath  = fs.String("out", "", "output file (default stdout)")
+		format   = fs.String("format", "markdown", "markdown | csv")
+		baseline = fs.String("baseline", string(sim.ModeStatic), "mode the others are compared with (paired by seed)")
+		metrics  = fs.String("metrics", "final_loss_deadline,final_loss_no_deadline,overhead_ratio_bytes,mean_queue_delay_ms", "comma-separated summary columns to report")
+		headline = fs.String("headline", "final_loss_deadline,overhead_ratio_bytes", "outcome,cost metric of the one-line claims in markdown (empty disables)")
+		level    = fs.Float64("level", 0.95, "confidence level of the intervals")
+	)
+	if err := parseFlags(fs, args, false); err != nil {
+		return err
+	}
+	if *format != "markdown" && *format != "csv" {
+		return usagef("unknown -format %q (expected markdown or csv)", *format)
+	}
+	cfg := report.Config{
+		Baseline: *baseline,
+		Metrics:  parseCSVList(*metrics),
+		Level:    *level,
+		Headline: parseCSVList(*headline),
+	}
+	if len(cfg.Headline) != 0 && len(cfg.Headline) != 2 {
+		return usagef("-headline needs two metrics (outcome,cost), got %d", len(cfg.Headline))
+	}
+	for _, m := range cfg.Headline {
+		if !slices.Contains(cfg.Metrics, m) {
+			cfg.Metrics = append(cfg.Metrics, m)
+		}
+	}
+
+	f, err := os.Open(*inPath)
+	if err != nil {
+		return err
+	}
+	rows, err := report.ReadSummary(f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	rep, err := report.Build(rows, cfg)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *outPath != "" {
+		if err := os.MkdirAll(filepath.Dir(*outPath), 0o755); err != nil {
+			return err
+		}
+		out, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = out.Close() }()
+		w = out
+	}
+	if *format == "csv" {
+		err = rep.WriteCSV(w)
+	} else {
+		err = rep.WriteMarkdown(w)
+	}
+	if err != nil {
+		return err
+	}
+	if c, ok := w.(io.Closer); ok && *outPath != "" {
+		return c.Close()
+	}
+	return nil
+}
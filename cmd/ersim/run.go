@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/lars-sto/error-recovery-simulation/internal/sim"
+)
+
+func runCmd(args []string) error {
+	var (
+		sf scenarioFlags
+		ef engineFlags
+	)
+	fs := newFlagSet("run", "")
+	sf.register(fs, "scenario name (required, exact match)")
+	ef.register(fs)
+	var (
+		mode   = fs.String("mode", string(sim.ModeAdaptive), "static_flexfec | adaptive_engine | oracle | loss_proportional | external (or static | adaptive | proportional)")
+		csvOut = fs.String("csv", "", "optional: write the time series CSV to this file")
+		pcap   = fs.String("pcap", "", "optional: write the run's RTP packets as pcapng to this file (virtual clock only)")
+		fecDec = decoderFlag(fs)
+		polCmd = policyCmdFlag(fs)
+		clock  = clockFlag(fs, false)
+	)
+	if err := parseFlags(fs, args, false); err != nil {
+		return err
+	}
+	if sf.filter == "" {
+		return usagef("-scenario is required (see ersim list-scenarios)")
+	}
+
+	m, err := sim.ParseMode(*mode)
+	if err != nil {
+		return usageError{err: err}
+	}
+	external := externalPolicy(*polCmd)
+	if m == sim.ModeExternal && external == nil {
+		return usagef("mode %s needs -policy-cmd", m)
+	}
+	decoderMode, err := parseDecoder(*fecDec)
+	if err != nil {
+		return err
+	}
+	clocks, err := parseClock(*clock, false)
+	if err != nil {
+		return err
+	}
+	if *pcap != "" && clocks[0] != clockVirtual {
+		return usagef("-pcap needs -clock virtual")
+	}
+	engine, err := ef.config()
+	if err != nil {
+		return err
+	}
+	scenarios, err := sf.load()
+	if err != nil {
+		return err
+	}
+	var sc *sim.Scenario
+	for i := range scenarios {
+		if scenarios[i].Name == sf.filter {
+			sc = &scenarios[i]
+		}
+	}
+	if sc == nil {
+		return usagef("unknown scenario %q (see ersim list-scenarios)", sf.filter)
+	}
+
+	j := runJob{sc: *sc, mode: m, seed: sf.seed, engine: engine, clock: clocks[0], tsPath: *csvOut, pcapPath: *pcap}
+	if m == sim.ModeExternal {
+		j.policy = external
+	}
+	row, res, err := runOne(j, decoderMode)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	out := func(k string, v any) { fmt.Fprintf(tw, "%s\t%v\n", k, v) }
+	out("scenario", row.Scenario)
+	out("mode", row.Mode)
+	out("seed", row.Seed)
+	out("final_loss_deadline", fmt.Sprintf("%.4f", row.FinalLossDeadline))
+	out("final_loss_no_deadline", fmt.Sprintf("%.4f", row.FinalLossNoDeadline))
+	out("overhead_ratio_bytes", fmt.Sprintf("%.4f", row.OverheadRatioBytes))
+	out("mean_queue_delay_ms", fmt.Sprintf("%.2f", row.MeanQueueDelayMs))
+	out("mean_policy_r", fmt.Sprintf("%.2f", row.MeanPolicyR))
+	out("sent_media_pkts", row.SentMediaPkts)
+	out("sent_fec_pkts", row.SentFECPkts)
+	out("dropped_media_pkts", row.DroppedMedia)
+	out("recovered_pkts", row.RecoveredPkts)
+	if res.RetransmittedPkts > 0 {
+		out("recovered_rtx_pkts", row.RecoveredRTXPkts)
+	}
+	if res.Frames > 0 {
+		out("decodable_frame_ratio", fmt.Sprintf("%.4f", row.DecodableFrameRatio))
+		out("freeze_count", row.FreezeCount)
+	}
+	if *csvOut != "" {
+		out("timeseries", *csvOut)
+	}
+	if *pcap != "" {
+		out("pcap", *pcap)
+	}
+	return tw.Flush()
+}
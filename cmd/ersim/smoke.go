@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"sync/atomic"
 	"time"
@@ -11,7 +12,13 @@ import (
 	"github.com/pion/rtp"
 )
 
-func main() {
+// smokeCmd checks the runtime bus end to end: publishing (k, r) must switch FEC output on
+func smokeCmd(args []string) error {
+	fs := newFlagSet("smoke", "")
+	if err := parseFlags(fs, args, false); err != nil {
+		return err
+	}
+
 	const (
 		mediaSSRC uint32 = 1111
 		fecSSRC   uint32 = 2222
@@ -28,13 +35,13 @@ func main() {
 		flexfec.NumFECPackets(0),
 	)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	reg.Add(fecFactory)
 
 	i, err := reg.Build("")
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer func() { _ = i.Close() }()
 
@@ -63,7 +70,9 @@ func main() {
 		NumFECPackets:   0,
 	})
 
-	_ = sendMediaN(mediaWriter, mediaSSRC, mediaPT, 1000, 123456, 30)
+	if err := sendMediaN(mediaWriter, mediaSSRC, mediaPT, 1000, 123456, 30); err != nil {
+		return err
+	}
 	time.Sleep(50 * time.Millisecond)
 	before := fecOut.Load()
 
@@ -74,16 +83,19 @@ func main() {
 		NumFECPackets:   2,
 	})
 
-	_ = sendMediaN(mediaWriter, mediaSSRC, mediaPT, 2000, 223456, 30)
+	if err := sendMediaN(mediaWriter, mediaSSRC, mediaPT, 2000, 223456, 30); err != nil {
+		return err
+	}
 	time.Sleep(50 * time.Millisecond)
 	after := fecOut.Load()
 
 	fmt.Printf("fec packets before enable: %d\n", before)
 	fmt.Printf("fec packets after enable:  %d\n", after)
 	if after <= before {
-		panic("expected FEC output to increase after enabling (k,r)")
+		return errors.New("expected FEC output to increase after enabling (k,r)")
 	}
 	fmt.Println("OK: runtime update changed fec output")
+	return nil
 }
 
 func sendMediaN(w interceptor.RTPWriter, ssrc uint32, pt uint8, startSeq uint16, startTS uint32, n int) error {
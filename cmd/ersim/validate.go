@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lars-sto/error-recovery-simulation/internal/sim"
+)
+
+func validateCmd(args []string) error {
+	fs := newFlagSet("validate", "[file|dir ...]")
+	seed := fs.Int64("seed", 1, "seed for scenarios that don't pin their own")
+	if err := parseFlags(fs, args, true); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"scenarios"}
+	}
+
+	var invalid int
+	for _, p := range paths {
+		n, err := validatePath(p, *seed)
+		if err != nil {
+			invalid++
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		fmt.Printf("ok  %s (%d scenarios)\n", p, n)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d paths invalid", invalid, len(paths))
+	}
+	return nil
+}
+
+// validatePath loads a scenario file or all files of a directory and returns the number of scenarios
+func validatePath(path string, seed int64) (int, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if st.IsDir() {
+		scenarios, err := sim.LoadScenarioDir(path, seed)
+		return len(scenarios), err
+	}
+	if _, err := sim.LoadScenarioFile(path, seed); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/lars-sto/error-recovery-simulation/internal/sim"
+)
+
+// runOrdered fans jobs out to a worker pool and hands results to write in job order
+// Every RunScenario call builds its own link, receiver, interceptor registry and engine and
+// gets its own copy of every loss model (batchCmd runs scenarios whose models can't be copied
+// on one worker), so runs share nothing but the read-only rest of the Scenario; the output
+// is identical to -workers 1
+func runOrdered(
+	jobs []runJob,
+	workers int,
+	run func(runJob) (sim.SummaryRow, error),
+	write func(sim.SummaryRow) error,
+) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	type result struct {
+		idx int
+		row sim.SummaryRow
+		err error
+	}
+
+	idxCh := make(chan int)
+	resCh := make(chan result, workers)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxCh {
+				row, err := run(jobs[idx])
+				resCh <- result{idx: idx, row: row, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(idxCh)
+		for i := range jobs {
+			select {
+			case idxCh <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(resCh)
+	}()
+
+	// ordered collector: buffer out-of-order results until their predecessors are written
+	pending := make(map[int]sim.SummaryRow)
+	next := 0
+	var firstErr error
+	for r := range resCh {
+		if firstErr != nil {
+			continue
+		}
+		if r.err != nil {
+			firstErr = r.err
+			close(done)
+			continue
+		}
+		pending[r.idx] = r.row
+		for {
+			row, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if err := write(row); err != nil {
+				firstErr = err
+				close(done)
+				break
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,254 @@
+// Package report compares the modes of a batch summary CSV: per-mode statistics and
+// paired (same seed) differences against a baseline mode with significance tests
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Row is one run of the summary CSV
+type Row struct {
+	Scenario string
+	Mode     string
+	Seed     int64
+	Values   map[string]float64
+}
+
+// ReadSummary reads a summary CSV as written by sim.SummaryCSVWriter; every column but
+// scenario, mode and seed is a metric
+func ReadSummary(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(r)
+	hdr, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("summary header: %w", err)
+	}
+	col := make(map[string]int, len(hdr))
+	for i, h := range hdr {
+		col[h] = i
+	}
+	for _, k := range []string{"scenario", "mode", "seed"} {
+		if _, ok := col[k]; !ok {
+			return nil, fmt.Errorf("summary: missing column %q", k)
+		}
+	}
+
+	var rows []Row
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		seed, err := strconv.ParseInt(rec[col["seed"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("summary line %d: seed: %w", line, err)
+		}
+		row := Row{
+			Scenario: rec[col["scenario"]],
+			Mode:     rec[col["mode"]],
+			Seed:     seed,
+			Values:   make(map[string]float64, len(hdr)),
+		}
+		for i, h := range hdr {
+			switch h {
+			case "scenario", "mode", "seed":
+				continue
+			}
+			v, err := strconv.ParseFloat(rec[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("summary line %d: %s: %w", line, h, err)
+			}
+			row.Values[h] = v
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Config selects what a report compares
+type Config struct {
+	// Baseline is the mode the others are paired with (same scenario and seed)
+	Baseline string
+	Metrics  []string
+	// Level is the confidence level of the intervals (e.g. 0.95)
+	Level float64
+	// Headline names the outcome and the cost metric of the one-line claims (optional)
+	Headline []string
+}
+
+// Summary describes one metric of one mode
+type Summary struct {
+	Metric string
+	N      int
+	Mean   float64
+	Median float64
+	CILow  float64
+	CIHigh float64
+}
+
+// Comparison is the paired difference mode - baseline of one metric
+type Comparison struct {
+	Mode     string
+	Baseline string
+	Metric   string
+	// Pairs is the number of seeds run in both modes
+	Pairs        int
+	BaselineMean float64
+	ModeMean     float64
+	MeanDiff     float64
+	MedianDiff   float64
+	CILow        float64
+	CIHigh       float64
+	// RelChange is MeanDiff relative to BaselineMean (NaN if that is 0)
+	RelChange float64
+	PWilcoxon float64
+	PTTest    float64
+}
+
+type ScenarioReport struct {
+	Name  string
+	Modes []string
+	// Summaries are per mode, in the order of Modes and Config.Metrics
+	Summaries   map[string][]Summary
+	Comparisons []Comparison
+}
+
+type Report struct {
+	Config    Config
+	Scenarios []ScenarioReport
+}
+
+// Build groups rows by scenario (in file order) and mode and compares every mode with the baseline
+func Build(rows []Row, cfg Config) (*Report, error) {
+	if cfg.Level <= 0 || cfg.Level >= 1 {
+		return nil, fmt.Errorf("confidence level %v out of (0, 1)", cfg.Level)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("summary has no runs")
+	}
+	for _, m := range cfg.Metrics {
+		if _, ok := rows[0].Values[m]; !ok {
+			return nil, fmt.Errorf("unknown metric %q", m)
+		}
+	}
+
+	type key struct{ scenario, mode string }
+	var (
+		scenarios []string
+		modes     = make(map[string][]string)
+		runs      = make(map[key]map[int64]Row)
+	)
+	for _, r := range rows {
+		k := key{r.Scenario, r.Mode}
+		if _, ok := modes[r.Scenario]; !ok {
+			scenarios = append(scenarios, r.Scenario)
+		}
+		if runs[k] == nil {
+			runs[k] = make(map[int64]Row)
+			modes[r.Scenario] = append(modes[r.Scenario], r.Mode)
+		}
+		if _, dup := runs[k][r.Seed]; dup {
+			return nil, fmt.Errorf("%s/%s: seed %d appears twice", r.Scenario, r.Mode, r.Seed)
+		}
+		runs[k][r.Seed] = r
+	}
+
+	rep := &Report{Config: cfg}
+	for _, sc := range scenarios {
+		sr := ScenarioReport{Name: sc, Modes: modes[sc], Summaries: make(map[string][]Summary)}
+		for _, mode := range sr.Modes {
+			for _, metric := range cfg.Metrics {
+				xs := values(runs[key{sc, mode}], nil, metric)
+				lo, hi := meanCI(xs, cfg.Level)
+				sr.Summaries[mode] = append(sr.Summaries[mode], Summary{
+					Metric: metric,
+					N:      len(xs),
+					Mean:   mean(xs),
+					Median: median(xs),
+					CILow:  lo,
+					CIHigh: hi,
+				})
+			}
+		}
+
+		base, ok := runs[key{sc, cfg.Baseline}]
+		if ok {
+			for _, mode := range sr.Modes {
+				if mode == cfg.Baseline {
+					continue
+				}
+				cur := runs[key{sc, mode}]
+				seeds := pairedSeeds(base, cur)
+				for _, metric := range cfg.Metrics {
+					sr.Comparisons = append(sr.Comparisons, compare(mode, cfg, metric, values(base, seeds, metric), values(cur, seeds, metric)))
+				}
+			}
+		}
+		rep.Scenarios = append(rep.Scenarios, sr)
+	}
+	return rep, nil
+}
+
+func compare(mode string, cfg Config, metric string, base, cur []float64) Comparison {
+	diffs := make([]float64, len(base))
+	for i := range base {
+		diffs[i] = cur[i] - base[i]
+	}
+	lo, hi := meanCI(diffs, cfg.Level)
+	c := Comparison{
+		Mode:         mode,
+		Baseline:     cfg.Baseline,
+		Metric:       metric,
+		Pairs:        len(diffs),
+		BaselineMean: mean(base),
+		ModeMean:     mean(cur),
+		MeanDiff:     mean(diffs),
+		MedianDiff:   median(diffs),
+		CILow:        lo,
+		CIHigh:       hi,
+		RelChange:    math.NaN(),
+		PWilcoxon:    math.NaN(),
+		PTTest:       pairedTTest(diffs),
+	}
+	if len(diffs) > 0 {
+		c.PWilcoxon = wilcoxonSignedRank(diffs)
+	}
+	if c.BaselineMean != 0 {
+		c.RelChange = c.MeanDiff / c.BaselineMean
+	}
+	return c
+}
+
+// pairedSeeds are the seeds run in both modes, sorted
+func pairedSeeds(a, b map[int64]Row) []int64 {
+	seeds := []int64{}
+	for s := range a {
+		if _, ok := b[s]; ok {
+			seeds = append(seeds, s)
+		}
+	}
+	sort.Slice(seeds, func(i, j int) bool { return seeds[i] < seeds[j] })
+	return seeds
+}
+
+// values of metric for seeds (nil: all runs, by seed)
+func values(runs map[int64]Row, seeds []int64, metric string) []float64 {
+	if seeds == nil {
+		for s := range runs {
+			seeds = append(seeds, s)
+		}
+		sort.Slice(seeds, func(i, j int) bool { return seeds[i] < seeds[j] })
+	}
+	out := make([]float64, len(seeds))
+	for i, s := range seeds {
+		out[i] = runs[s].Values[metric]
+	}
+	return out
+}
@@ -0,0 +1,245 @@
+package report
+
+import (
+	"math"
+	"sort"
+)
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return math.NaN()
+	}
+	var s float64
+	for _, x := range xs {
+		s += x
+	}
+	return s / float64(len(xs))
+}
+
+func median(xs []float64) float64 {
+	n := len(xs)
+	if n == 0 {
+		return math.NaN()
+	}
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// stddev is the sample standard deviation (n-1)
+func stddev(xs []float64) float64 {
+	n := len(xs)
+	if n < 2 {
+		return math.NaN()
+	}
+	m := mean(xs)
+	var ss float64
+	for _, x := range xs {
+		ss += (x - m) * (x - m)
+	}
+	return math.Sqrt(ss / float64(n-1))
+}
+
+// meanCI is the Student-t confidence interval of the mean at level (e.g. 0.95)
+func meanCI(xs []float64, level float64) (lo, hi float64) {
+	n := len(xs)
+	m := mean(xs)
+	if n < 2 {
+		return math.NaN(), math.NaN()
+	}
+	half := tQuantile(1-(1-level)/2, float64(n-1)) * stddev(xs) / math.Sqrt(float64(n))
+	return m - half, m + half
+}
+
+// pairedTTest is the two-sided p-value of the one-sample t-test of diffs against 0
+func pairedTTest(diffs []float64) float64 {
+	n := len(diffs)
+	if n < 2 {
+		return math.NaN()
+	}
+	sd := stddev(diffs)
+	m := mean(diffs)
+	if sd == 0 {
+		if m == 0 {
+			return 1
+		}
+		return 0
+	}
+	t := m / (sd / math.Sqrt(float64(n)))
+	return 2 * (1 - tCDF(math.Abs(t), float64(n-1)))
+}
+
+// wilcoxonSignedRank is the two-sided p-value of the Wilcoxon signed-rank test of diffs
+// against 0. Zero differences are dropped; the distribution is exact for up to 50
+// differences without ties, otherwise the normal approximation with tie and continuity
+// correction is used
+func wilcoxonSignedRank(diffs []float64) float64 {
+	type rd struct{ abs, sign float64 }
+	var ds []rd
+	for _, d := range diffs {
+		if d != 0 && !math.IsNaN(d) {
+			ds = append(ds, rd{math.Abs(d), math.Copysign(1, d)})
+		}
+	}
+	n := len(ds)
+	if n == 0 {
+		return 1
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].abs < ds[j].abs })
+
+	// average ranks over ties; values within float noise of CSV round trips are tied
+	ranks := make([]float64, n)
+	var tieTerm float64
+	ties := false
+	for i := 0; i < n; {
+		j := i + 1
+		for j < n && ds[j].abs-ds[i].abs <= 1e-9*ds[j].abs {
+			j++
+		}
+		r := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			ranks[k] = r
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieTerm += t*t*t - t
+		}
+		i = j
+	}
+	var wPlus float64
+	for i, d := range ds {
+		if d.sign > 0 {
+			wPlus += ranks[i]
+		}
+	}
+
+	if !ties && n <= 50 {
+		return wilcoxonExact(n, int(wPlus))
+	}
+	nf := float64(n)
+	mu := nf * (nf + 1) / 4
+	sigma := math.Sqrt(nf*(nf+1)*(2*nf+1)/24 - tieTerm/48)
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Abs(wPlus-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return math.Min(1, 2*(1-normCDF(z)))
+}
+
+// wilcoxonExact is the two-sided p-value of W+ = w with n untied ranks
+func wilcoxonExact(n, w int) float64 {
+	top := n * (n + 1) / 2
+	// counts[s] = number of sign assignments with rank sum s
+	counts := make([]float64, top+1)
+	counts[0] = 1
+	for r := 1; r <= n; r++ {
+		for s := top; s >= r; s-- {
+			counts[s] += counts[s-r]
+		}
+	}
+	total := math.Pow(2, float64(n))
+	lo := w
+	if top-w < lo {
+		lo = top - w
+	}
+	var tail float64
+	for s := 0; s <= lo; s++ {
+		tail += counts[s]
+	}
+	return math.Min(1, 2*tail/total)
+}
+
+func normCDF(z float64) float64 { return 0.5 * math.Erfc(-z/math.Sqrt2) }
+
+// tCDF is the CDF of Student's t distribution with df degrees of freedom
+func tCDF(t, df float64) float64 {
+	x := df / (df + t*t)
+	p := 0.5 * incBeta(df/2, 0.5, x)
+	if t > 0 {
+		return 1 - p
+	}
+	return p
+}
+
+// tQuantile inverts tCDF by bisection
+func tQuantile(p, df float64) float64 {
+	lo, hi := -1e3, 1e3
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		if tCDF(mid, df) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// incBeta is the regularized incomplete beta function I_x(a, b) (continued fraction)
+func incBeta(a, b, x float64) float64 {
+	switch {
+	case x <= 0:
+		return 0
+	case x >= 1:
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaCF(b, a, 1-x)/b
+	}
+	return front * betaCF(a, b, x) / a
+}
+
+// betaCF evaluates the continued fraction of incBeta (modified Lentz)
+func betaCF(a, b, x float64) float64 {
+	const (
+		eps  = 1e-14
+		tiny = 1e-300
+	)
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= 300; m++ {
+		mf := float64(m)
+		num := mf * (b - mf) * x / ((a + 2*mf - 1) * (a + 2*mf))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+
+		num = -(a + mf) * (a + b + mf) * x / ((a + 2*mf) * (a + 2*mf + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,200 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// WriteMarkdown writes per scenario a table of the modes, a table of the paired differences
+// and, with Config.Headline set, a one-line claim per mode
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	pct := fmt.Sprintf("%g%%", r.Config.Level*100)
+	p := &printer{w: w}
+	for _, sc := range r.Scenarios {
+		p.printf("## %s\n\n", sc.Name)
+		p.printf("| mode | metric | n | mean | %s CI | median |\n", pct)
+		p.printf("|---|---|---:|---:|---|---:|\n")
+		for _, mode := range sc.Modes {
+			for _, s := range sc.Summaries[mode] {
+				p.printf("| %s | %s | %d | %s | %s | %s |\n", mode, s.Metric, s.N, fnum(s.Mean), fci(s.CILow, s.CIHigh), fnum(s.Median))
+			}
+		}
+		p.printf("\n")
+
+		if len(sc.Comparisons) == 0 {
+			p.printf("No runs of baseline %s.\n\n", r.Config.Baseline)
+			continue
+		}
+		p.printf("Paired by seed against %s (difference = mode - baseline):\n\n", r.Config.Baseline)
+		p.printf("| mode | metric | pairs | mean diff | %s CI | median diff | change | p (Wilcoxon) | p (t-test) |\n", pct)
+		p.printf("|---|---|---:|---:|---|---:|---:|---:|---:|\n")
+		for _, c := range sc.Comparisons {
+			p.printf("| %s | %s | %d | %s | %s | %s | %s | %s | %s |\n",
+				c.Mode, c.Metric, c.Pairs, fnum(c.MeanDiff), fci(c.CILow, c.CIHigh), fnum(c.MedianDiff),
+				fpct(c.RelChange), fp(c.PWilcoxon), fp(c.PTTest))
+		}
+		p.printf("\n")
+
+		for _, line := range r.headlines(sc) {
+			p.printf("> %s\n", line)
+		}
+		if len(r.headlines(sc)) > 0 {
+			p.printf("\n")
+		}
+	}
+	return p.err
+}
+
+// headlines are "<mode> vs <baseline>: <outcome> a -> b (change, p) at <cost> a -> b (change)"
+func (r *Report) headlines(sc ScenarioReport) []string {
+	if len(r.Config.Headline) != 2 {
+		return nil
+	}
+	outcome, cost := r.Config.Headline[0], r.Config.Headline[1]
+	find := func(mode, metric string) (Comparison, bool) {
+		for _, c := range sc.Comparisons {
+			if c.Mode == mode && c.Metric == metric {
+				return c, true
+			}
+		}
+		return Comparison{}, false
+	}
+	var out []string
+	for _, mode := range sc.Modes {
+		o, ok1 := find(mode, outcome)
+		c, ok2 := find(mode, cost)
+		if !ok1 || !ok2 {
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s vs %s: %s %s -> %s (%s, p=%s) at %s %s -> %s (%s)",
+			mode, r.Config.Baseline,
+			outcome, fnum(o.BaselineMean), fnum(o.ModeMean), fpct(o.RelChange), fp(o.PWilcoxon),
+			cost, fnum(c.BaselineMean), fnum(c.ModeMean), fpct(c.RelChange)))
+	}
+	return out
+}
+
+// WriteCSV writes one row per mode and metric (kind summary) and per paired comparison (kind paired)
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	hdr := []string{
+		"scenario",
+		"kind",
+		"mode",
+		"baseline",
+		"metric",
+		"n",
+		"mean",
+		"median",
+		"ci_low",
+		"ci_high",
+		"baseline_mean",
+		"rel_change",
+		"p_wilcoxon",
+		"p_ttest",
+	}
+	if err := cw.Write(hdr); err != nil {
+		return err
+	}
+	for _, sc := range r.Scenarios {
+		for _, mode := range sc.Modes {
+			for _, s := range sc.Summaries[mode] {
+				row := []string{
+					sc.Name,
+					"summary",
+					mode,
+					"",
+					s.Metric,
+					strconv.Itoa(s.N),
+					fcsv(s.Mean),
+					fcsv(s.Median),
+					fcsv(s.CILow),
+					fcsv(s.CIHigh),
+					"",
+					"",
+					"",
+					"",
+				}
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+		// for paired rows mean, median and the CI describe the differences
+		for _, c := range sc.Comparisons {
+			row := []string{
+				sc.Name,
+				"paired",
+				c.Mode,
+				c.Baseline,
+				c.Metric,
+				strconv.Itoa(c.Pairs),
+				fcsv(c.MeanDiff),
+				fcsv(c.MedianDiff),
+				fcsv(c.CILow),
+				fcsv(c.CIHigh),
+				fcsv(c.BaselineMean),
+				fcsv(c.RelChange),
+				fcsv(c.PWilcoxon),
+				fcsv(c.PTTest),
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func fnum(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+func fci(lo, hi float64) string {
+	if math.IsNaN(lo) {
+		return "-"
+	}
+	return "[" + fnum(lo) + ", " + fnum(hi) + "]"
+}
+
+func fpct(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", v*100)
+}
+
+func fp(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "-"
+	case v < 0.001:
+		return "<0.001"
+	}
+	return fmt.Sprintf("%.3f", v)
+}
+
+func fcsv(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
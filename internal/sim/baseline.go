@@ -0,0 +1,146 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+)
+
+// DefaultOracleTarget is the residual media loss the oracle aims for when RunOptions.OracleTarget is 0
+const DefaultOracleTarget = 0.01
+
+// oraclePolicy picks the smallest R that meets its target at the true loss: the loss models'
+// rate for the coming window if they know it, else the wire loss of the window just ended,
+// plus that window's congestion drops
+type oraclePolicy struct {
+	env PolicyEnv
+}
+
+func newOraclePolicy(env PolicyEnv) (Policy, error) {
+	return &oraclePolicy{env: env}, nil
+}
+
+func (p *oraclePolicy) Decide(in PolicyInput) (recovery.PolicyDecision, bool, error) {
+	wire, lookahead := pathLossRate(p.env.hops, in.T, in.T+in.Window)
+	if !lookahead {
+		wire = in.TrueLoss - in.CongestionLoss
+	}
+	loss := clamp01(1 - (1-wire)*(1-in.CongestionLoss))
+	k := p.env.Scenario.K
+	d := fecDecision(k, oracleR(k, p.env.MaxFECPackets, loss, p.env.oracleTarget), oracleReason(loss, lookahead))
+	return d, changed(in.Current, d.FEC), nil
+}
+
+func (p *oraclePolicy) Close() error { return nil }
+
+// proportionalPolicy protects protection_scale x the reported loss, without hysteresis
+type proportionalPolicy struct {
+	env PolicyEnv
+}
+
+func newProportionalPolicy(env PolicyEnv) (Policy, error) {
+	return &proportionalPolicy{env: env}, nil
+}
+
+func (p *proportionalPolicy) Decide(in PolicyInput) (recovery.PolicyDecision, bool, error) {
+	k := p.env.Scenario.K
+	d := fecDecision(k, proportionalR(k, p.env.MaxFECPackets, in.Stats.LossRate, p.env.Engine.ProtectionScale), "loss_proportional")
+	return d, changed(in.Current, d.FEC), nil
+}
+
+func (p *proportionalPolicy) Close() error { return nil }
+
+// changed reports whether d differs from the current configuration; like the engine,
+// the baselines only publish changes
+func changed(cur, d recovery.FECDecision) bool {
+	return d.Enabled != cur.Enabled || d.NumMediaPackets != cur.NumMediaPackets || d.NumFECPackets != cur.NumFECPackets
+}
+
+// maxFECPackets is the largest R the baselines use: K x max_overhead of the controller config
+func maxFECPackets(k uint32, cfg recovery.Config) uint32 {
+	return uint32(math.Floor(float64(k)*cfg.MaxOverhead + 1e-9))
+}
+
+// oracleR is the smallest R in 0..maxR whose expected residual loss at loss p is within
+// target (maxR if none is)
+func oracleR(k, maxR uint32, p, target float64) uint32 {
+	for r := uint32(0); r < maxR; r++ {
+		if residualLoss(k, r, p) <= target {
+			return r
+		}
+	}
+	return maxR
+}
+
+// residualLoss is the expected media loss left by a K+R block that repairs up to R losses
+// under independent loss p; FlexFEC's masks repair less, so this is a lower bound
+func residualLoss(k, r uint32, p float64) float64 {
+	n := int(k + r)
+	if n == 0 {
+		return p
+	}
+	var res float64
+	for lost := int(r) + 1; lost <= n; lost++ {
+		res += binomPMF(n, lost, p) * float64(lost) / float64(n)
+	}
+	return res
+}
+
+func binomPMF(n, k int, p float64) float64 {
+	lnN, _ := math.Lgamma(float64(n + 1))
+	lnK, _ := math.Lgamma(float64(k + 1))
+	lnNK, _ := math.Lgamma(float64(n - k + 1))
+	return math.Exp(lnN-lnK-lnNK) * math.Pow(p, float64(k)) * math.Pow(1-p, float64(n-k))
+}
+
+// proportionalR protects scale x loss of the media rate, capped at maxR
+func proportionalR(k, maxR uint32, loss, scale float64) uint32 {
+	r := uint32(math.Ceil(loss*scale*float64(k) - 1e-9))
+	if r > maxR {
+		return maxR
+	}
+	return r
+}
+
+// fecDecision is a baseline decision in the form the engine publishes
+func fecDecision(k, r uint32, reason string) recovery.PolicyDecision {
+	return recovery.PolicyDecision{FEC: recovery.FECDecision{
+		Enabled:         r > 0,
+		NumMediaPackets: k,
+		NumFECPackets:   r,
+		TargetOverhead:  overhead(k, r),
+		Reason:          reason,
+	}}
+}
+
+// pathLossRate combines the hops' loss rates over [from, to); false unless every hop's
+// loss model knows its rate ahead of time
+func pathLossRate(hops []HopSpec, from, to time.Duration) (float64, bool) {
+	pass := 1.0
+	for _, h := range hops {
+		if h.Link.Loss == nil {
+			continue
+		}
+		lr, ok := h.Link.Loss.(lossRater)
+		if !ok {
+			return 0, false
+		}
+		p, ok := lr.LossRate(from, to)
+		if !ok {
+			return 0, false
+		}
+		pass *= 1 - p
+	}
+	return 1 - pass, true
+}
+
+// oracleReason documents where the oracle's loss came from
+func oracleReason(loss float64, lookahead bool) string {
+	src := "measured"
+	if lookahead {
+		src = "lookahead"
+	}
+	return fmt.Sprintf("oracle: %s loss %.4f", src, loss)
+}
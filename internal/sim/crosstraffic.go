@@ -0,0 +1,403 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// CrossTrafficKind selects the behaviour of a competing flow
+type CrossTrafficKind string
+
+const (
+	// CrossCBR sends PacketBytes packets at a constant RateBps
+	CrossCBR CrossTrafficKind = "cbr"
+	// CrossParetoOnOff sends at RateBps during Pareto-distributed on periods and pauses in between
+	CrossParetoOnOff CrossTrafficKind = "pareto_onoff"
+	// CrossAIMD is a window-based TCP-like flow: slow start, +1 packet per RTT, halving on loss
+	CrossAIMD CrossTrafficKind = "aimd"
+)
+
+const (
+	defaultCrossPacketBytes = 1200
+	defaultParetoMean       = 500 * time.Millisecond
+	defaultParetoShape      = 1.5
+	defaultAIMDInitWindow   = 10
+	defaultAIMDMaxWindow    = 1000
+
+	// crossSSRCBase numbers flows without an explicit SSRC
+	crossSSRCBase = 0xc0550000
+)
+
+// CrossTrafficSpec describes one competing flow on the forward link
+// Its packets take queue space and serialization time but never reach the Receiver
+type CrossTrafficSpec struct {
+	Name string
+	Kind CrossTrafficKind
+
+	// Hop is the index of the path hop the flow shares (0 without Scenario.Path);
+	// its packets leave the path after that hop
+	Hop int
+
+	// SSRC keys the flow on the link (FQ-CoDel flow, loss model state); 0 picks one per flow
+	SSRC        uint32
+	PacketBytes int // default 1200
+
+	// Start and Stop bound the active period relative to the run start (Stop 0 = until the end)
+	Start time.Duration
+	Stop  time.Duration
+
+	// RateBps is the CBR rate, the rate during Pareto on periods and the AIMD pacing limit (0 = unpaced)
+	RateBps float64
+
+	// Pareto on/off period means (default 500ms) and shape (default 1.5, must be > 1)
+	OnMean      time.Duration
+	OffMean     time.Duration
+	ParetoShape float64
+
+	// AIMD congestion window bounds in packets (default 10 and 1000)
+	InitialWindow int
+	MaxWindow     int
+	// AckDelay is the return path delay of acks and loss signals (default: the hop's base delay)
+	AckDelay time.Duration
+}
+
+// CrossFlowSample is the per-flow state reported with every TimeSample
+type CrossFlowSample struct {
+	Name string
+	// SentBps and ThroughputBps are offered and delivered rates over the last stats window
+	SentBps       float64
+	ThroughputBps float64
+	Dropped       int64
+	Window        float64 // AIMD congestion window in packets (0 for other kinds)
+}
+
+// CrossFlowResult summarises a competing flow over the whole run
+type CrossFlowResult struct {
+	Name           string
+	Kind           CrossTrafficKind
+	SentPkts       int64
+	SentBytes      int64
+	DeliveredPkts  int64
+	DeliveredBytes int64
+	DroppedPkts    int64
+	ThroughputBps  float64
+}
+
+type crossFeedback struct {
+	at     time.Time
+	sentAt time.Time
+	lost   bool
+}
+
+type crossFlow struct {
+	spec        CrossTrafficSpec
+	ssrc        uint32
+	seq         uint16
+	size        int
+	interval    time.Duration
+	start, stop time.Time
+	rng         *rand.Rand
+
+	nextSend time.Time
+
+	// pareto on/off
+	onUntil time.Time
+
+	// aimd
+	cwnd, ssthresh float64
+	maxWindow      float64
+	inflight       int
+	recoverFrom    time.Time
+	ackDelay       time.Duration
+	lossDelay      time.Duration
+	feedback       []crossFeedback // sorted by at
+	sentAt         map[uint16]time.Time
+
+	sentPkts, sentBytes           int64
+	deliveredPkts, deliveredBytes int64
+	droppedPkts                   int64
+	winSent, winDelivered         int64
+}
+
+// crossTraffic drives all competing flows of a run
+type crossTraffic struct {
+	flows  []*crossFlow
+	bySSRC map[uint32]*crossFlow
+	end    time.Time
+}
+
+// newCrossTraffic sets up the flows; hopDelay is the base one-way delay of every path hop
+func newCrossTraffic(specs []CrossTrafficSpec, start, end time.Time, seed int64, hopDelay []time.Duration) *crossTraffic {
+	if len(specs) == 0 {
+		return nil
+	}
+	c := &crossTraffic{bySSRC: make(map[uint32]*crossFlow, len(specs)), end: end}
+	for i, spec := range specs {
+		f := &crossFlow{
+			spec:  spec,
+			ssrc:  spec.SSRC,
+			size:  spec.PacketBytes,
+			start: start.Add(spec.Start),
+			stop:  end,
+			rng:   rand.New(rand.NewSource(int64(splitmix64(uint64(seed) ^ 0x63726f7373 ^ uint64(i)<<32)))),
+		}
+		if f.spec.Name == "" {
+			f.spec.Name = crossFlowName(spec.Kind, i)
+		}
+		if f.ssrc == 0 {
+			f.ssrc = crossSSRCBase + uint32(i)
+		}
+		if f.size <= 0 {
+			f.size = defaultCrossPacketBytes
+		}
+		if spec.Stop > 0 && start.Add(spec.Stop).Before(end) {
+			f.stop = start.Add(spec.Stop)
+		}
+		if spec.RateBps > 0 {
+			f.interval = time.Duration(float64(f.size) * 8 / spec.RateBps * float64(time.Second))
+		}
+		f.nextSend = f.start
+		if f.interval == 0 && spec.Kind != CrossAIMD {
+			f.nextSend = f.stop // a rate is required; see CrossTrafficSpec.RateBps
+		}
+
+		switch spec.Kind {
+		case CrossParetoOnOff:
+			f.onUntil = f.start.Add(f.pareto(spec.OnMean))
+		case CrossAIMD:
+			f.cwnd = float64(spec.InitialWindow)
+			if f.cwnd <= 0 {
+				f.cwnd = defaultAIMDInitWindow
+			}
+			f.maxWindow = float64(spec.MaxWindow)
+			if f.maxWindow <= 0 {
+				f.maxWindow = defaultAIMDMaxWindow
+			}
+			f.ssthresh = f.maxWindow
+			linkDelay := hopDelay[spec.Hop]
+			f.ackDelay = spec.AckDelay
+			if f.ackDelay <= 0 {
+				f.ackDelay = linkDelay
+			}
+			// a drop is noticed about one round trip after it happened
+			f.lossDelay = linkDelay + f.ackDelay
+			f.sentAt = make(map[uint16]time.Time)
+		}
+		c.flows = append(c.flows, f)
+		c.bySSRC[f.ssrc] = f
+	}
+	return c
+}
+
+// pareto draws a Pareto distributed duration with the given mean
+func (f *crossFlow) pareto(mean time.Duration) time.Duration {
+	if mean <= 0 {
+		mean = defaultParetoMean
+	}
+	shape := f.spec.ParetoShape
+	if shape <= 1 {
+		shape = defaultParetoShape
+	}
+	xm := float64(mean) * (shape - 1) / shape
+	u := 1 - f.rng.Float64() // (0,1]
+	return time.Duration(xm / math.Pow(u, 1/shape))
+}
+
+// canSend reports when the flow wants to send next (false: waiting for feedback or done)
+func (f *crossFlow) canSend() (time.Time, bool) {
+	if !f.nextSend.Before(f.stop) {
+		return time.Time{}, false
+	}
+	if f.spec.Kind == CrossAIMD && f.inflight >= int(f.cwnd) {
+		return time.Time{}, false
+	}
+	return f.nextSend, true
+}
+
+// Next returns the earliest send or feedback time of any flow within the run
+func (c *crossTraffic) Next() (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	var (
+		next  time.Time
+		found bool
+	)
+	for _, f := range c.flows {
+		if t, ok := f.canSend(); ok && (!found || t.Before(next)) {
+			next, found = t, true
+		}
+		if len(f.feedback) > 0 && (!found || f.feedback[0].at.Before(next)) {
+			next, found = f.feedback[0].at, true
+		}
+	}
+	if found && next.After(c.end) {
+		return time.Time{}, false
+	}
+	return next, found
+}
+
+// Run handles all feedback and sends due at now
+func (c *crossTraffic) Run(now time.Time, path *Path) {
+	for _, f := range c.flows {
+		for len(f.feedback) > 0 && !f.feedback[0].at.After(now) {
+			fb := f.feedback[0]
+			f.feedback = f.feedback[1:]
+			f.onFeedback(fb)
+		}
+		for {
+			t, ok := f.canSend()
+			if !ok || t.After(now) {
+				break
+			}
+			out := path.SendCross(f.spec.Hop, f.ssrc, f.seq, f.size, now)
+			if f.sentAt != nil {
+				f.sentAt[f.seq] = now
+				f.inflight++
+			}
+			f.seq++
+			f.sentPkts++
+			f.sentBytes += int64(f.size)
+			f.winSent += int64(f.size)
+			if out.Dropped {
+				f.onDrop(f.seq-1, now)
+			}
+			f.scheduleNext(now)
+		}
+	}
+}
+
+func (f *crossFlow) scheduleNext(now time.Time) {
+	switch f.spec.Kind {
+	case CrossParetoOnOff:
+		f.nextSend = now.Add(f.interval)
+		for !f.nextSend.Before(f.onUntil) {
+			// the on period is over: pause, then start a new on period
+			on := f.onUntil.Add(f.pareto(f.spec.OffMean))
+			f.onUntil = on.Add(f.pareto(f.spec.OnMean))
+			f.nextSend = on
+		}
+	default:
+		// CBR and AIMD (unpaced AIMD sends as fast as the window allows)
+		f.nextSend = f.nextSend.Add(f.interval)
+		if f.nextSend.Before(now) {
+			f.nextSend = now
+		}
+	}
+}
+
+func (f *crossFlow) addFeedback(fb crossFeedback) {
+	i := sort.Search(len(f.feedback), func(i int) bool { return f.feedback[i].at.After(fb.at) })
+	f.feedback = append(f.feedback, crossFeedback{})
+	copy(f.feedback[i+1:], f.feedback[i:])
+	f.feedback[i] = fb
+}
+
+func (f *crossFlow) onFeedback(fb crossFeedback) {
+	f.inflight--
+	if fb.lost {
+		// one window reduction per round trip: losses of packets sent before the last cut are ignored
+		if !fb.sentAt.Before(f.recoverFrom) {
+			f.ssthresh = math.Max(f.cwnd/2, 2)
+			f.cwnd = f.ssthresh
+			f.recoverFrom = fb.at
+		}
+		return
+	}
+	if f.cwnd < f.ssthresh {
+		f.cwnd++
+	} else {
+		f.cwnd += 1 / f.cwnd
+	}
+	f.cwnd = math.Min(f.cwnd, f.maxWindow)
+}
+
+func (f *crossFlow) onDrop(seq uint16, at time.Time) {
+	f.droppedPkts++
+	if f.sentAt == nil {
+		return
+	}
+	sent, ok := f.sentAt[seq]
+	if !ok {
+		return
+	}
+	delete(f.sentAt, seq)
+	f.addFeedback(crossFeedback{at: at.Add(f.lossDelay), sentAt: sent, lost: true})
+}
+
+// OnDelivered accounts a cross packet that left the link
+func (c *crossTraffic) OnDelivered(meta *PacketMeta, at time.Time) {
+	f, ok := c.bySSRC[meta.SSRC]
+	if !ok {
+		return
+	}
+	f.deliveredPkts++
+	f.deliveredBytes += int64(meta.SizeBytes)
+	f.winDelivered += int64(meta.SizeBytes)
+	if f.sentAt == nil {
+		return
+	}
+	sent, ok := f.sentAt[meta.Seq]
+	if !ok {
+		return
+	}
+	delete(f.sentAt, meta.Seq)
+	f.addFeedback(crossFeedback{at: at.Add(f.ackDelay), sentAt: sent})
+}
+
+// OnDrop accounts a cross packet dropped by the link after it was queued
+func (c *crossTraffic) OnDrop(meta PacketMeta, at time.Time) {
+	if f, ok := c.bySSRC[meta.SSRC]; ok {
+		f.onDrop(meta.Seq, at)
+	}
+}
+
+// Sample reports per-flow rates over the last window and starts a new one
+func (c *crossTraffic) Sample(window time.Duration) []CrossFlowSample {
+	if c == nil {
+		return nil
+	}
+	out := make([]CrossFlowSample, 0, len(c.flows))
+	for _, f := range c.flows {
+		s := CrossFlowSample{Name: f.spec.Name, Dropped: f.droppedPkts}
+		if sec := window.Seconds(); sec > 0 {
+			s.SentBps = float64(f.winSent*8) / sec
+			s.ThroughputBps = float64(f.winDelivered*8) / sec
+		}
+		if f.spec.Kind == CrossAIMD {
+			s.Window = f.cwnd
+		}
+		f.winSent, f.winDelivered = 0, 0
+		out = append(out, s)
+	}
+	return out
+}
+
+func (c *crossTraffic) Results(d time.Duration) []CrossFlowResult {
+	if c == nil {
+		return nil
+	}
+	out := make([]CrossFlowResult, 0, len(c.flows))
+	for _, f := range c.flows {
+		r := CrossFlowResult{
+			Name:           f.spec.Name,
+			Kind:           f.spec.Kind,
+			SentPkts:       f.sentPkts,
+			SentBytes:      f.sentBytes,
+			DeliveredPkts:  f.deliveredPkts,
+			DeliveredBytes: f.deliveredBytes,
+			DroppedPkts:    f.droppedPkts,
+		}
+		if d > 0 {
+			r.ThroughputBps = float64(f.deliveredBytes*8) / d.Seconds()
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// crossFlowName is the default name of the i-th flow
+func crossFlowName(kind CrossTrafficKind, i int) string { return fmt.Sprintf("%s_%d", kind, i) }
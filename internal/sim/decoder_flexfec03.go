@@ -23,17 +23,22 @@ var (
 )
 
 // FlexFEC03Decoder is a minimal FlexFEC-03 decoder used for simulation
-// It attempts recovery when exactly one protected media packet is missing
+// It attempts recovery when exactly one protected media packet is missing;
+// in FECDecoderGaussian mode it also solves overlapping FEC packets jointly
 type FlexFEC03Decoder struct {
 	logger              logging.LeveledLogger
 	ssrc                uint32
 	protectedStreamSSRC uint32
+	mode                FECDecoderMode
 
 	maxMediaPackets int
 	maxFECPackets   int
 
 	recoveredPackets   []rtp.Packet
 	receivedFECPackets []fecPacketState
+	// jointPending is set when a FEC packet or a missing protected packet arrived since the
+	// last joint recovery; otherwise the system is unchanged and solving it again finds nothing
+	jointPending bool
 }
 
 func NewFlexFEC03Decoder(ssrc uint32, protectedStreamSSRC uint32) *FlexFEC03Decoder {
@@ -41,6 +46,7 @@ func NewFlexFEC03Decoder(ssrc uint32, protectedStreamSSRC uint32) *FlexFEC03Deco
 		logger:              logging.NewDefaultLoggerFactory().NewLogger("fec_decoder"),
 		ssrc:                ssrc,
 		protectedStreamSSRC: protectedStreamSSRC,
+		mode:                FECDecoderPeeling,
 		maxMediaPackets:     200,
 		maxFECPackets:       200,
 		recoveredPackets:    make([]rtp.Packet, 0),
@@ -48,6 +54,9 @@ func NewFlexFEC03Decoder(ssrc uint32, protectedStreamSSRC uint32) *FlexFEC03Deco
 	}
 }
 
+// SetMode switches between peeling-only and full (Gaussian elimination) recovery
+func (d *FlexFEC03Decoder) SetMode(m FECDecoderMode) { d.mode = m }
+
 // Push inserts a packet (media or fec) and returns newly recovered media packets (if any)
 func (d *FlexFEC03Decoder) Push(receivedPacket rtp.Packet) []rtp.Packet {
 	// Buffer reset heuristic on large gaps (media stream only)
@@ -72,7 +81,7 @@ func (d *FlexFEC03Decoder) insertPacket(receivedPkt rtp.Packet) {
 	if len(d.receivedFECPackets) > 0 && receivedPkt.SSRC == d.ssrc {
 		toRemove := 0
 		for _, fecPkt := range d.receivedFECPackets {
-			if abs(int(receivedPkt.SequenceNumber)-int(fecPkt.packet.SequenceNumber)) > 0x3fff {
+			if seqDiff(receivedPkt.SequenceNumber, fecPkt.packet.SequenceNumber) > 0x3fff {
 				toRemove++
 			} else {
 				break
@@ -114,6 +123,9 @@ func (d *FlexFEC03Decoder) updateCoveringFecPackets(receivedPkt rtp.Packet) {
 		fecPkt := &d.receivedFECPackets[i]
 		for _, protectedPacket := range fecPkt.protectedPackets {
 			if protectedPacket.seq == receivedPkt.SequenceNumber {
+				if protectedPacket.packet == nil {
+					d.jointPending = true
+				}
 				protectedPacket.packet = &receivedPkt
 			}
 		}
@@ -166,7 +178,9 @@ func (d *FlexFEC03Decoder) insertFECPacket(fecPkt rtp.Packet) { //nolint:cyclop
 		case isNewerSeq(d.recoveredPackets[recoveredIt].SequenceNumber, protectedSeqs[protectedSeqIt]):
 			recoveredIt++
 		default:
-			protectedPackets = append(protectedPackets, &protectedPacket{seq: protectedSeqs[protectedSeqIt], packet: &d.recoveredPackets[recoveredIt]})
+			// copy: recoveredPackets is re-sorted in place when packets arrive out of order
+			pkt := d.recoveredPackets[recoveredIt]
+			protectedPackets = append(protectedPackets, &protectedPacket{seq: protectedSeqs[protectedSeqIt], packet: &pkt})
 			protectedSeqIt++
 			recoveredIt++
 		}
@@ -186,6 +200,7 @@ func (d *FlexFEC03Decoder) insertFECPacket(fecPkt rtp.Packet) { //nolint:cyclop
 	sort.Slice(d.receivedFECPackets, func(i, j int) bool {
 		return isNewerSeq(d.receivedFECPackets[i].packet.SequenceNumber, d.receivedFECPackets[j].packet.SequenceNumber)
 	})
+	d.jointPending = true
 
 	if len(d.receivedFECPackets) > d.maxFECPackets {
 		d.receivedFECPackets = d.receivedFECPackets[1:]
@@ -237,6 +252,10 @@ func (d *FlexFEC03Decoder) attemptRecovery() []rtp.Packet {
 		}
 	}
 
+	if d.mode == FECDecoderGaussian && d.jointPending {
+		recoveredPackets = append(recoveredPackets, d.attemptJointRecovery()...)
+	}
+
 	return recoveredPackets
 }
 
@@ -406,13 +425,6 @@ func seqDiff(a, b uint16) uint16 {
 	return min(a-b, b-a)
 }
 
-func abs(x int) int {
-	if x >= 0 {
-		return x
-	}
-	return -x
-}
-
 func isNewerSeq(prevValue, value uint16) bool {
 	breakpoint := uint16(0x8000)
 	if value-prevValue == breakpoint {
@@ -0,0 +1,287 @@
+package sim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/pion/rtp"
+)
+
+// FECDecoderMode selects how FlexFEC03Decoder combines FEC packets
+type FECDecoderMode string
+
+const (
+	// FECDecoderPeeling recovers a packet only from FEC packets with exactly one missing
+	// protected packet, repeated until nothing changes (the pion decoder behaviour)
+	FECDecoderPeeling FECDecoderMode = "peeling"
+	// FECDecoderGaussian additionally solves the XOR system over all buffered FEC packets
+	// with Gaussian elimination over GF(2), recovering jointly determined losses
+	FECDecoderGaussian FECDecoderMode = "gaussian"
+)
+
+func ParseFECDecoderMode(s string) (FECDecoderMode, error) {
+	switch m := FECDecoderMode(s); m {
+	case "", FECDecoderPeeling:
+		return FECDecoderPeeling, nil
+	case FECDecoderGaussian:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown fec decoder mode %q (expected %q or %q)", s, FECDecoderPeeling, FECDecoderGaussian)
+	}
+}
+
+// jointHorizon is how far behind the newest media packet a missing packet can still be solved
+// for: twice the 109 packets a FlexFEC-03 mask spans, so FEC packets arriving late still count.
+// Older losses get no new FEC packets, so their equations stay unsolvable and are left out
+const jointHorizon = 2 * (15 + 31 + 63)
+
+// attemptJointRecovery treats every buffered FEC packet with missing protected packets as one
+// equation "XOR of missing packets = FEC repair XOR received packets" and solves the system.
+// Elimination runs on coefficient bitsets first; repair data is only combined for rows that
+// end up with a single unknown, so unsolvable backlogs stay cheap.
+// It only runs after the system changed (see jointPending).
+func (d *FlexFEC03Decoder) attemptJointRecovery() []rtp.Packet {
+	d.jointPending = false
+	var newest uint16
+	hasNewest := len(d.recoveredPackets) > 0
+	if hasNewest {
+		newest = d.recoveredPackets[len(d.recoveredPackets)-1].SequenceNumber
+	}
+	live := func(seq uint16) bool {
+		return !hasNewest || !isNewerSeq(seq, newest) || seqDiff(newest, seq) <= jointHorizon
+	}
+
+	var (
+		eqs      []*fecPacketState
+		unknowns []uint16
+		col      = make(map[uint16]int)
+	)
+	for i := range d.receivedFECPackets {
+		fec := &d.receivedFECPackets[i]
+		missing, solvable := false, false
+		for _, pp := range fec.protectedPackets {
+			if pp.packet == nil {
+				missing = true
+				solvable = solvable || live(pp.seq)
+			}
+		}
+		if !missing || !solvable {
+			continue
+		}
+		eqs = append(eqs, fec)
+		for _, pp := range fec.protectedPackets {
+			if pp.packet != nil {
+				continue
+			}
+			if _, ok := col[pp.seq]; !ok {
+				col[pp.seq] = len(unknowns)
+				unknowns = append(unknowns, pp.seq)
+			}
+		}
+	}
+	if len(eqs) < 2 || len(unknowns) < 2 {
+		return nil
+	}
+
+	rows := make([]gf2Row, len(eqs))
+	for i, fec := range eqs {
+		rows[i] = gf2Row{coef: newBitset(len(unknowns)), comb: newBitset(len(eqs))}
+		for _, pp := range fec.protectedPackets {
+			if pp.packet == nil {
+				rows[i].coef.flip(col[pp.seq])
+			}
+		}
+		rows[i].comb.flip(i)
+	}
+
+	// reduced row echelon form
+	pivot := 0
+	for c := 0; c < len(unknowns) && pivot < len(rows); c++ {
+		sel := -1
+		for r := pivot; r < len(rows); r++ {
+			if rows[r].coef.test(c) {
+				sel = r
+				break
+			}
+		}
+		if sel < 0 {
+			continue
+		}
+		rows[pivot], rows[sel] = rows[sel], rows[pivot]
+		for r := range rows {
+			if r != pivot && rows[r].coef.test(c) {
+				rows[r].coef.xor(rows[pivot].coef)
+				rows[r].comb.xor(rows[pivot].comb)
+			}
+		}
+		pivot++
+	}
+
+	residuals := make([]*fecResidual, len(eqs))
+	recovered := make([]rtp.Packet, 0)
+	for r := 0; r < pivot; r++ {
+		if rows[r].coef.count() != 1 {
+			continue
+		}
+		seq := unknowns[rows[r].coef.first()]
+
+		var acc fecResidual
+		ok := true
+		for _, e := range rows[r].comb.indices() {
+			if residuals[e] == nil {
+				res, err := computeFECResidual(eqs[e])
+				if err != nil {
+					d.logger.Errorf("failed to prepare fec equation: %v", err)
+					ok = false
+					break
+				}
+				residuals[e] = &res
+			}
+			acc.xor(residuals[e])
+		}
+		if !ok {
+			continue
+		}
+
+		pkt, err := d.packetFromResidual(acc, seq)
+		if err != nil {
+			d.logger.Errorf("failed to recover packet: %v", err)
+			continue
+		}
+		recovered = append(recovered, pkt)
+	}
+
+	// register after solving: updateCoveringFecPackets mutates the equations used above.
+	// Everything the system determines was solved, so the recoveries don't call for another pass
+	for _, pkt := range recovered {
+		d.recoveredPackets = append(d.recoveredPackets, pkt)
+		d.updateCoveringFecPackets(pkt)
+	}
+	d.jointPending = false
+	if len(recovered) > 0 {
+		sort.Slice(d.recoveredPackets, func(i, j int) bool {
+			return isNewerSeq(d.recoveredPackets[i].SequenceNumber, d.recoveredPackets[j].SequenceNumber)
+		})
+		d.discardOldRecoveredPackets()
+	}
+	return recovered
+}
+
+// fecResidual is the FEC repair data with all received protected packets XORed out
+type fecResidual struct {
+	header  [8]byte
+	payload []byte
+}
+
+func computeFECResidual(fec *fecPacketState) (fecResidual, error) {
+	var res fecResidual
+	copy(res.header[:], fec.packet.Payload[:8])
+	res.payload = append([]byte(nil), fec.flexFec.payload...)
+
+	for _, protected := range fec.protectedPackets {
+		if protected.packet == nil {
+			continue
+		}
+		receivedHeader, err := protected.packet.Header.Marshal()
+		if err != nil {
+			return fecResidual{}, fmt.Errorf("marshal received header: %w", err)
+		}
+		binary.BigEndian.PutUint16(receivedHeader[2:4], uint16(protected.packet.MarshalSize()-12))
+		for i := 0; i < 8; i++ {
+			res.header[i] ^= receivedHeader[i]
+		}
+
+		raw, err := protected.packet.Marshal()
+		if err != nil {
+			return fecResidual{}, fmt.Errorf("marshal protected packet: %w", err)
+		}
+		for i := 0; i < min(len(res.payload), len(raw)-12); i++ {
+			res.payload[i] ^= raw[12+i]
+		}
+	}
+	return res, nil
+}
+
+func (r *fecResidual) xor(o *fecResidual) {
+	for i := range r.header {
+		r.header[i] ^= o.header[i]
+	}
+	if len(o.payload) > len(r.payload) {
+		r.payload = append(r.payload, make([]byte, len(o.payload)-len(r.payload))...)
+	}
+	for i, b := range o.payload {
+		r.payload[i] ^= b
+	}
+}
+
+// packetFromResidual mirrors the header/payload reconstruction of recoverPacket
+func (d *FlexFEC03Decoder) packetFromResidual(res fecResidual, seq uint16) (rtp.Packet, error) {
+	headerRecovery := make([]byte, 12)
+	copy(headerRecovery, res.header[:])
+
+	headerRecovery[0] |= 0x80 // V=2
+	headerRecovery[0] &= 0xbf // clear padding bit
+	payloadLength := int(binary.BigEndian.Uint16(headerRecovery[2:4]))
+	if payloadLength > len(res.payload) {
+		return rtp.Packet{}, fmt.Errorf("%w: recovered length %d exceeds repair payload %d", errPacketTruncated, payloadLength, len(res.payload))
+	}
+
+	binary.BigEndian.PutUint16(headerRecovery[2:4], seq)
+	binary.BigEndian.PutUint32(headerRecovery[8:12], d.protectedStreamSSRC)
+
+	rawRecovered := append(headerRecovery, res.payload[:payloadLength]...) //nolint:makezero
+
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(rawRecovered); err != nil {
+		return rtp.Packet{}, fmt.Errorf("unmarshal recovered: %w", err)
+	}
+	return pkt, nil
+}
+
+type gf2Row struct {
+	coef bitset // unknowns present in this row
+	comb bitset // original equations XORed into this row
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset { return make(bitset, (n+63)/64) }
+
+func (b bitset) flip(i int)      { b[i/64] ^= 1 << (uint(i) % 64) }
+func (b bitset) test(i int) bool { return b[i/64]&(1<<(uint(i)%64)) != 0 }
+
+func (b bitset) xor(o bitset) {
+	for i := range b {
+		b[i] ^= o[i]
+	}
+}
+
+func (b bitset) count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+func (b bitset) first() int {
+	for i, w := range b {
+		if w != 0 {
+			return i*64 + bits.TrailingZeros64(w)
+		}
+	}
+	return -1
+}
+
+func (b bitset) indices() []int {
+	out := make([]int, 0, b.count())
+	for i, w := range b {
+		for w != 0 {
+			out = append(out, i*64+bits.TrailingZeros64(w))
+			w &= w - 1
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func testDecoderMedia(seq uint16) rtp.Packet {
+	payload := make([]byte, 20+int(seq)%7)
+	for i := range payload {
+		payload[i] = byte(int(seq)*31 + i)
+	}
+	return rtp.Packet{
+		Header:  rtp.Header{Version: 2, SSRC: testIDs.MediaSSRC, PayloadType: 96, SequenceNumber: seq, Timestamp: uint32(seq) * 900},
+		Payload: payload,
+	}
+}
+
+// testFEC builds a FlexFEC-03 packet (first mask only) protecting seqs, seqs[0] as the base
+func testFEC(fecSeq uint16, seqs ...uint16) rtp.Packet {
+	var (
+		hdr     [8]byte
+		payload []byte
+		mask    uint16
+	)
+	for _, seq := range seqs {
+		pkt := testDecoderMedia(seq)
+		raw, err := pkt.Marshal()
+		if err != nil {
+			panic(err)
+		}
+		binary.BigEndian.PutUint16(raw[2:4], uint16(len(raw)-12))
+		for i := range hdr {
+			hdr[i] ^= raw[i]
+		}
+		if n := len(raw) - 12; n > len(payload) {
+			payload = append(payload, make([]byte, n-len(payload))...)
+		}
+		for i, b := range raw[12:] {
+			payload[i] ^= b
+		}
+		mask |= 1 << (14 - (seq - seqs[0]))
+	}
+
+	fec := make([]byte, 20, 20+len(payload))
+	copy(fec, hdr[:])
+	fec[0] &= 0x3f // R and F bits
+	fec[8] = 1     // SSRC count
+	binary.BigEndian.PutUint32(fec[12:], testIDs.MediaSSRC)
+	binary.BigEndian.PutUint16(fec[16:], seqs[0])
+	binary.BigEndian.PutUint16(fec[18:], 0x8000|mask)
+	return rtp.Packet{
+		Header:  rtp.Header{Version: 2, SSRC: testIDs.FECSSRC, PayloadType: 97, SequenceNumber: fecSeq},
+		Payload: append(fec, payload...),
+	}
+}
+
+func pushAll(d *FlexFEC03Decoder, pkts ...rtp.Packet) map[uint16]rtp.Packet {
+	out := make(map[uint16]rtp.Packet)
+	for _, p := range pkts {
+		for _, r := range d.Push(p) {
+			out[r.SequenceNumber] = r
+		}
+	}
+	return out
+}
+
+// Packets 1..3 are lost and every FEC packet misses two or three of them, so peeling is stuck;
+// B+C gives 1, A+C gives 3 and then A gives 2
+func TestJointRecoverySolvesWherePeelingFails(t *testing.T) {
+	pkts := []rtp.Packet{
+		testDecoderMedia(0), testDecoderMedia(4), testDecoderMedia(5),
+		testFEC(0, 0, 1, 2),    // A: 1+2
+		testFEC(1, 2, 3, 4),    // B: 2+3
+		testFEC(2, 1, 2, 3, 5), // C: 1+2+3
+	}
+
+	peeling := NewFlexFEC03Decoder(testIDs.FECSSRC, testIDs.MediaSSRC)
+	if got := pushAll(peeling, pkts...); len(got) != 0 {
+		t.Fatalf("peeling recovered %d packets, want 0", len(got))
+	}
+
+	d := NewFlexFEC03Decoder(testIDs.FECSSRC, testIDs.MediaSSRC)
+	d.SetMode(FECDecoderGaussian)
+	got := pushAll(d, pkts...)
+	if len(got) != 3 {
+		t.Fatalf("recovered %d packets, want 3", len(got))
+	}
+	for seq := uint16(1); seq <= 3; seq++ {
+		want := testDecoderMedia(seq)
+		r, ok := got[seq]
+		if !ok {
+			t.Errorf("packet %d not recovered", seq)
+			continue
+		}
+		if !bytes.Equal(r.Payload, want.Payload) || r.Timestamp != want.Timestamp {
+			t.Errorf("packet %d: recovered ts=%d payload=%x, want ts=%d payload=%x", seq, r.Timestamp, r.Payload, want.Timestamp, want.Payload)
+		}
+	}
+}
+
+// Two equations over three unknowns determine none of them
+func TestJointRecoveryUnderdetermined(t *testing.T) {
+	d := NewFlexFEC03Decoder(testIDs.FECSSRC, testIDs.MediaSSRC)
+	d.SetMode(FECDecoderGaussian)
+	got := pushAll(d,
+		testDecoderMedia(0), testDecoderMedia(4),
+		testFEC(0, 0, 1, 2), // 1+2
+		testFEC(1, 2, 3, 4), // 2+3
+	)
+	if len(got) != 0 {
+		t.Fatalf("recovered %d packets from an underdetermined system, want 0", len(got))
+	}
+}
@@ -0,0 +1,184 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultEngineConfig is the controller config used when RunOptions.Engine is nil
+func DefaultEngineConfig() recovery.Config {
+	cfg := recovery.DefaultConfig()
+	cfg.Scheme = recovery.FECSchemeFlexFEC03
+	return cfg
+}
+
+// OverlayEngineConfig sets the given fields (keys as in the controller's JSON encoding) on base
+// Unknown keys are an error so typos don't silently fall back to defaults
+func OverlayEngineConfig(base recovery.Config, fields map[string]any) (recovery.Config, error) {
+	if len(fields) == 0 {
+		return base, nil
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return base, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	cfg := base
+	if err := dec.Decode(&cfg); err != nil {
+		return base, fmt.Errorf("engine config: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadEngineConfig reads a YAML or JSON file of controller fields on top of base
+func LoadEngineConfig(path string, base recovery.Config) (recovery.Config, error) {
+	var fields map[string]any
+	if err := decodeYAMLFile(path, &fields); err != nil {
+		return base, err
+	}
+	cfg, err := OverlayEngineConfig(base, fields)
+	if err != nil {
+		return base, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseEngineSet parses "key=value,key=value" into overlay fields; values are JSON
+// literals (numbers, booleans, quoted strings) or else taken as plain strings
+func ParseEngineSet(s string) (map[string]any, error) {
+	fields := make(map[string]any)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("engine setting %q: expected key=value", kv)
+		}
+		var val any
+		if err := json.Unmarshal([]byte(v), &val); err != nil {
+			val = v
+		}
+		fields[strings.TrimSpace(k)] = val
+	}
+	return fields, nil
+}
+
+// EngineVariant is one controller parameter set of a sweep
+type EngineVariant struct {
+	Label  string
+	Config recovery.Config
+}
+
+// EngineSweepFile lists controller parameter sets: explicit sets, the cartesian
+// product of a grid, or both (sets first); base is applied to every variant
+type EngineSweepFile struct {
+	Base map[string]any   `yaml:"base"`
+	Sets []map[string]any `yaml:"sets"`
+	Grid map[string][]any `yaml:"grid"`
+}
+
+// LoadEngineSweep reads a sweep file and expands it into variants on top of base
+// Sets are labelled by their "label" key (default set<i>), grid points by their values
+func LoadEngineSweep(path string, base recovery.Config) ([]EngineVariant, error) {
+	var f EngineSweepFile
+	if err := decodeYAMLFile(path, &f); err != nil {
+		return nil, err
+	}
+	base, err := OverlayEngineConfig(base, f.Base)
+	if err != nil {
+		return nil, fmt.Errorf("%s: base: %w", path, err)
+	}
+
+	var out []EngineVariant
+	for i, set := range f.Sets {
+		label := fmt.Sprintf("set%d", i)
+		fields := make(map[string]any, len(set))
+		for k, v := range set {
+			if k == "label" {
+				label = fmt.Sprint(v)
+				continue
+			}
+			fields[k] = v
+		}
+		cfg, err := OverlayEngineConfig(base, fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: sets[%d]: %w", path, i, err)
+		}
+		out = append(out, EngineVariant{Label: label, Config: cfg})
+	}
+
+	keys := make([]string, 0, len(f.Grid))
+	for k, vals := range f.Grid {
+		if len(vals) == 0 {
+			return nil, fmt.Errorf("%s: grid.%s: needs at least one value", path, k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		// odometer over the grid, the last key changing fastest
+		idx := make([]int, len(keys))
+		for {
+			fields := make(map[string]any, len(keys))
+			parts := make([]string, len(keys))
+			for i, k := range keys {
+				v := f.Grid[k][idx[i]]
+				fields[k] = v
+				parts[i] = fmt.Sprintf("%s=%v", k, v)
+			}
+			cfg, err := OverlayEngineConfig(base, fields)
+			if err != nil {
+				return nil, fmt.Errorf("%s: grid: %w", path, err)
+			}
+			out = append(out, EngineVariant{Label: strings.Join(parts, ";"), Config: cfg})
+
+			i := len(keys) - 1
+			for ; i >= 0; i-- {
+				idx[i]++
+				if idx[i] < len(f.Grid[keys[i]]) {
+					break
+				}
+				idx[i] = 0
+			}
+			if i < 0 {
+				break
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s: no parameter sets (expected sets and/or grid)", path)
+	}
+	seen := make(map[string]bool, len(out))
+	for _, v := range out {
+		if seen[v.Label] {
+			return nil, fmt.Errorf("%s: duplicate label %q", path, v.Label)
+		}
+		seen[v.Label] = true
+	}
+	return out, nil
+}
+
+// decodeYAMLFile decodes a YAML (or JSON) file, rejecting unknown fields of structs
+func decodeYAMLFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package sim
+
+import "time"
+
+// frameInfo is what the sender knows about a sent video frame
+type frameInfo struct {
+	// firstSeq is the extended sequence number of the frame's first packet
+	firstSeq uint64
+	packets  int
+	keyframe bool
+	sendAt   time.Time
+}
+
+// Freeze is a period without a newly displayed frame, relative to the run start
+type Freeze struct {
+	Start    time.Duration
+	Duration time.Duration
+	Frames   int
+}
+
+// frameTracker decides frame decodability at the playout deadline
+// A frame is decodable when all its packets are available by sendAt+deadline and, for
+// P-frames, the previous frame was decodable; the reference chain resets at keyframes.
+// A freeze lasts from the playout of the first undecodable frame to the playout of the
+// next decodable one.
+type frameTracker struct {
+	start    time.Time
+	deadline time.Duration
+	interval time.Duration
+
+	frames  []frameInfo
+	decided int
+	refOK   bool
+
+	decodable int64
+	freezes   []Freeze
+
+	frozen      bool
+	freezeStart time.Time
+	freezeLen   int
+}
+
+func newFrameTracker(start time.Time, deadline, interval time.Duration) *frameTracker {
+	return &frameTracker{start: start, deadline: deadline, interval: interval}
+}
+
+func (t *frameTracker) OnFrame(f frameInfo) { t.frames = append(t.frames, f) }
+
+// Advance decides every frame whose playout time is not after now; availability
+// later than the playout time can't change the outcome, so decisions are final
+func (t *frameTracker) Advance(now time.Time, availAt map[uint64]time.Time) {
+	for t.decided < len(t.frames) {
+		f := t.frames[t.decided]
+		playout := f.sendAt.Add(t.deadline)
+		if playout.After(now) {
+			return
+		}
+		t.decided++
+
+		complete := true
+		for j := 0; j < f.packets; j++ {
+			aAt, ok := availAt[f.firstSeq+uint64(j)]
+			if !ok || aAt.After(playout) {
+				complete = false
+				break
+			}
+		}
+		ok := complete && (f.keyframe || t.refOK)
+		t.refOK = ok
+
+		switch {
+		case ok:
+			t.decodable++
+			if t.frozen {
+				t.closeFreeze(playout)
+			}
+		case !t.frozen:
+			t.frozen = true
+			t.freezeStart = playout
+			t.freezeLen = 1
+		default:
+			t.freezeLen++
+		}
+	}
+}
+
+// Finish decides the remaining frames and closes an open freeze one frame interval
+// after the last frame's playout
+func (t *frameTracker) Finish(availAt map[uint64]time.Time) {
+	if len(t.frames) == 0 {
+		return
+	}
+	last := t.frames[len(t.frames)-1].sendAt.Add(t.deadline)
+	t.Advance(last, availAt)
+	if t.frozen {
+		t.closeFreeze(last.Add(t.interval))
+	}
+}
+
+func (t *frameTracker) closeFreeze(at time.Time) {
+	t.freezes = append(t.freezes, Freeze{
+		Start:    t.freezeStart.Sub(t.start),
+		Duration: at.Sub(t.freezeStart),
+		Frames:   t.freezeLen,
+	})
+	t.frozen = false
+}
+
+// FreezeTotal sums closed freezes plus the running one up to now
+func (t *frameTracker) FreezeTotal(now time.Time) time.Duration {
+	var total time.Duration
+	for _, f := range t.freezes {
+		total += f.Duration
+	}
+	if t.frozen && now.After(t.freezeStart) {
+		total += now.Sub(t.freezeStart)
+	}
+	return total
+}
+
+func (t *frameTracker) FreezeCount() int64 {
+	n := int64(len(t.freezes))
+	if t.frozen {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package sim
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// 2^7 linear sub-buckets per power of two keep the relative error of recorded values below 1%
+const (
+	histSubBits  = 7
+	histSubCount = 1 << histSubBits
+)
+
+// Histogram is an HDR-style log-linear histogram of durations at microsecond resolution.
+// Its memory is bounded by the largest value recorded (a few KB for seconds), not by the count
+type Histogram struct {
+	counts []int64
+	n      int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func NewHistogram() *Histogram { return &Histogram{} }
+
+// Record adds d; negative durations count as 0
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := histIndex(int64(d / time.Microsecond))
+	if i >= len(h.counts) {
+		grown := make([]int64, i+histSubCount)
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	h.counts[i]++
+	if h.n == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.n++
+	h.sum += d
+}
+
+func (h *Histogram) Count() int64       { return h.n }
+func (h *Histogram) Max() time.Duration { return h.max }
+
+func (h *Histogram) Mean() time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.n)
+}
+
+// Quantile is the nearest-rank q-quantile (the midpoint of its bucket, clamped to the
+// recorded range); 0 without values
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := max(1, int64(math.Ceil(q*float64(h.n))))
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen < rank {
+			continue
+		}
+		switch v := histMid(i); {
+		case v < h.min:
+			return h.min
+		case v > h.max:
+			return h.max
+		default:
+			return v
+		}
+	}
+	return h.max
+}
+
+// Percentiles are the usual latency quantiles in milliseconds
+type Percentiles struct {
+	P50, P95, P99, Max float64
+}
+
+func (h *Histogram) Percentiles() Percentiles {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return Percentiles{
+		P50: ms(h.Quantile(0.50)),
+		P95: ms(h.Quantile(0.95)),
+		P99: ms(h.Quantile(0.99)),
+		Max: ms(h.max),
+	}
+}
+
+// histIndex maps v >= 0 to its bucket: exact below histSubCount, then histSubCount
+// buckets per power of two
+func histIndex(v int64) int {
+	if v < histSubCount {
+		return int(v)
+	}
+	exp := bits.Len64(uint64(v)) - histSubBits - 1
+	return exp*histSubCount + int(v>>exp)
+}
+
+// histMid is the middle of bucket i as a duration
+func histMid(i int) time.Duration {
+	if i < 2*histSubCount {
+		return time.Duration(i) * time.Microsecond
+	}
+	exp := i/histSubCount - 1
+	lo := int64(i-exp*histSubCount) << exp
+	return time.Duration(lo+int64(1)<<exp/2) * time.Microsecond
+}
@@ -0,0 +1,56 @@
+package sim
+
+import "time"
+
+// latencyTracker records per media packet the time from sending until it is available
+// at the receiver, split by how it got there
+type latencyTracker struct {
+	// expectAt is when a dropped media packet (extended seq) would have arrived, as the
+	// link that dropped it worked out: departure after queueing and serialization plus the
+	// propagation delay. Recovery delay is measured from there, or from send time plus
+	// pathDelay (the propagation delay of the forward path) for packets that weren't dropped
+	expectAt  map[uint64]time.Time
+	pathDelay time.Duration
+
+	all, direct, fec, rtx, recovery *Histogram
+}
+
+func newLatencyTracker(pathDelay time.Duration) *latencyTracker {
+	return &latencyTracker{
+		expectAt:  make(map[uint64]time.Time),
+		pathDelay: pathDelay,
+		all:       NewHistogram(),
+		direct:    NewHistogram(),
+		fec:       NewHistogram(),
+		rtx:       NewHistogram(),
+		recovery:  NewHistogram(),
+	}
+}
+
+// Lost notes when the dropped media packet ext would have arrived (see PacketMeta.ExpectedAt)
+func (t *latencyTracker) Lost(ext uint64, expectAt time.Time) {
+	if !expectAt.IsZero() {
+		t.expectAt[ext] = expectAt
+	}
+}
+
+func (t *latencyTracker) Record(ext uint64, sentAt, at time.Time, via availSource) {
+	d := at.Sub(sentAt)
+	t.all.Record(d)
+	switch via {
+	case availDirect:
+		t.direct.Record(d)
+		return
+	case availFEC:
+		t.fec.Record(d)
+	case availRTX:
+		t.rtx.Record(d)
+	}
+	expect, ok := t.expectAt[ext]
+	if ok {
+		delete(t.expectAt, ext)
+	} else {
+		expect = sentAt.Add(t.pathDelay)
+	}
+	t.recovery.Record(at.Sub(expect))
+}
@@ -0,0 +1,21 @@
+package sim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecoveryDelayFromExpectedArrival(t *testing.T) {
+	lt := newLatencyTracker(20 * time.Millisecond)
+	sent := time.Unix(0, 0)
+	// queued for 100ms before the loss, restored 30ms after it would have arrived
+	lt.Lost(7, sent.Add(120*time.Millisecond))
+	lt.Record(7, sent, sent.Add(150*time.Millisecond), availFEC)
+	// no expected arrival known: send time plus the path delay
+	lt.Record(8, sent, sent.Add(40*time.Millisecond), availFEC)
+
+	p := lt.recovery.Percentiles()
+	if p.Max < 29 || p.Max > 31 {
+		t.Errorf("max recovery delay = %vms, want 30ms", p.Max)
+	}
+}
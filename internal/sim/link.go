@@ -15,22 +15,68 @@ const (
 	DropQueue    DropReason = "queue_overflow"
 	DropWireLoss DropReason = "wire_loss"
 	DropZeroCap  DropReason = "zero_capacity"
+
+	// AQM drops (see QueueSpec)
+	DropCoDel      DropReason = "codel"
+	DropPIE        DropReason = "pie"
+	DropFQCoDel    DropReason = "fq_codel"
+	DropFQOverflow DropReason = "fq_codel_overflow"
 )
 
+// IsAQM reports whether the drop was an active queue management decision
+func (r DropReason) IsAQM() bool {
+	switch r {
+	case DropCoDel, DropPIE, DropFQCoDel, DropFQOverflow:
+		return true
+	}
+	return false
+}
+
 type Link struct {
 	spec  LinkSpec
 	start time.Time
 
 	nextAvail time.Time
 	pq        eventHeap
+
+	rtcpSeq uint64
+	// seqs extends the sequence numbers of RTP and cross traffic per SSRC for PacketMeta.ExtSeq
+	seqs  map[uint32]*seqUnwrapper
+	trace traceCursor
+
+	// queue discipline (nil: analytical drop-tail, outcomes are known at send time)
+	q      qdisc
+	onDrop func(PacketMeta, DropReason)
+
+	// packet currently being sent; its fate goes into the SendOutcome instead of onDrop
+	cur    *queuedPacket
+	curOut *SendOutcome
+	// time of the enqueue or service in progress, for drops decided by the queue discipline
+	opAt time.Time
+
+	// impairment state: reorder candidates seen per SSRC, end and last release of the current stall
+	reorderCount map[uint32]int
+	stallEnd     time.Time
+	stallRelease time.Time
+
+	// queueing delay of packets that started transmission
+	txPkts        int64
+	queueDelaySum time.Duration
+	maxQueueDelay time.Duration
 }
 
 type SendOutcome struct {
+	// Pending is set when the packet was queued and its fate is decided later;
+	// a later drop is reported to the handler set with OnDrop
+	Pending bool
+
 	Dropped    bool
 	Reason     DropReason
 	ArrivalAt  time.Time
 	QueueDelay time.Duration
 	SizeBytes  int
+	// ExpectedAt is when a dropped packet would have arrived (see PacketMeta.ExpectedAt)
+	ExpectedAt time.Time
 }
 
 type DeliveredPacket struct {
@@ -39,10 +85,23 @@ type DeliveredPacket struct {
 	SentAt    time.Time
 	SizeBytes int
 	IsFEC     bool
+
+	// RTCP is set (and Pkt empty) for compound RTCP packets sent with SendRTCP
+	RTCP []byte
+	// Cross is set (and Pkt empty) for cross traffic sent with SendCross
+	Cross *PacketMeta
 }
 
 func NewLink(spec LinkSpec, start time.Time) *Link {
-	l := &Link{spec: spec, start: start, nextAvail: start}
+	l := &Link{
+		spec:         spec,
+		start:        start,
+		nextAvail:    start,
+		seqs:         make(map[uint32]*seqUnwrapper),
+		trace:        newTraceCursor(),
+		q:            newQdisc(spec.Queue, spec.Seed),
+		reorderCount: make(map[uint32]int),
+	}
 	heap.Init(&l.pq)
 	return l
 }
@@ -52,66 +111,317 @@ func (l *Link) Send(pkt rtp.Packet, sentAt time.Time, isFEC bool) SendOutcome {
 	if sizeBytes <= 0 {
 		sizeBytes = 12 + len(pkt.Payload)
 	}
+	meta := PacketMeta{
+		At:        sentAt.Sub(l.start),
+		SSRC:      pkt.SSRC,
+		PT:        pkt.PayloadType,
+		Seq:       pkt.SequenceNumber,
+		ExtSeq:    l.extSeq(pkt.SSRC, pkt.SequenceNumber),
+		SizeBytes: sizeBytes,
+		IsFEC:     isFEC,
+	}
+	return l.enqueue(meta, sentAt, &deliveryEvent{sentAt: sentAt, pkt: pkt, isFEC: isFEC})
+}
 
-	capBps := math.Inf(1)
-	if l.spec.CapacityBps != nil {
-		capBps = l.spec.CapacityBps.At(sentAt.Sub(l.start))
+// SendRTCP transmits a marshalled compound RTCP packet from ssrc over the link
+// It shares capacity, queue, delay and loss with RTP traffic; loss models see it
+// with PT 0 and a per-link running sequence number
+func (l *Link) SendRTCP(raw []byte, ssrc uint32, sentAt time.Time) SendOutcome {
+	l.rtcpSeq++
+	meta := PacketMeta{
+		At:        sentAt.Sub(l.start),
+		SSRC:      ssrc,
+		Seq:       uint16(l.rtcpSeq),
+		ExtSeq:    l.rtcpSeq,
+		SizeBytes: len(raw),
+		IsRTCP:    true,
 	}
-	if capBps == 0 {
-		return SendOutcome{Dropped: true, Reason: DropZeroCap, SizeBytes: sizeBytes}
+	return l.enqueue(meta, sentAt, &deliveryEvent{sentAt: sentAt, rtcp: raw})
+}
+
+// SendCross injects size bytes of competing traffic; it occupies queue and serialization
+// time like RTP but is only handed back from Next as DeliveredPacket.Cross
+func (l *Link) SendCross(ssrc uint32, seq uint16, size int, sentAt time.Time) SendOutcome {
+	meta := PacketMeta{
+		At:        sentAt.Sub(l.start),
+		SSRC:      ssrc,
+		Seq:       seq,
+		ExtSeq:    l.extSeq(ssrc, seq),
+		SizeBytes: size,
+		IsCross:   true,
 	}
-	if capBps < 0 {
-		capBps = 0
+	return l.enqueue(meta, sentAt, &deliveryEvent{sentAt: sentAt, cross: &meta})
+}
+
+// extSeq extends seq of ssrc; packets forwarded by a Path keep the extension of the first hop
+func (l *Link) extSeq(ssrc uint32, seq uint16) uint64 {
+	u, ok := l.seqs[ssrc]
+	if !ok {
+		u = &seqUnwrapper{}
+		l.seqs[ssrc] = u
 	}
+	return u.Unwrap(seq)
+}
 
-	startTx := sentAt
-	if l.nextAvail.After(startTx) {
-		startTx = l.nextAvail
+// OnDrop sets the handler for drops decided after Send returned (queue disciplines only)
+func (l *Link) OnDrop(fn func(meta PacketMeta, reason DropReason)) { l.onDrop = fn }
+
+// forward offers a packet delivered by the previous hop of a Path at its arrival time
+func (l *Link) forward(ev *deliveryEvent, at time.Time) SendOutcome {
+	meta := ev.meta
+	meta.At = at.Sub(l.start)
+	return l.enqueue(meta, at, ev)
+}
+
+func (l *Link) enqueue(meta PacketMeta, sentAt time.Time, ev *deliveryEvent) SendOutcome {
+	ev.meta = meta
+	if l.q == nil {
+		return l.send(meta, sentAt, ev)
 	}
-	qDelay := startTx.Sub(sentAt)
-	if l.spec.MaxQueueDelay > 0 && qDelay > l.spec.MaxQueueDelay {
-		return SendOutcome{Dropped: true, Reason: DropQueue, QueueDelay: qDelay, SizeBytes: sizeBytes}
+
+	p := &queuedPacket{meta: meta, ev: ev, enqAt: sentAt}
+	out := SendOutcome{Pending: true, SizeBytes: meta.SizeBytes}
+	l.cur, l.curOut = p, &out
+	l.opAt = sentAt
+	l.q.enqueue(p, sentAt, l.drop)
+	if !l.nextAvail.After(sentAt) {
+		l.service(sentAt)
 	}
+	l.cur, l.curOut = nil, nil
+	return out
+}
+
+// drop reports a queued packet as lost; one that never left the queue would have arrived
+// no earlier than the base delay after the drop
+func (l *Link) drop(p *queuedPacket, reason DropReason) {
+	if p.meta.ExpectedAt.IsZero() {
+		p.meta.ExpectedAt = l.opAt.Add(l.spec.BaseOneWayDelay)
+	}
+	if p == l.cur {
+		*l.curOut = SendOutcome{Dropped: true, Reason: reason, QueueDelay: l.curOut.QueueDelay, SizeBytes: p.meta.SizeBytes, ExpectedAt: p.meta.ExpectedAt}
+		return
+	}
+	if l.onDrop != nil {
+		l.onDrop(p.meta, reason)
+	}
+}
+
+// NextEvent is the time of the next delivery or queue service, whichever comes first
+func (l *Link) NextEvent() (time.Time, bool) {
+	t, ok := l.nextService()
+	if l.pq.Len() > 0 && (!ok || l.pq[0].at.Before(t)) {
+		return l.pq[0].at, true
+	}
+	return t, ok
+}
+
+func (l *Link) nextService() (time.Time, bool) {
+	if l.q == nil || l.q.empty() {
+		return time.Time{}, false
+	}
+	return l.nextAvail, true
+}
 
-	serSec := (float64(sizeBytes) * 8.0) / capBps
-	if serSec < 0 {
-		serSec = 0
+// Service starts transmitting queued packets once the link is free; it reports
+// whether a service was due at now (deliveries at the same time come after it)
+func (l *Link) Service(now time.Time) bool {
+	t, ok := l.nextService()
+	if !ok || t.After(now) {
+		return false
 	}
-	ser := time.Duration(serSec * float64(time.Second))
-	if ser == 0 && !math.IsInf(capBps, 1) {
-		ser = time.Nanosecond
+	l.service(now)
+	return true
+}
+
+func (l *Link) service(now time.Time) {
+	l.opAt = now
+	for !l.q.empty() && !l.nextAvail.After(now) {
+		p := l.q.dequeue(now, l.drop)
+		if p == nil {
+			return
+		}
+		l.transmit(p, now)
+	}
+}
+
+// transmit puts a dequeued packet on the wire at now
+func (l *Link) transmit(p *queuedPacket, now time.Time) {
+	sojourn := now.Sub(p.enqAt)
+	if p == l.cur {
+		l.curOut.QueueDelay = sojourn
 	}
+	if l.spec.MaxQueueDelay > 0 && sojourn > l.spec.MaxQueueDelay {
+		l.drop(p, DropQueue)
+		return
+	}
+	l.recordQueueDelay(sojourn)
 
-	finishTx := startTx.Add(ser)
+	size := p.meta.SizeBytes
+	var finishTx time.Time
+	if tr := l.spec.Trace; tr != nil {
+		_, last := tr.serve(&l.trace, now.Sub(l.start), size)
+		finishTx = l.start.Add(last)
+	} else {
+		capBps := math.Inf(1)
+		if l.spec.CapacityBps != nil {
+			capBps = l.spec.CapacityBps.At(now.Sub(l.start))
+		}
+		if capBps <= 0 {
+			l.drop(p, DropZeroCap)
+			return
+		}
+		ser := time.Duration(float64(size) * 8 / capBps * float64(time.Second))
+		if ser == 0 && !math.IsInf(capBps, 1) {
+			ser = time.Nanosecond
+		}
+		finishTx = now.Add(ser)
+	}
 	l.nextAvail = finishTx
 
 	arrival := finishTx.Add(l.spec.BaseOneWayDelay)
 	if l.spec.Jitter > 0 {
-		j := l.jitterFor(pkt.SSRC, pkt.SequenceNumber)
+		arrival = arrival.Add(l.jitterFor(p.meta.SSRC, p.meta.ExtSeq))
+	}
+	if l.spec.Loss != nil && l.spec.Loss.Drop(p.meta) {
+		p.meta.ExpectedAt = arrival
+		l.drop(p, DropWireLoss)
+		return
+	}
+	p.ev.sizeBytes = size
+	arrival = l.deliver(p.ev, arrival)
+
+	if p == l.cur {
+		*l.curOut = SendOutcome{ArrivalAt: arrival, QueueDelay: sojourn, SizeBytes: size}
+	}
+}
+
+// deliver schedules a packet that survived the link at arrival, after the impairments
+func (l *Link) deliver(ev *deliveryEvent, arrival time.Time) time.Time {
+	im := l.spec.Impairments
+	if im == (Impairments{}) {
+		ev.at = arrival
+		heap.Push(&l.pq, ev)
+		return arrival
+	}
+
+	meta := ev.meta
+	if im.ReorderProb > 0 {
+		// counted per SSRC, so RTCP and cross traffic don't shift the media candidates
+		l.reorderCount[meta.SSRC]++
+		gap := max(im.ReorderGap, 1)
+		if l.reorderCount[meta.SSRC]%gap == 0 && u01(l.spec.Seed^0x72656f72, meta.SSRC, meta.ExtSeq) < im.ReorderProb {
+			arrival = arrival.Add(im.ReorderDelay)
+		}
+	}
+	if im.SpikeProb > 0 {
+		switch {
+		case arrival.Before(l.stallEnd):
+			// held by the current stall, released right after the packets before it
+			l.stallRelease = l.stallRelease.Add(time.Nanosecond)
+			arrival = l.stallRelease
+		case u01(l.spec.Seed^0x7370696b, meta.SSRC, meta.ExtSeq) < im.SpikeProb:
+			u := u01(l.spec.Seed^0x7374616c, meta.SSRC, meta.ExtSeq)
+			l.stallEnd = arrival.Add(time.Duration(-math.Log(1-u) * float64(im.SpikeDuration)))
+			l.stallRelease = l.stallEnd
+			arrival = l.stallEnd
+		}
+	}
+
+	ev.at = arrival
+	heap.Push(&l.pq, ev)
+
+	isRTP := !meta.IsRTCP && !meta.IsCross
+	if isRTP && im.DuplicateProb > 0 && u01(l.spec.Seed^0x64757063, meta.SSRC, meta.ExtSeq) < im.DuplicateProb {
+		dup := *ev
+		dup.at = arrival.Add(im.DuplicateDelay)
+		heap.Push(&l.pq, &dup)
+	}
+	return arrival
+}
+
+func (l *Link) recordQueueDelay(d time.Duration) {
+	l.txPkts++
+	l.queueDelaySum += d
+	if d > l.maxQueueDelay {
+		l.maxQueueDelay = d
+	}
+}
+
+// QueueDelay is the current queueing delay: the wait of a packet sent now, or with a
+// queue discipline the sojourn time of the oldest queued packet
+func (l *Link) QueueDelay(now time.Time) time.Duration {
+	if l.q != nil {
+		if t, ok := l.q.oldest(); ok {
+			return now.Sub(t)
+		}
+		return 0
+	}
+	if l.nextAvail.After(now) {
+		return l.nextAvail.Sub(now)
+	}
+	return 0
+}
+
+func (l *Link) send(meta PacketMeta, sentAt time.Time, ev *deliveryEvent) SendOutcome {
+	sizeBytes := meta.SizeBytes
+
+	var startTx, finishTx time.Time
+	cursor := l.trace
+	if tr := l.spec.Trace; tr != nil {
+		// trace mode: bytes leave in the delivery opportunities of the trace
+		first, last := tr.serve(&cursor, sentAt.Sub(l.start), sizeBytes)
+		startTx, finishTx = l.start.Add(first), l.start.Add(last)
+	} else {
+		capBps := math.Inf(1)
+		if l.spec.CapacityBps != nil {
+			capBps = l.spec.CapacityBps.At(sentAt.Sub(l.start))
+		}
+		if capBps == 0 {
+			return SendOutcome{Dropped: true, Reason: DropZeroCap, SizeBytes: sizeBytes, ExpectedAt: sentAt.Add(l.spec.BaseOneWayDelay)}
+		}
+		if capBps < 0 {
+			capBps = 0
+		}
+
+		startTx = sentAt
+		if l.nextAvail.After(startTx) {
+			startTx = l.nextAvail
+		}
+
+		serSec := (float64(sizeBytes) * 8.0) / capBps
+		if serSec < 0 {
+			serSec = 0
+		}
+		ser := time.Duration(serSec * float64(time.Second))
+		if ser == 0 && !math.IsInf(capBps, 1) {
+			ser = time.Nanosecond
+		}
+		finishTx = startTx.Add(ser)
+	}
+
+	qDelay := startTx.Sub(sentAt)
+	if l.spec.MaxQueueDelay > 0 && qDelay > l.spec.MaxQueueDelay {
+		return SendOutcome{Dropped: true, Reason: DropQueue, QueueDelay: qDelay, SizeBytes: sizeBytes, ExpectedAt: finishTx.Add(l.spec.BaseOneWayDelay)}
+	}
+
+	l.nextAvail = finishTx
+	l.trace = cursor
+	l.recordQueueDelay(qDelay)
+
+	arrival := finishTx.Add(l.spec.BaseOneWayDelay)
+	if l.spec.Jitter > 0 {
+		j := l.jitterFor(meta.SSRC, meta.ExtSeq)
 		arrival = arrival.Add(j)
 	}
 
 	if l.spec.Loss != nil {
-		meta := PacketMeta{
-			At:        sentAt.Sub(l.start),
-			SSRC:      pkt.SSRC,
-			PT:        pkt.PayloadType,
-			Seq:       pkt.SequenceNumber,
-			SizeBytes: sizeBytes,
-			IsFEC:     isFEC,
-		}
 		if l.spec.Loss.Drop(meta) {
-			return SendOutcome{Dropped: true, Reason: DropWireLoss, QueueDelay: qDelay, SizeBytes: sizeBytes}
+			return SendOutcome{Dropped: true, Reason: DropWireLoss, QueueDelay: qDelay, SizeBytes: sizeBytes, ExpectedAt: arrival}
 		}
 	}
 
-	heap.Push(&l.pq, &deliveryEvent{
-		at:        arrival,
-		sentAt:    sentAt,
-		pkt:       pkt,
-		sizeBytes: sizeBytes,
-		isFEC:     isFEC,
-	})
+	ev.sizeBytes = sizeBytes
+	arrival = l.deliver(ev, arrival)
 
 	return SendOutcome{Dropped: false, Reason: DropNone, ArrivalAt: arrival, QueueDelay: qDelay, SizeBytes: sizeBytes}
 }
@@ -121,21 +431,33 @@ func (l *Link) Next() (DeliveredPacket, bool) {
 		return DeliveredPacket{}, false
 	}
 	ev := heap.Pop(&l.pq).(*deliveryEvent)
-	return DeliveredPacket{Pkt: ev.pkt, Arrives: ev.at, SentAt: ev.sentAt, SizeBytes: ev.sizeBytes, IsFEC: ev.isFEC}, true
+	return DeliveredPacket{Pkt: ev.pkt, Arrives: ev.at, SentAt: ev.sentAt, SizeBytes: ev.sizeBytes, IsFEC: ev.isFEC, RTCP: ev.rtcp, Cross: ev.cross}, true
+}
+
+// peek is the next delivery without removing it
+func (l *Link) peek() (*deliveryEvent, bool) {
+	if l.pq.Len() == 0 {
+		return nil, false
+	}
+	return l.pq[0], true
 }
 
-func (l *Link) jitterFor(ssrc uint32, seq uint16) time.Duration {
+func (l *Link) jitterFor(ssrc uint32, seq uint64) time.Duration {
 	u := u01(l.spec.Seed, ssrc, seq)
 	x := (u * 2) - 1
 	return time.Duration(x * float64(l.spec.Jitter))
 }
 
 type deliveryEvent struct {
-	at        time.Time
+	at time.Time
+	// sentAt is the send time at the first hop; meta describes the packet on the current one
 	sentAt    time.Time
+	meta      PacketMeta
 	pkt       rtp.Packet
 	sizeBytes int
 	isFEC     bool
+	rtcp      []byte
+	cross     *PacketMeta
 }
 
 type eventHeap []*deliveryEvent
@@ -0,0 +1,39 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// 100-byte packets at 8 kbit/s take 100ms each; the third of a burst is lost on the wire
+// after waiting 200ms behind the others, so it would have arrived at 300ms plus the delays
+// of both hops
+func TestPathExpectedArrivalOfDrops(t *testing.T) {
+	start := time.Unix(0, 0)
+	pattern := []bool{false, false, true}
+	path := NewPath([]HopSpec{
+		{Name: "access", Link: LinkSpec{
+			BaseOneWayDelay: 20 * time.Millisecond,
+			CapacityBps:     &FloatSchedule{Default: 8000},
+			Loss:            NewPacketTraceLoss("third", 0, pattern, TraceLossShared, false),
+		}},
+		{Name: "core", Link: LinkSpec{BaseOneWayDelay: 30 * time.Millisecond}},
+	}, start)
+
+	var out SendOutcome
+	for i := 0; i < 3; i++ {
+		pkt := rtp.Packet{
+			Header:  rtp.Header{Version: 2, SSRC: testIDs.MediaSSRC, SequenceNumber: uint16(i)},
+			Payload: make([]byte, 88),
+		}
+		out = path.Send(pkt, start, false)
+	}
+	if !out.Dropped {
+		t.Fatal("third packet was not dropped")
+	}
+	if want := start.Add(350 * time.Millisecond); !out.ExpectedAt.Equal(want) {
+		t.Errorf("expected arrival = %v, want %v", out.ExpectedAt.Sub(start), want.Sub(start))
+	}
+}
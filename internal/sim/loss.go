@@ -11,8 +11,14 @@ type PacketMeta struct {
 	SSRC      uint32
 	PT        uint8
 	Seq       uint16
+	ExtSeq    uint64 // Seq extended across wraps per SSRC (see seqUnwrapper), set by the Link
 	SizeBytes int
 	IsFEC     bool
+	IsRTCP    bool
+	IsCross   bool
+	// ExpectedAt is set on drops: when the packet would have arrived at the end of the
+	// link (or path), with the queueing it saw until the drop
+	ExpectedAt time.Time
 }
 
 type LossModel interface {
@@ -20,6 +26,18 @@ type LossModel interface {
 	Drop(meta PacketMeta) bool
 }
 
+// LossModelReseeder is implemented by loss models that can give every run its own copy;
+// models without it are shared by all runs of a scenario as they are
+type LossModelReseeder interface {
+	Reseed(seed int64) LossModel
+}
+
+// lossRater is implemented by loss models whose loss rate is known ahead of time (used by the oracle)
+type lossRater interface {
+	// LossRate is the expected fraction of media packets lost when sent in [from, to)
+	LossRate(from, to time.Duration) (float64, bool)
+}
+
 type ScheduledBernoulliLoss struct {
 	Seed int64
 	P    *FloatSchedule
@@ -46,7 +64,11 @@ func (m *ScheduledBernoulliLoss) Drop(meta PacketMeta) bool {
 	if p >= 1 {
 		return true
 	}
-	return u01(m.Seed, meta.SSRC, meta.Seq) < p
+	return u01(m.Seed, meta.SSRC, meta.ExtSeq) < p
+}
+
+func (m *ScheduledBernoulliLoss) LossRate(from, to time.Duration) (float64, bool) {
+	return clamp01(m.P.Mean(from, to)), true
 }
 
 type GilbertElliottLoss struct {
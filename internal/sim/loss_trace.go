@@ -0,0 +1,249 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceLossScope selects which packets walk a loss trace and how
+type TraceLossScope string
+
+const (
+	// TraceLossShared applies the trace to all packets in send order (one position)
+	TraceLossShared TraceLossScope = "shared"
+	// TraceLossSeparate lets media and FEC walk the trace independently with their own offsets
+	TraceLossSeparate TraceLossScope = "separate"
+	// TraceLossMediaOnly applies the trace to media packets; FEC is never dropped
+	TraceLossMediaOnly TraceLossScope = "media_only"
+)
+
+// LossInterval is a timestamped loss event: packets sent in [Start, Start+Duration) are lost
+type LossInterval struct {
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// TraceLoss replays recorded loss, either per packet (Pattern) or by send time (Events)
+// With RandomOffset every seed starts at a different, deterministic position of the trace
+type TraceLoss struct {
+	NameStr string
+	Seed    int64
+
+	// Pattern is indexed by packet (true = lost); used when Events is empty
+	Pattern []bool
+	// Events are sorted, non-overlapping loss periods that repeat every Period
+	Events []LossInterval
+	Period time.Duration
+
+	Scope        TraceLossScope
+	RandomOffset bool
+
+	mu      sync.Mutex
+	offsets [2]uint64 // media, FEC
+	counts  [2]uint64
+}
+
+// NewPacketTraceLoss replays a per-packet loss pattern (looped)
+func NewPacketTraceLoss(name string, seed int64, pattern []bool, scope TraceLossScope, randomOffset bool) *TraceLoss {
+	return newTraceLoss(&TraceLoss{NameStr: name, Seed: seed, Pattern: pattern, Scope: scope, RandomOffset: randomOffset})
+}
+
+// NewTimedTraceLoss replays loss periods keyed on PacketMeta.At; period <= 0 loops after the last event
+func NewTimedTraceLoss(name string, seed int64, events []LossInterval, period time.Duration, scope TraceLossScope, randomOffset bool) *TraceLoss {
+	if period <= 0 && len(events) > 0 {
+		last := events[len(events)-1]
+		period = last.Start + last.Duration
+	}
+	return newTraceLoss(&TraceLoss{NameStr: name, Seed: seed, Events: events, Period: period, Scope: scope, RandomOffset: randomOffset})
+}
+
+func newTraceLoss(m *TraceLoss) *TraceLoss {
+	if m.NameStr == "" {
+		m.NameStr = "trace"
+	}
+	if m.Scope == "" {
+		m.Scope = TraceLossShared
+	}
+	if m.RandomOffset {
+		m.offsets[0] = splitmix64(uint64(m.Seed) ^ 0x6c6f73736d656469)
+		m.offsets[1] = splitmix64(uint64(m.Seed) ^ 0x6c6f737366656321)
+	}
+	return m
+}
+
+func (m *TraceLoss) Name() string { return m.NameStr }
+
+// reseed returns a fresh copy positioned for seed
+func (m *TraceLoss) reseed(seed int64) *TraceLoss {
+	return newTraceLoss(&TraceLoss{
+		NameStr:      m.NameStr,
+		Seed:         seed,
+		Pattern:      m.Pattern,
+		Events:       m.Events,
+		Period:       m.Period,
+		Scope:        m.Scope,
+		RandomOffset: m.RandomOffset,
+	})
+}
+
+func (m *TraceLoss) Drop(meta PacketMeta) bool {
+	if meta.IsCross {
+		// the trace was recorded on the call's own packets
+		return false
+	}
+	class := 0
+	switch {
+	case meta.IsFEC && m.Scope == TraceLossMediaOnly:
+		return false
+	case meta.IsFEC && m.Scope == TraceLossSeparate:
+		class = 1
+	}
+
+	if len(m.Events) > 0 {
+		return m.lostAt(meta.At, m.offsets[class])
+	}
+	if len(m.Pattern) == 0 {
+		return false
+	}
+
+	m.mu.Lock()
+	idx := m.counts[class]
+	m.counts[class]++
+	m.mu.Unlock()
+	return m.Pattern[(idx+m.offsets[class])%uint64(len(m.Pattern))]
+}
+
+func (m *TraceLoss) lostAt(at time.Duration, offset uint64) bool {
+	if m.Period <= 0 {
+		return false
+	}
+	t := (at + time.Duration(offset%uint64(m.Period))) % m.Period
+	i := sort.Search(len(m.Events), func(i int) bool { return m.Events[i].Start > t }) - 1
+	return i >= 0 && t < m.Events[i].Start+m.Events[i].Duration
+}
+
+// LossRate is the share of [from, to) covered by loss periods (sampled per millisecond)
+// for the media packets; per-packet patterns don't know their rate ahead of time
+func (m *TraceLoss) LossRate(from, to time.Duration) (float64, bool) {
+	if len(m.Events) == 0 || to <= from {
+		return 0, false
+	}
+	var lost, n int
+	for t := from; t < to; t += time.Millisecond {
+		if m.lostAt(t, m.offsets[0]) {
+			lost++
+		}
+		n++
+	}
+	return float64(lost) / float64(n), true
+}
+
+// ParseLossPattern reads a received/lost bit sequence: '1' is a lost packet, '0' a received one
+// Whitespace is ignored and '#' starts a comment until the end of the line
+func ParseLossPattern(r io.Reader) ([]bool, error) {
+	var out []bool
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		for col, c := range s {
+			switch c {
+			case '0':
+				out = append(out, false)
+			case '1':
+				out = append(out, true)
+			case ' ', '\t', '\r':
+			default:
+				return nil, fmt.Errorf("line %d col %d: unexpected %q (expected 0 or 1)", line, col+1, c)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("loss pattern is empty")
+	}
+	return out, nil
+}
+
+// ParseLossEvents reads "start_ms duration_ms" lines with increasing, non-overlapping events
+func ParseLossEvents(r io.Reader) ([]LossInterval, error) {
+	var out []LossInterval
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := strings.TrimSpace(sc.Text())
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = strings.TrimSpace(s[:i])
+		}
+		if s == "" {
+			continue
+		}
+		fields := strings.Fields(s)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"start_ms duration_ms\", got %q", line, s)
+		}
+		start, err1 := strconv.ParseFloat(fields[0], 64)
+		dur, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil || start < 0 || dur <= 0 {
+			return nil, fmt.Errorf("line %d: start must be >= 0 and duration > 0 (ms), got %q", line, s)
+		}
+		ev := LossInterval{
+			Start:    time.Duration(start * float64(time.Millisecond)),
+			Duration: time.Duration(dur * float64(time.Millisecond)),
+		}
+		if n := len(out); n > 0 && ev.Start < out[n-1].Start+out[n-1].Duration {
+			return nil, fmt.Errorf("line %d: event overlaps or precedes the previous one", line)
+		}
+		out = append(out, ev)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("loss event trace is empty")
+	}
+	return out, nil
+}
+
+// LoadLossPattern reads a bit sequence file (see ParseLossPattern)
+func LoadLossPattern(path string) ([]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	p, err := ParseLossPattern(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// LoadLossEvents reads a timestamped loss event file (see ParseLossEvents)
+func LoadLossEvents(path string) ([]LossInterval, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	ev, err := ParseLossEvents(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ev, nil
+}
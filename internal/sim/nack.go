@@ -0,0 +1,229 @@
+package sim
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	defaultNACKReorderTolerance = 10 * time.Millisecond
+	defaultNACKRetryInterval    = 100 * time.Millisecond
+	defaultNACKMaxRetries       = 3
+	defaultRTXHistorySize       = 1024
+
+	// maxNACKPending bounds the receiver's gap list (oldest entries are dropped)
+	maxNACKPending = 1000
+)
+
+// nackTracker detects sequence gaps on the receiver and schedules NACKs for them
+// A gap is requested once it is older than the reorder tolerance and re-requested
+// every retry interval until the packet shows up or the retries are used up
+// Sequence numbers are extended (see seqUnwrapper), so gaps survive the 16-bit wrap
+type nackTracker struct {
+	tolerance     time.Duration
+	retryInterval time.Duration
+	maxRetries    int
+
+	started bool
+	maxSeq  uint64
+	pending map[uint64]*nackState
+	// due orders the pending gaps by their next NACK time
+	due nackHeap
+}
+
+type nackState struct {
+	seq     uint64
+	nextAt  time.Time
+	retries int
+	index   int // position in nackTracker.due
+}
+
+func newNACKTracker(spec RTXSpec) *nackTracker {
+	t := &nackTracker{
+		tolerance:     spec.ReorderTolerance,
+		retryInterval: spec.RetryInterval,
+		maxRetries:    spec.MaxRetries,
+		pending:       make(map[uint64]*nackState),
+	}
+	if t.tolerance <= 0 {
+		t.tolerance = defaultNACKReorderTolerance
+	}
+	if t.retryInterval <= 0 {
+		t.retryInterval = defaultNACKRetryInterval
+	}
+	if t.maxRetries <= 0 {
+		t.maxRetries = defaultNACKMaxRetries
+	}
+	return t
+}
+
+// OnMedia registers a received media sequence number (direct or via RTX)
+func (t *nackTracker) OnMedia(seq uint64, at time.Time) {
+	t.Forget(seq)
+	if !t.started {
+		t.started = true
+		t.maxSeq = seq
+		return
+	}
+	if seq <= t.maxSeq {
+		return
+	}
+	// of a gap wider than the list only the newest entries would survive the trim
+	from := t.maxSeq + 1
+	if seq-from > maxNACKPending {
+		from = seq - maxNACKPending
+	}
+	for s := from; s < seq; s++ {
+		st := &nackState{seq: s, nextAt: at.Add(t.tolerance)}
+		t.pending[s] = st
+		heap.Push(&t.due, st)
+	}
+	t.maxSeq = seq
+	t.trim()
+}
+
+// Forget drops a gap that was filled by other means (FEC recovery)
+func (t *nackTracker) Forget(seq uint64) {
+	if st, ok := t.pending[seq]; ok {
+		heap.Remove(&t.due, st.index)
+		delete(t.pending, seq)
+	}
+}
+
+// NextDue returns the earliest time a NACK is due
+func (t *nackTracker) NextDue() (time.Time, bool) {
+	if len(t.due) == 0 {
+		return time.Time{}, false
+	}
+	return t.due[0].nextAt, true
+}
+
+// Due returns all sequence numbers to request at now in sequence order and reschedules them
+func (t *nackTracker) Due(now time.Time) []uint64 {
+	var out []uint64
+	for len(t.due) > 0 && !t.due[0].nextAt.After(now) {
+		st := t.due[0]
+		out = append(out, st.seq)
+		st.retries++
+		if st.retries >= t.maxRetries {
+			heap.Pop(&t.due)
+			delete(t.pending, st.seq)
+		} else {
+			st.nextAt = now.Add(t.retryInterval)
+			heap.Fix(&t.due, 0)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// trim drops the oldest gaps beyond maxNACKPending in one pass
+func (t *nackTracker) trim() {
+	excess := len(t.pending) - maxNACKPending
+	if excess <= 0 {
+		return
+	}
+	seqs := make([]uint64, 0, len(t.pending))
+	for seq := range t.pending {
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	for _, seq := range seqs[:excess] {
+		t.Forget(seq)
+	}
+}
+
+type nackHeap []*nackState
+
+func (h nackHeap) Len() int           { return len(h) }
+func (h nackHeap) Less(i, j int) bool { return h[i].nextAt.Before(h[j].nextAt) }
+func (h nackHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *nackHeap) Push(x any) {
+	st := x.(*nackState)
+	st.index = len(*h)
+	*h = append(*h, st)
+}
+
+func (h *nackHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// rtxSender keeps a history of sent media packets and builds RFC 4588 retransmissions
+// The history is keyed by extended sequence number, so a large history does not alias
+// packets one wrap apart
+type rtxSender struct {
+	ssrc uint32
+	pt   uint8
+	seq  uint16
+
+	size    int
+	seqs    seqUnwrapper
+	order   []uint64
+	history map[uint64]rtp.Packet
+}
+
+func newRTXSender(ids RTPIDs, historySize int) *rtxSender {
+	if historySize <= 0 {
+		historySize = defaultRTXHistorySize
+	}
+	return &rtxSender{
+		ssrc:    ids.RTXSSRC,
+		pt:      ids.RTXPT,
+		size:    historySize,
+		history: make(map[uint64]rtp.Packet, historySize),
+	}
+}
+
+func (s *rtxSender) Store(pkt rtp.Packet) {
+	ext := s.seqs.Unwrap(pkt.SequenceNumber)
+	if _, ok := s.history[ext]; !ok {
+		s.order = append(s.order, ext)
+	}
+	s.history[ext] = pkt
+	for len(s.order) > s.size {
+		delete(s.history, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Retransmission wraps the stored packet seq: OSN (2 bytes) + original payload on the RTX stream
+func (s *rtxSender) Retransmission(seq uint16) (rtp.Packet, bool) {
+	orig, ok := s.history[s.seqs.extend(seq)]
+	if !ok {
+		return rtp.Packet{}, false
+	}
+	payload := make([]byte, 2+len(orig.Payload))
+	binary.BigEndian.PutUint16(payload, seq)
+	copy(payload[2:], orig.Payload)
+
+	h := orig.Header
+	h.SSRC = s.ssrc
+	h.PayloadType = s.pt
+	h.SequenceNumber = s.seq
+	s.seq++
+	return rtp.Packet{Header: h, Payload: payload}, true
+}
+
+// unwrapRTX restores the original media packet from an RFC 4588 retransmission
+func unwrapRTX(pkt rtp.Packet, mediaSSRC uint32, mediaPT uint8) (rtp.Packet, bool) {
+	if len(pkt.Payload) < 2 {
+		return rtp.Packet{}, false
+	}
+	h := pkt.Header
+	h.SequenceNumber = binary.BigEndian.Uint16(pkt.Payload)
+	h.SSRC = mediaSSRC
+	h.PayloadType = mediaPT
+	return rtp.Packet{Header: h, Payload: pkt.Payload[2:]}, true
+}
@@ -0,0 +1,37 @@
+package sim
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNACKTrackerSchedule(t *testing.T) {
+	tr := newNACKTracker(RTXSpec{ReorderTolerance: 10 * time.Millisecond, RetryInterval: 100 * time.Millisecond, MaxRetries: 2})
+	at := time.Unix(0, 0)
+
+	tr.OnMedia(0, at)
+	tr.OnMedia(4, at)                         // gaps 1..3 due at 10ms
+	tr.OnMedia(7, at.Add(5*time.Millisecond)) // gaps 5, 6 due at 15ms
+	tr.Forget(2)
+
+	if next, ok := tr.NextDue(); !ok || !next.Equal(at.Add(10*time.Millisecond)) {
+		t.Fatalf("next due = %v %v, want 10ms", next.Sub(at), ok)
+	}
+	if got := tr.Due(at.Add(12 * time.Millisecond)); !slices.Equal(got, []uint64{1, 3}) {
+		t.Fatalf("due at 12ms = %v, want [1 3]", got)
+	}
+	if next, _ := tr.NextDue(); !next.Equal(at.Add(15 * time.Millisecond)) {
+		t.Fatalf("next due = %v, want 15ms", next.Sub(at))
+	}
+	if got := tr.Due(at.Add(20 * time.Millisecond)); !slices.Equal(got, []uint64{5, 6}) {
+		t.Fatalf("due at 20ms = %v, want [5 6]", got)
+	}
+	// second and last request of every gap, then nothing is left
+	if got := tr.Due(at.Add(time.Second)); !slices.Equal(got, []uint64{1, 3, 5, 6}) {
+		t.Fatalf("due at 1s = %v, want [1 3 5 6]", got)
+	}
+	if _, ok := tr.NextDue(); ok || len(tr.pending) != 0 {
+		t.Fatalf("%d gaps left after the last retry", len(tr.pending))
+	}
+}
@@ -0,0 +1,58 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+// jitterEstimator is the RFC 3550 (6.4.1) interarrival jitter estimator
+// Transit times are taken from virtual send and arrival instants, so no RTP clock rate is needed
+type jitterEstimator struct {
+	hasPrev     bool
+	prevTransit time.Duration
+	jitter      float64 // nanoseconds
+}
+
+func (e *jitterEstimator) Update(sentAt, arrivedAt time.Time) {
+	transit := arrivedAt.Sub(sentAt)
+	if e.hasPrev {
+		d := math.Abs(float64(transit - e.prevTransit))
+		e.jitter += (d - e.jitter) / 16
+	}
+	e.prevTransit = transit
+	e.hasPrev = true
+}
+
+func (e *jitterEstimator) Jitter() time.Duration { return time.Duration(e.jitter) }
+
+// rttWindow averages round-trip samples (forward one-way delay + return path) over one stats window
+// The last measured value is held when a window sees no deliveries
+type rttWindow struct {
+	returnDelay time.Duration
+
+	sum  time.Duration
+	n    int64
+	last time.Duration
+}
+
+func newRTTWindow(initial, returnDelay time.Duration) *rttWindow {
+	return &rttWindow{returnDelay: returnDelay, last: initial}
+}
+
+func (w *rttWindow) Add(sentAt, arrivedAt time.Time) {
+	w.sum += arrivedAt.Sub(sentAt) + w.returnDelay
+	w.n++
+}
+
+// Close ends the window and returns its mean RTT
+func (w *rttWindow) Close() time.Duration {
+	if w.n > 0 {
+		w.last = w.sum / time.Duration(w.n)
+	}
+	w.sum, w.n = 0, 0
+	return w.last
+}
+
+func durationMs(d time.Duration) int {
+	return int(math.Round(float64(d) / float64(time.Millisecond)))
+}
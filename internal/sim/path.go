@@ -0,0 +1,227 @@
+package sim
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// HopSpec is one segment of a multi-hop forward path
+type HopSpec struct {
+	Name string
+	Link LinkSpec
+}
+
+// HopSample is the per-hop state reported with every TimeSample
+type HopSample struct {
+	Name         string
+	QueueDelayMs float64
+	// Dropped counts media, FEC and RTX packets dropped at this hop so far
+	Dropped int64
+}
+
+// HopResult summarises one hop of the forward path over the whole run
+type HopResult struct {
+	Name string
+	// DroppedPkts counts media, FEC and RTX packets dropped at this hop
+	DroppedPkts   int64
+	DropsByReason map[DropReason]int64
+	// TransmittedPkts counts packets of any kind that left the hop's queue;
+	// the queue delays are measured over them
+	TransmittedPkts int64
+	MeanQueueDelay  time.Duration
+	MaxQueueDelay   time.Duration
+}
+
+// Path chains Links: a packet delivered by one hop is offered to the next at its
+// arrival time. Next only hands back deliveries of the last hop and cross traffic
+// leaving the hop it was sent on
+type Path struct {
+	names []string
+	hops  []*Link
+	drops []map[DropReason]int64
+	// after is the base delay of the hops behind each hop, added to ExpectedAt of its drops
+	after []time.Duration
+
+	onDrop func(PacketMeta, DropReason)
+}
+
+func NewPath(hops []HopSpec, start time.Time) *Path {
+	p := &Path{}
+	for i, h := range hops {
+		l := NewLink(h.Link, start)
+		l.OnDrop(func(meta PacketMeta, reason DropReason) { p.drop(i, meta, reason) })
+		p.names = append(p.names, h.Name)
+		p.hops = append(p.hops, l)
+		p.drops = append(p.drops, make(map[DropReason]int64))
+	}
+	p.after = make([]time.Duration, len(hops))
+	for i := len(hops) - 2; i >= 0; i-- {
+		p.after[i] = p.after[i+1] + hops[i+1].Link.BaseOneWayDelay
+	}
+	return p
+}
+
+// Send offers an RTP packet to the first hop; with more hops a packet that was
+// not dropped there is Pending and later drops go to the OnDrop handler
+func (p *Path) Send(pkt rtp.Packet, sentAt time.Time, isFEC bool) SendOutcome {
+	out := p.hops[0].Send(pkt, sentAt, isFEC)
+	if out.Dropped {
+		p.count(0, PacketMeta{}, out.Reason)
+		out.ExpectedAt = out.ExpectedAt.Add(p.after[0])
+	}
+	return p.pending(out)
+}
+
+func (p *Path) SendRTCP(raw []byte, ssrc uint32, sentAt time.Time) SendOutcome {
+	return p.pending(p.hops[0].SendRTCP(raw, ssrc, sentAt))
+}
+
+// SendCross injects competing traffic at hop; it leaves the path after that hop
+func (p *Path) SendCross(hop int, ssrc uint32, seq uint16, size int, sentAt time.Time) SendOutcome {
+	return p.hops[hop].SendCross(ssrc, seq, size, sentAt)
+}
+
+// OnDrop sets the handler for drops decided after Send returned (queue disciplines and later hops)
+func (p *Path) OnDrop(fn func(meta PacketMeta, reason DropReason)) { p.onDrop = fn }
+
+func (p *Path) pending(out SendOutcome) SendOutcome {
+	if len(p.hops) > 1 && !out.Dropped {
+		out.Pending = true
+	}
+	return out
+}
+
+// count books a drop at hop (RTCP and cross traffic are not counted)
+func (p *Path) count(hop int, meta PacketMeta, reason DropReason) {
+	if meta.IsRTCP || meta.IsCross {
+		return
+	}
+	p.drops[hop][reason]++
+}
+
+func (p *Path) drop(hop int, meta PacketMeta, reason DropReason) {
+	meta.ExpectedAt = meta.ExpectedAt.Add(p.after[hop])
+	p.count(hop, meta, reason)
+	if p.onDrop != nil {
+		p.onDrop(meta, reason)
+	}
+}
+
+// NextEvent is the earliest delivery, forward or queue service on any hop
+func (p *Path) NextEvent() (time.Time, bool) {
+	var (
+		next  time.Time
+		found bool
+	)
+	for _, l := range p.hops {
+		if t, ok := l.NextEvent(); ok && (!found || t.Before(next)) {
+			next, found = t, true
+		}
+	}
+	return next, found
+}
+
+// Service runs one queue service or hands one packet to the next hop; it reports
+// whether anything was due at now (deliveries at the same time come after it)
+func (p *Path) Service(now time.Time) bool {
+	for _, l := range p.hops {
+		if l.Service(now) {
+			return true
+		}
+	}
+	last := len(p.hops) - 1
+	for i, l := range p.hops[:last] {
+		ev, ok := l.peek()
+		if !ok || ev.at.After(now) || ev.cross != nil {
+			continue
+		}
+		dp, _ := l.Next()
+		out := p.hops[i+1].forward(ev, dp.Arrives)
+		if out.Dropped {
+			meta := ev.meta
+			meta.ExpectedAt = out.ExpectedAt
+			p.drop(i+1, meta, out.Reason)
+		}
+		return true
+	}
+	return false
+}
+
+// Next pops the earliest packet leaving the path
+func (p *Path) Next() (DeliveredPacket, bool) {
+	last := len(p.hops) - 1
+	best := -1
+	var at time.Time
+	for i, l := range p.hops {
+		ev, ok := l.peek()
+		if !ok || (i < last && ev.cross == nil) {
+			continue
+		}
+		if best < 0 || ev.at.Before(at) {
+			best, at = i, ev.at
+		}
+	}
+	if best < 0 {
+		return DeliveredPacket{}, false
+	}
+	return p.hops[best].Next()
+}
+
+// QueueDelay is the sum of the current queueing delays of all hops
+func (p *Path) QueueDelay(now time.Time) time.Duration {
+	var d time.Duration
+	for _, l := range p.hops {
+		d += l.QueueDelay(now)
+	}
+	return d
+}
+
+func (p *Path) Samples(now time.Time) []HopSample {
+	out := make([]HopSample, len(p.hops))
+	for i, l := range p.hops {
+		out[i] = HopSample{
+			Name:         p.names[i],
+			QueueDelayMs: float64(l.QueueDelay(now).Milliseconds()),
+			Dropped:      sumDrops(p.drops[i]),
+		}
+	}
+	return out
+}
+
+func (p *Path) Results() []HopResult {
+	out := make([]HopResult, len(p.hops))
+	for i, l := range p.hops {
+		r := HopResult{
+			Name:            p.names[i],
+			DroppedPkts:     sumDrops(p.drops[i]),
+			DropsByReason:   p.drops[i],
+			TransmittedPkts: l.txPkts,
+			MaxQueueDelay:   l.maxQueueDelay,
+		}
+		if l.txPkts > 0 {
+			r.MeanQueueDelay = l.queueDelaySum / time.Duration(l.txPkts)
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// capacityBps is the link rate at to (a trace's mean rate over from..to); false if unlimited
+func (s LinkSpec) capacityBps(from, to time.Duration) (float64, bool) {
+	switch {
+	case s.Trace != nil:
+		return s.Trace.RateBps(from, to), true
+	case s.CapacityBps != nil:
+		return s.CapacityBps.At(to), true
+	}
+	return 0, false
+}
+
+func sumDrops(m map[DropReason]int64) int64 {
+	var n int64
+	for _, v := range m {
+		n += v
+	}
+	return n
+}
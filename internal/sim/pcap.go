@@ -0,0 +1,257 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Interfaces of a capture: packets leaving the FEC interceptor, packets delivered to the
+// Receiver, and packets the forward path dropped (with the reason as comment)
+const (
+	pcapSender = iota
+	pcapReceiver
+	pcapDropped
+)
+
+// pcapng block types, options and the link type of raw IPv4
+const (
+	pcapngSHB = 0x0a0d0d0a
+	pcapngIDB = 0x00000001
+	pcapngEPB = 0x00000006
+
+	pcapngOptEnd     = 0
+	pcapngOptComment = 1
+	pcapngOptName    = 2 // if_name
+	pcapngOptDescr   = 3 // if_description
+	pcapngOptUserApp = 4 // shb_userappl
+	pcapngOptTSResol = 9
+
+	linkTypeRaw = 101
+)
+
+// Synthetic addresses of the capture: every RTP packet goes from 10.0.0.1 to 10.0.0.2 on
+// UDP port 5004
+var (
+	pcapSrcIP = [4]byte{10, 0, 0, 1}
+	pcapDstIP = [4]byte{10, 0, 0, 2}
+)
+
+const pcapPort = 5004
+
+// pcapKey identifies a packet in flight by its extended sequence number, so a packet
+// still queued when the sequence number wraps is never mistaken for a later one
+type pcapKey struct {
+	ssrc uint32
+	seq  uint64
+}
+
+// PcapWriter captures the RTP packets of a run as pcapng (no libpcap): what leaves the FlexFEC
+// interceptor, what the forward path delivers to the Receiver and what it drops. Packets carry
+// virtual timestamps (ns) and synthetic IPv4/UDP headers. The first write error is kept and
+// returned by Close
+type PcapWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	err error
+
+	ipID uint16
+	// sent packets not yet delivered or dropped, so drops can show the packet
+	inFlight map[pcapKey][]byte
+	seqs     map[uint32]*seqUnwrapper
+}
+
+func NewPcapWriter(path string) (*PcapWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &PcapWriter{
+		f:        f,
+		w:        bufio.NewWriter(f),
+		inFlight: make(map[pcapKey][]byte),
+		seqs:     make(map[uint32]*seqUnwrapper),
+	}
+
+	var shb []byte
+	shb = binary.LittleEndian.AppendUint32(shb, 0x1a2b3c4d) // byte-order magic
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0)) // section length not given
+	shb = pcapngOption(shb, pcapngOptUserApp, []byte("ersim"))
+	p.block(pcapngSHB, pcapngOption(shb, pcapngOptEnd, nil))
+
+	for _, itf := range []struct{ name, descr string }{
+		{"sender", "RTP leaving the FlexFEC interceptor"},
+		{"receiver", "RTP delivered to the receiver"},
+		{"dropped", "RTP dropped on the forward path"},
+	} {
+		var idb []byte
+		idb = binary.LittleEndian.AppendUint16(idb, linkTypeRaw)
+		idb = binary.LittleEndian.AppendUint16(idb, 0)
+		idb = binary.LittleEndian.AppendUint32(idb, 0) // no snap length
+		idb = pcapngOption(idb, pcapngOptName, []byte(itf.name))
+		idb = pcapngOption(idb, pcapngOptDescr, []byte(itf.descr))
+		idb = pcapngOption(idb, pcapngOptTSResol, []byte{9})
+		p.block(pcapngIDB, pcapngOption(idb, pcapngOptEnd, nil))
+	}
+	if p.err != nil {
+		_ = f.Close()
+		return nil, p.err
+	}
+	return p, nil
+}
+
+// Sent records pkt leaving the sender at at
+func (p *PcapWriter) Sent(pkt rtp.Packet, at time.Time) {
+	b, err := pkt.Marshal()
+	if err != nil {
+		p.fail(err)
+		return
+	}
+	u, ok := p.seqs[pkt.SSRC]
+	if !ok {
+		u = &seqUnwrapper{}
+		p.seqs[pkt.SSRC] = u
+	}
+	p.inFlight[pcapKey{pkt.SSRC, u.Unwrap(pkt.SequenceNumber)}] = b
+	p.packet(pcapSender, at, b, "")
+}
+
+// Delivered records pkt arriving at the receiver at at
+func (p *PcapWriter) Delivered(pkt rtp.Packet, at time.Time) {
+	b, err := pkt.Marshal()
+	if err != nil {
+		p.fail(err)
+		return
+	}
+	if k, ok := p.key(pkt.SSRC, pkt.SequenceNumber); ok {
+		delete(p.inFlight, k)
+	}
+	p.packet(pcapReceiver, at, b, "")
+}
+
+// Dropped records the drop of a sent RTP packet at at, commented with the reason
+func (p *PcapWriter) Dropped(meta PacketMeta, reason DropReason, at time.Time) {
+	k, ok := p.key(meta.SSRC, meta.Seq)
+	if !ok {
+		return
+	}
+	b, ok := p.inFlight[k]
+	if !ok {
+		return
+	}
+	delete(p.inFlight, k)
+	p.packet(pcapDropped, at, b, "dropped: "+string(reason))
+}
+
+// key extends seq relative to the packets sent on ssrc (false if none were)
+func (p *PcapWriter) key(ssrc uint32, seq uint16) (pcapKey, bool) {
+	u, ok := p.seqs[ssrc]
+	if !ok {
+		return pcapKey{}, false
+	}
+	return pcapKey{ssrc, u.extend(seq)}, true
+}
+
+func (p *PcapWriter) Close() error {
+	if err := p.w.Flush(); err != nil {
+		p.fail(err)
+	}
+	if err := p.f.Close(); err != nil {
+		p.fail(err)
+	}
+	return p.err
+}
+
+// packet writes an Enhanced Packet Block with payload wrapped in IPv4/UDP
+func (p *PcapWriter) packet(itf uint32, at time.Time, payload []byte, comment string) {
+	data := p.ipUDP(payload)
+	ts := uint64(at.UnixNano())
+
+	var epb []byte
+	epb = binary.LittleEndian.AppendUint32(epb, itf)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = append(epb, data...)
+	epb = append(epb, make([]byte, pad4(len(data)))...)
+	if comment != "" {
+		epb = pcapngOption(epb, pcapngOptComment, []byte(comment))
+		epb = pcapngOption(epb, pcapngOptEnd, nil)
+	}
+	p.block(pcapngEPB, epb)
+}
+
+// ipUDP prepends the synthetic IPv4 and UDP headers (UDP checksum 0, i.e. none)
+func (p *PcapWriter) ipUDP(payload []byte) []byte {
+	b := make([]byte, 28+len(payload))
+	b[0] = 0x45 // IPv4, 20 byte header
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	binary.BigEndian.PutUint16(b[4:], p.ipID)
+	p.ipID++
+	b[6] = 0x40 // don't fragment
+	b[8] = 64   // TTL
+	b[9] = 17   // UDP
+	copy(b[12:16], pcapSrcIP[:])
+	copy(b[16:20], pcapDstIP[:])
+	binary.BigEndian.PutUint16(b[10:], ipChecksum(b[:20]))
+
+	binary.BigEndian.PutUint16(b[20:], pcapPort)
+	binary.BigEndian.PutUint16(b[22:], pcapPort)
+	binary.BigEndian.PutUint16(b[24:], uint16(8+len(payload)))
+	copy(b[28:], payload)
+	return b
+}
+
+// block writes a pcapng block; body must be padded to 32 bits
+func (p *PcapWriter) block(typ uint32, body []byte) {
+	if p.err != nil {
+		return
+	}
+	n := uint32(12 + len(body))
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	if _, err := p.w.Write(b); err != nil {
+		p.fail(err)
+	}
+}
+
+func (p *PcapWriter) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// pcapngOption appends an option padded to 32 bits
+func pcapngOption(b []byte, code uint16, val []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(val)))
+	b = append(b, val...)
+	return append(b, make([]byte, pad4(len(val)))...)
+}
+
+func pad4(n int) int { return (4 - n%4) % 4 }
+
+// ipChecksum is the Internet checksum (RFC 1071) of an IPv4 header
+func ipChecksum(h []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(h); i += 2 {
+		sum += uint32(h[i])<<8 | uint32(h[i+1])
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
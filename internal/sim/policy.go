@@ -0,0 +1,136 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+	"github.com/lars-sto/error-recovery-simulation/internal/adapter"
+)
+
+// Policy decides the FEC configuration once per stats window. Decisions go through the
+// FlexFECAdapter/RuntimeBus path to the encoder, the same for every implementation
+type Policy interface {
+	// Decide sees the window that just ended; ok = false keeps the current configuration
+	Decide(in PolicyInput) (d recovery.PolicyDecision, ok bool, err error)
+	Close() error
+}
+
+// PolicyFactory creates the Policy of one run
+type PolicyFactory func(env PolicyEnv) (Policy, error)
+
+// PolicyEnv describes the run a Policy is created for
+type PolicyEnv struct {
+	Scenario Scenario
+	Seed     int64
+	// Engine is the controller config of the run (RunOptions.Engine or DefaultEngineConfig)
+	Engine recovery.Config
+	// MaxFECPackets is the largest R the baselines use: K x Engine.MaxOverhead
+	MaxFECPackets uint32
+	// StatsInterval is the length of the windows passed to Decide
+	StatsInterval time.Duration
+
+	oracleTarget float64
+	// hops is the seeded forward path (the oracle asks its loss models ahead of time)
+	hops []HopSpec
+}
+
+// PolicyInput is one stats window as seen by a Policy
+type PolicyInput struct {
+	// T is the end of the window since the start of the run
+	T      time.Duration
+	Window time.Duration
+	// Stats is what the adaptive engine sees (loss, jitter and RTT from RTCP with Scenario.Feedback)
+	Stats recovery.NetworkStats
+	// TrueLoss is the media loss before recovery on the forward path in the window;
+	// CongestionLoss is the part of it dropped by queues and AQM
+	TrueLoss       float64
+	CongestionLoss float64
+	// Current is the FEC configuration in effect during the window
+	Current recovery.FECDecision
+}
+
+// builtinPolicy is the Policy of mode (nil for ModeStatic)
+func builtinPolicy(mode Mode) (PolicyFactory, error) {
+	switch mode {
+	case ModeStatic:
+		return nil, nil
+	case ModeAdaptive:
+		return NewEnginePolicy, nil
+	case ModeOracle:
+		return newOraclePolicy, nil
+	case ModeLossProportional:
+		return newProportionalPolicy, nil
+	default:
+		return nil, fmt.Errorf("mode %q needs RunOptions.Policy", mode)
+	}
+}
+
+// enginePolicy runs the AERC engine on its own goroutine in lock step with the simulation
+type enginePolicy struct {
+	src      *simStatsSource
+	observer *simObserver
+	cancel   context.CancelFunc
+
+	decision recovery.PolicyDecision
+	changed  bool
+}
+
+// NewEnginePolicy wraps recovery.NewEngine with env.Engine
+func NewEnginePolicy(env PolicyEnv) (Policy, error) {
+	p := &enginePolicy{
+		src:      newSimStatsSource(),
+		observer: &simObserver{processed: make(chan struct{}, 16)},
+	}
+	sink := adapter.SinkFunc(func(d recovery.PolicyDecision) {
+		p.decision, p.changed = d, true
+	})
+	engine := recovery.NewEngine(env.Engine, p.src, sink, p.observer)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go engine.Run(ctx)
+	return p, nil
+}
+
+func (p *enginePolicy) Decide(in PolicyInput) (recovery.PolicyDecision, bool, error) {
+	p.changed = false
+	p.src.ch <- in.Stats
+	// Ensure engine has consumed this stats sample (even if it didn't publish a new decision)
+	<-p.observer.processed
+	return p.decision, p.changed, nil
+}
+
+func (p *enginePolicy) Close() error {
+	p.src.Close()
+	p.cancel()
+	// give engine goroutine a chance to exit cleanly
+	runtime.Gosched()
+	return nil
+}
+
+type simStatsSource struct {
+	ch chan recovery.NetworkStats
+}
+
+func newSimStatsSource() *simStatsSource {
+	// unbuffered helps ordering; we additionally wait for observer-ack
+	return &simStatsSource{ch: make(chan recovery.NetworkStats)}
+}
+
+func (s *simStatsSource) Stats() <-chan recovery.NetworkStats { return s.ch }
+func (s *simStatsSource) Close()                              { close(s.ch) }
+
+type simObserver struct {
+	processed chan struct{}
+}
+
+func (o *simObserver) OnSample(_ recovery.NetworkStats, _ recovery.PolicyDecision, _ bool) {
+	// signal "engine processed one stats sample"
+	select {
+	case o.processed <- struct{}{}:
+	default:
+		// never block
+	}
+}
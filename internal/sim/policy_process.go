@@ -0,0 +1,226 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+)
+
+// NewProcessPolicy runs argv as the controller of every run (one process per run) and talks
+// JSON lines over its stdin/stdout: an "init" message first, then one "window" message per
+// stats window, each answered by one decision line ({} or null keeps the configuration).
+// The simulation waits for every answer, so runs stay deterministic if the controller is.
+// A controller that doesn't answer within processTimeout is killed and fails the run
+func NewProcessPolicy(argv []string) PolicyFactory {
+	return func(env PolicyEnv) (Policy, error) {
+		if len(argv) == 0 {
+			return nil, errors.New("policy process: empty command")
+		}
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("policy process %q: %w", argv[0], err)
+		}
+
+		p := &processPolicy{
+			name:  strings.Join(argv, " "),
+			cmd:   cmd,
+			stdin: stdin,
+			enc:   json.NewEncoder(stdin),
+			lines: make(chan string),
+			done:  make(chan struct{}),
+		}
+		go p.read(stdout)
+
+		if err := p.send(processInit{
+			Type:            "init",
+			Scenario:        env.Scenario.Name,
+			Seed:            env.Seed,
+			K:               env.Scenario.K,
+			StaticR:         env.Scenario.StaticR,
+			MaxFECPackets:   env.MaxFECPackets,
+			StatsIntervalMs: env.StatsInterval.Milliseconds(),
+		}); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+// processTimeout bounds each answer of a controller process and its exit after Close
+var processTimeout = 30 * time.Second
+
+type processPolicy struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	enc   *json.Encoder
+	// lines carries the controller's output; readErr is set before it is closed
+	lines   chan string
+	readErr error
+	done    chan struct{}
+}
+
+// read forwards stdout line by line until the process ends or the policy is closed
+func (p *processPolicy) read(stdout io.Reader) {
+	defer close(p.lines)
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		select {
+		case p.lines <- sc.Text():
+		case <-p.done:
+			return
+		}
+	}
+	p.readErr = sc.Err()
+}
+
+type processInit struct {
+	Type            string `json:"type"`
+	Scenario        string `json:"scenario"`
+	Seed            int64  `json:"seed"`
+	K               uint32 `json:"k"`
+	StaticR         uint32 `json:"static_r"`
+	MaxFECPackets   uint32 `json:"max_fec_packets"`
+	StatsIntervalMs int64  `json:"stats_interval_ms"`
+}
+
+type processWindow struct {
+	Type              string     `json:"type"`
+	TMs               int64      `json:"t_ms"`
+	WindowMs          int64      `json:"window_ms"`
+	LossRate          float64    `json:"loss_rate"`
+	RTTMs             int        `json:"rtt_ms"`
+	JitterMs          int        `json:"jitter_ms"`
+	TargetBitrateBps  float64    `json:"target_bitrate_bps"`
+	CurrentBitrateBps float64    `json:"current_bitrate_bps"`
+	TrueLoss          float64    `json:"true_loss"`
+	CongestionLoss    float64    `json:"congestion_loss"`
+	FEC               processFEC `json:"fec"`
+}
+
+// processFEC is a FEC configuration on the wire, in both directions
+type processFEC struct {
+	Enabled          *bool  `json:"enabled,omitempty"`
+	NumMediaPackets  uint32 `json:"num_media_packets,omitempty"`
+	NumFECPackets    uint32 `json:"num_fec_packets"`
+	CoverageMode     string `json:"coverage_mode,omitempty"`
+	InterleaveStride uint32 `json:"interleave_stride,omitempty"`
+	BurstSpan        uint32 `json:"burst_span,omitempty"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+func (p *processPolicy) Decide(in PolicyInput) (recovery.PolicyDecision, bool, error) {
+	enabled := in.Current.Enabled
+	err := p.send(processWindow{
+		Type:              "window",
+		TMs:               in.T.Milliseconds(),
+		WindowMs:          in.Window.Milliseconds(),
+		LossRate:          in.Stats.LossRate,
+		RTTMs:             in.Stats.RTTMs,
+		JitterMs:          in.Stats.JitterMs,
+		TargetBitrateBps:  in.Stats.TargetBitrate,
+		CurrentBitrateBps: in.Stats.CurrentBitrate,
+		TrueLoss:          in.TrueLoss,
+		CongestionLoss:    in.CongestionLoss,
+		FEC: processFEC{
+			Enabled:         &enabled,
+			NumMediaPackets: in.Current.NumMediaPackets,
+			NumFECPackets:   in.Current.NumFECPackets,
+		},
+	})
+	if err != nil {
+		return recovery.PolicyDecision{}, false, err
+	}
+
+	var line string
+	select {
+	case l, ok := <-p.lines:
+		if !ok {
+			err := p.readErr
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return recovery.PolicyDecision{}, false, fmt.Errorf("policy process %q: no decision for t=%v: %w", p.name, in.T, err)
+		}
+		line = strings.TrimSpace(l)
+	case <-time.After(processTimeout):
+		_ = p.cmd.Process.Kill()
+		return recovery.PolicyDecision{}, false, fmt.Errorf("policy process %q: no decision for t=%v within %v, killed", p.name, in.T, processTimeout)
+	}
+	// only {} and null keep the configuration; {"num_fec_packets":0} turns FEC off
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return recovery.PolicyDecision{}, false, fmt.Errorf("policy process %q: decision %q: %w", p.name, line, err)
+	}
+	if len(fields) == 0 {
+		return recovery.PolicyDecision{}, false, nil
+	}
+	var f processFEC
+	if err := json.Unmarshal([]byte(line), &f); err != nil {
+		return recovery.PolicyDecision{}, false, fmt.Errorf("policy process %q: decision %q: %w", p.name, line, err)
+	}
+
+	d := recovery.FECDecision{
+		Enabled:          f.NumFECPackets > 0,
+		NumMediaPackets:  f.NumMediaPackets,
+		NumFECPackets:    f.NumFECPackets,
+		CoverageMode:     recovery.CoverageMode(f.CoverageMode),
+		InterleaveStride: f.InterleaveStride,
+		BurstSpan:        f.BurstSpan,
+		Reason:           f.Reason,
+	}
+	if f.Enabled != nil {
+		d.Enabled = *f.Enabled
+	}
+	if d.NumMediaPackets == 0 {
+		d.NumMediaPackets = in.Current.NumMediaPackets
+	}
+	d.TargetOverhead = overhead(d.NumMediaPackets, d.NumFECPackets)
+	return recovery.PolicyDecision{FEC: d}, true, nil
+}
+
+func (p *processPolicy) send(msg any) error {
+	if err := p.enc.Encode(msg); err != nil {
+		return fmt.Errorf("policy process %q: %w", p.name, err)
+	}
+	return nil
+}
+
+// Close ends the controller's input and waits for it to exit; one that keeps running past
+// processTimeout is killed
+func (p *processPolicy) Close() error {
+	_ = p.stdin.Close()
+	close(p.done)
+	exited := make(chan error, 1)
+	go func() { exited <- p.cmd.Wait() }()
+	select {
+	case err := <-exited:
+		if err != nil {
+			return fmt.Errorf("policy process %q: %w", p.name, err)
+		}
+		return nil
+	case <-time.After(processTimeout):
+		_ = p.cmd.Process.Kill()
+		<-exited
+		return fmt.Errorf("policy process %q: still running %v after its input was closed, killed", p.name, processTimeout)
+	}
+}
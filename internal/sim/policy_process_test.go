@@ -0,0 +1,93 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+)
+
+// TestPolicyProcessHelper is the controller process of the tests below: it answers every
+// window with $ERSIM_TEST_ANSWER, or never answers when that is "hang"
+func TestPolicyProcessHelper(t *testing.T) {
+	answer := os.Getenv("ERSIM_TEST_ANSWER")
+	if answer == "" {
+		return
+	}
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		if !strings.Contains(sc.Text(), `"window"`) {
+			continue
+		}
+		if answer == "hang" {
+			time.Sleep(time.Hour)
+		}
+		fmt.Println(answer)
+	}
+	os.Exit(0)
+}
+
+func testProcessPolicy(t *testing.T, answer string) Policy {
+	t.Helper()
+	t.Setenv("ERSIM_TEST_ANSWER", answer)
+	p, err := NewProcessPolicy([]string{os.Args[0], "-test.run=^TestPolicyProcessHelper$"})(PolicyEnv{
+		Scenario:      Scenario{Name: "test", K: 10, StaticR: 2},
+		StatsInterval: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+var testPolicyInput = PolicyInput{
+	T:       time.Second,
+	Window:  time.Second,
+	Current: recovery.FECDecision{Enabled: true, NumMediaPackets: 10, NumFECPackets: 2},
+}
+
+func TestProcessPolicyDecisions(t *testing.T) {
+	for _, tc := range []struct {
+		answer  string
+		ok      bool
+		enabled bool
+		r       uint32
+	}{
+		{answer: `{}`},
+		{answer: `null`},
+		{answer: `{"num_fec_packets":0}`, ok: true},
+		{answer: `{"num_fec_packets":3}`, ok: true, enabled: true, r: 3},
+	} {
+		p := testProcessPolicy(t, tc.answer)
+		d, ok, err := p.Decide(testPolicyInput)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.answer, err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatalf("%s: close: %v", tc.answer, err)
+		}
+		if ok != tc.ok || d.FEC.Enabled != tc.enabled || d.FEC.NumFECPackets != tc.r {
+			t.Errorf("%s: got ok=%v enabled=%v r=%d, want ok=%v enabled=%v r=%d",
+				tc.answer, ok, d.FEC.Enabled, d.FEC.NumFECPackets, tc.ok, tc.enabled, tc.r)
+		}
+	}
+}
+
+func TestProcessPolicyTimeout(t *testing.T) {
+	defer func(d time.Duration) { processTimeout = d }(processTimeout)
+	processTimeout = 200 * time.Millisecond
+
+	p := testProcessPolicy(t, "hang")
+	start := time.Now()
+	if _, _, err := p.Decide(testPolicyInput); err == nil {
+		t.Fatal("Decide returned without an answer")
+	}
+	_ = p.Close()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("hanging controller held the run for %v", d)
+	}
+}
@@ -0,0 +1,532 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+// QueueDiscipline selects how a Link manages its bottleneck queue
+type QueueDiscipline string
+
+const (
+	// QueueDropTail is a FIFO bounded by LinkSpec.MaxQueueDelay and, optionally, LimitBytes/LimitPackets
+	QueueDropTail QueueDiscipline = "drop_tail"
+	// QueueCoDel drops at dequeue once the sojourn time stays above Target for an Interval (RFC 8289)
+	QueueCoDel QueueDiscipline = "codel"
+	// QueuePIE drops at enqueue with a probability driven by the queue delay (RFC 8033)
+	QueuePIE QueueDiscipline = "pie"
+	// QueueFQCoDel schedules per-SSRC flows with DRR and runs CoDel on each flow (RFC 8290)
+	QueueFQCoDel QueueDiscipline = "fq_codel"
+)
+
+const (
+	defaultCoDelTarget   = 5 * time.Millisecond
+	defaultCoDelInterval = 100 * time.Millisecond
+	defaultPIETarget     = 15 * time.Millisecond
+	defaultPIEUpdate     = 15 * time.Millisecond
+	defaultPIEAlpha      = 0.125
+	defaultPIEBeta       = 1.25
+	pieMaxBurst          = 150 * time.Millisecond
+	defaultFQQuantum     = 1514
+	defaultFQLimit       = 10240
+
+	// queueMTU is the "one packet" threshold of CoDel and PIE
+	queueMTU = 1500
+)
+
+// QueueSpec configures the queue discipline of a Link; the zero value is today's
+// delay-bounded drop-tail queue
+// With any other setting packets are really queued and dequeued as the link frees up,
+// and MaxQueueDelay drops packets whose sojourn time exceeds it at dequeue
+type QueueSpec struct {
+	Discipline QueueDiscipline
+
+	// LimitBytes and LimitPackets bound the queue (0 = unlimited; FQ-CoDel defaults to 10240 packets)
+	LimitBytes   int
+	LimitPackets int
+
+	// Target queue delay (CoDel/FQ-CoDel default 5ms, PIE default 15ms)
+	Target time.Duration
+	// Interval is the CoDel/FQ-CoDel sliding window (default 100ms)
+	Interval time.Duration
+
+	// PIE drop probability update interval (default 15ms) and gains (default 0.125 and 1.25)
+	UpdateInterval time.Duration
+	Alpha          float64
+	Beta           float64
+
+	// Quantum is the FQ-CoDel DRR quantum in bytes (default 1514)
+	Quantum int
+}
+
+// eventDriven reports whether the queue needs packet-by-packet dequeue events
+func (q QueueSpec) eventDriven() bool {
+	return (q.Discipline != "" && q.Discipline != QueueDropTail) || q.LimitBytes > 0 || q.LimitPackets > 0
+}
+
+type queuedPacket struct {
+	meta  PacketMeta
+	ev    *deliveryEvent
+	enqAt time.Time
+}
+
+type dropFunc func(p *queuedPacket, reason DropReason)
+
+// qdisc is a queue that is dequeued whenever the link becomes free
+type qdisc interface {
+	enqueue(p *queuedPacket, now time.Time, drop dropFunc)
+	// dequeue returns the next packet to transmit (nil when empty); AQM drops are reported via drop
+	dequeue(now time.Time, drop dropFunc) *queuedPacket
+	empty() bool
+	// oldest is the enqueue time of the longest waiting packet
+	oldest() (time.Time, bool)
+}
+
+func newQdisc(spec QueueSpec, seed int64) qdisc {
+	if !spec.eventDriven() {
+		return nil
+	}
+	switch spec.Discipline {
+	case QueueCoDel:
+		return &codelQueue{fifo: newFIFO(spec), codel: newCoDel(spec)}
+	case QueuePIE:
+		return newPIEQueue(spec, seed)
+	case QueueFQCoDel:
+		return newFQCoDel(spec)
+	default:
+		return &fifoQueue{fifo: newFIFO(spec)}
+	}
+}
+
+// fifo is a byte/packet-limited packet FIFO
+type fifo struct {
+	pkts       []*queuedPacket
+	bytes      int
+	limitBytes int
+	limitPkts  int
+}
+
+func newFIFO(spec QueueSpec) fifo {
+	return fifo{limitBytes: spec.LimitBytes, limitPkts: spec.LimitPackets}
+}
+
+func (f *fifo) full(size int) bool {
+	return (f.limitPkts > 0 && len(f.pkts) >= f.limitPkts) ||
+		(f.limitBytes > 0 && f.bytes+size > f.limitBytes)
+}
+
+func (f *fifo) push(p *queuedPacket) {
+	f.pkts = append(f.pkts, p)
+	f.bytes += p.meta.SizeBytes
+}
+
+func (f *fifo) pop() *queuedPacket {
+	if len(f.pkts) == 0 {
+		return nil
+	}
+	p := f.pkts[0]
+	f.pkts[0] = nil
+	f.pkts = f.pkts[1:]
+	f.bytes -= p.meta.SizeBytes
+	return p
+}
+
+func (f *fifo) empty() bool { return len(f.pkts) == 0 }
+
+func (f *fifo) oldest() (time.Time, bool) {
+	if len(f.pkts) == 0 {
+		return time.Time{}, false
+	}
+	return f.pkts[0].enqAt, true
+}
+
+// fifoQueue is drop-tail with byte/packet limits
+type fifoQueue struct{ fifo }
+
+func (q *fifoQueue) enqueue(p *queuedPacket, _ time.Time, drop dropFunc) {
+	if q.full(p.meta.SizeBytes) {
+		drop(p, DropQueue)
+		return
+	}
+	q.push(p)
+}
+
+func (q *fifoQueue) dequeue(time.Time, dropFunc) *queuedPacket { return q.pop() }
+
+// codel is the RFC 8289 dequeue-side control law
+type codel struct {
+	target   time.Duration
+	interval time.Duration
+
+	aboveSet       bool
+	firstAboveTime time.Time
+	dropping       bool
+	dropNext       time.Time
+	count          uint32
+	lastCount      uint32
+}
+
+func newCoDel(spec QueueSpec) codel {
+	c := codel{target: spec.Target, interval: spec.Interval}
+	if c.target <= 0 {
+		c.target = defaultCoDelTarget
+	}
+	if c.interval <= 0 {
+		c.interval = defaultCoDelInterval
+	}
+	return c
+}
+
+func (c *codel) okToDrop(sojourn time.Duration, now time.Time, backlog int) bool {
+	if sojourn < c.target || backlog <= queueMTU {
+		c.aboveSet = false
+		return false
+	}
+	if !c.aboveSet {
+		c.aboveSet = true
+		c.firstAboveTime = now.Add(c.interval)
+		return false
+	}
+	return !now.Before(c.firstAboveTime)
+}
+
+func (c *codel) controlLaw(t time.Time) time.Time {
+	return t.Add(time.Duration(float64(c.interval) / math.Sqrt(float64(c.count))))
+}
+
+// shouldDrop decides for a packet just taken from the queue; backlog is what remains queued
+func (c *codel) shouldDrop(p *queuedPacket, now time.Time, backlog int) bool {
+	ok := c.okToDrop(now.Sub(p.enqAt), now, backlog)
+	if c.dropping {
+		if !ok {
+			c.dropping = false
+			return false
+		}
+		if !now.Before(c.dropNext) {
+			c.count++
+			c.dropNext = c.controlLaw(c.dropNext)
+			return true
+		}
+		return false
+	}
+	if !ok {
+		return false
+	}
+	c.dropping = true
+	delta := c.count - c.lastCount
+	if delta > 1 && now.Sub(c.dropNext) < 16*c.interval {
+		c.count = delta
+	} else {
+		c.count = 1
+	}
+	c.lastCount = c.count
+	c.dropNext = c.controlLaw(now)
+	return true
+}
+
+type codelQueue struct {
+	fifo
+	codel codel
+}
+
+func (q *codelQueue) enqueue(p *queuedPacket, _ time.Time, drop dropFunc) {
+	if q.full(p.meta.SizeBytes) {
+		drop(p, DropQueue)
+		return
+	}
+	q.push(p)
+}
+
+func (q *codelQueue) dequeue(now time.Time, drop dropFunc) *queuedPacket {
+	for {
+		p := q.pop()
+		if p == nil {
+			return nil
+		}
+		// backlog includes p so a single queued MTU-sized packet is never dropped
+		if !q.codel.shouldDrop(p, now, q.bytes+p.meta.SizeBytes) {
+			return p
+		}
+		drop(p, DropCoDel)
+	}
+}
+
+// pieQueue is RFC 8033 PIE with timestamp-based queue delay; probability updates that
+// fall between packets are applied lazily on the next enqueue or dequeue
+type pieQueue struct {
+	fifo
+
+	target      time.Duration
+	update      time.Duration
+	alpha, beta float64
+
+	prob       float64
+	qdelayOld  time.Duration
+	burst      time.Duration
+	nextUpdate time.Time
+	started    bool
+
+	rng uint64
+}
+
+func newPIEQueue(spec QueueSpec, seed int64) *pieQueue {
+	q := &pieQueue{
+		fifo:   newFIFO(spec),
+		target: spec.Target,
+		update: spec.UpdateInterval,
+		alpha:  spec.Alpha,
+		beta:   spec.Beta,
+		burst:  pieMaxBurst,
+		rng:    splitmix64(uint64(seed) ^ 0x706965),
+	}
+	if q.target <= 0 {
+		q.target = defaultPIETarget
+	}
+	if q.update <= 0 {
+		q.update = defaultPIEUpdate
+	}
+	if q.alpha <= 0 {
+		q.alpha = defaultPIEAlpha
+	}
+	if q.beta <= 0 {
+		q.beta = defaultPIEBeta
+	}
+	return q
+}
+
+func (q *pieQueue) qdelay(now time.Time) time.Duration {
+	if t, ok := q.oldest(); ok {
+		return now.Sub(t)
+	}
+	return 0
+}
+
+func (q *pieQueue) advance(now time.Time) {
+	if !q.started {
+		q.started = true
+		q.nextUpdate = now.Add(q.update)
+		return
+	}
+	for !now.Before(q.nextUpdate) {
+		q.updateProb(q.qdelay(now))
+		q.nextUpdate = q.nextUpdate.Add(q.update)
+	}
+}
+
+func (q *pieQueue) updateProb(qdelay time.Duration) {
+	p := q.alpha*(qdelay-q.target).Seconds() + q.beta*(qdelay-q.qdelayOld).Seconds()
+	// auto-tuning: smaller steps while the drop probability is small
+	switch {
+	case q.prob < 0.000001:
+		p /= 2048
+	case q.prob < 0.00001:
+		p /= 512
+	case q.prob < 0.0001:
+		p /= 128
+	case q.prob < 0.001:
+		p /= 32
+	case q.prob < 0.01:
+		p /= 8
+	case q.prob < 0.1:
+		p /= 2
+	}
+	if q.prob >= 0.1 && p > 0.02 {
+		p = 0.02
+	}
+	q.prob += p
+	if qdelay == 0 && q.qdelayOld == 0 {
+		q.prob *= 0.98
+	}
+	q.prob = clamp01(q.prob)
+
+	if q.prob == 0 && qdelay < q.target/2 && q.qdelayOld < q.target/2 {
+		q.burst = pieMaxBurst
+	} else if q.burst -= q.update; q.burst < 0 {
+		q.burst = 0
+	}
+	q.qdelayOld = qdelay
+}
+
+func (q *pieQueue) enqueue(p *queuedPacket, now time.Time, drop dropFunc) {
+	q.advance(now)
+	if q.full(p.meta.SizeBytes) {
+		drop(p, DropQueue)
+		return
+	}
+	if q.randomDrop() {
+		drop(p, DropPIE)
+		return
+	}
+	q.push(p)
+}
+
+func (q *pieQueue) randomDrop() bool {
+	if q.burst > 0 {
+		return false
+	}
+	if q.qdelayOld < q.target/2 && q.prob < 0.2 {
+		return false
+	}
+	if q.bytes <= 2*queueMTU {
+		return false
+	}
+	q.rng = splitmix64(q.rng)
+	return float64(q.rng>>11)/(1<<53) < q.prob
+}
+
+func (q *pieQueue) dequeue(now time.Time, _ dropFunc) *queuedPacket {
+	q.advance(now)
+	return q.pop()
+}
+
+// fqCoDel keeps one flow per SSRC and serves new flows before old ones (RFC 8290)
+type fqCoDel struct {
+	spec    QueueSpec
+	quantum int
+	limit   int
+
+	flows    map[uint32]*fqFlow
+	newFlows []*fqFlow
+	oldFlows []*fqFlow
+	total    int
+}
+
+type fqFlow struct {
+	fifo
+	codel   codel
+	deficit int
+	active  bool
+}
+
+func newFQCoDel(spec QueueSpec) *fqCoDel {
+	q := &fqCoDel{spec: spec, quantum: spec.Quantum, limit: spec.LimitPackets, flows: make(map[uint32]*fqFlow)}
+	if q.quantum <= 0 {
+		q.quantum = defaultFQQuantum
+	}
+	if q.limit <= 0 {
+		q.limit = defaultFQLimit
+	}
+	return q
+}
+
+func (q *fqCoDel) enqueue(p *queuedPacket, _ time.Time, drop dropFunc) {
+	f, ok := q.flows[p.meta.SSRC]
+	if !ok {
+		f = &fqFlow{codel: newCoDel(q.spec)}
+		q.flows[p.meta.SSRC] = f
+	}
+	f.push(p)
+	q.total++
+	if !f.active {
+		f.active = true
+		f.deficit = q.quantum
+		q.newFlows = append(q.newFlows, f)
+	}
+
+	for q.total > q.limit || (q.spec.LimitBytes > 0 && q.totalBytes() > q.spec.LimitBytes) {
+		victim := q.fattest().pop()
+		q.total--
+		drop(victim, DropFQOverflow)
+	}
+}
+
+func (q *fqCoDel) totalBytes() int {
+	n := 0
+	for _, f := range q.newFlows {
+		n += f.bytes
+	}
+	for _, f := range q.oldFlows {
+		n += f.bytes
+	}
+	return n
+}
+
+// fattest returns the flow with the largest backlog (first in list order on ties)
+func (q *fqCoDel) fattest() *fqFlow {
+	var best *fqFlow
+	for _, list := range [][]*fqFlow{q.newFlows, q.oldFlows} {
+		for _, f := range list {
+			if best == nil || f.bytes > best.bytes {
+				best = f
+			}
+		}
+	}
+	return best
+}
+
+func (q *fqCoDel) dequeue(now time.Time, drop dropFunc) *queuedPacket {
+	for {
+		var (
+			f     *fqFlow
+			isNew bool
+		)
+		switch {
+		case len(q.newFlows) > 0:
+			f, isNew = q.newFlows[0], true
+		case len(q.oldFlows) > 0:
+			f = q.oldFlows[0]
+		default:
+			return nil
+		}
+
+		if f.deficit <= 0 {
+			f.deficit += q.quantum
+			q.rotate(isNew)
+			q.oldFlows = append(q.oldFlows, f)
+			continue
+		}
+
+		var p *queuedPacket
+		for {
+			p = f.pop()
+			if p == nil {
+				break
+			}
+			q.total--
+			if !f.codel.shouldDrop(p, now, f.bytes+p.meta.SizeBytes) {
+				break
+			}
+			drop(p, DropFQCoDel)
+		}
+
+		if p == nil {
+			q.rotate(isNew)
+			if isNew && len(q.oldFlows) > 0 {
+				// an emptied new flow goes to the old list so it can't starve others
+				q.oldFlows = append(q.oldFlows, f)
+			} else {
+				f.active = false
+			}
+			continue
+		}
+
+		f.deficit -= p.meta.SizeBytes
+		return p
+	}
+}
+
+// rotate removes the head of the new or old flow list
+func (q *fqCoDel) rotate(isNew bool) {
+	if isNew {
+		q.newFlows = q.newFlows[1:]
+	} else {
+		q.oldFlows = q.oldFlows[1:]
+	}
+}
+
+func (q *fqCoDel) empty() bool { return q.total == 0 }
+
+func (q *fqCoDel) oldest() (time.Time, bool) {
+	var (
+		t     time.Time
+		found bool
+	)
+	for _, list := range [][]*fqFlow{q.newFlows, q.oldFlows} {
+		for _, f := range list {
+			if at, ok := f.fifo.oldest(); ok && (!found || at.Before(t)) {
+				t, found = at, true
+			}
+		}
+	}
+	return t, found
+}
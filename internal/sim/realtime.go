@@ -0,0 +1,704 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
+	"github.com/lars-sto/error-recovery-simulation/internal/adapter"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// realtimeGrace is how long a realtime run waits for datagrams still on a loopback socket
+const realtimeGrace = 20 * time.Millisecond
+
+// RunRealtime runs sc by wall clock instead of virtual time. Media is paced in real time and
+// leaves the pion interceptor stack as RTP over UDP on 127.0.0.1; an in-process relay applies
+// the scenario's forward path (loss, delay, capacity, queue) to every datagram and forwards it
+// to a Receiver on its own socket. Policies decide once per stats window as in RunScenario.
+// A run takes sc.Duration plus the time the path needs to drain, and its results vary with
+// scheduling; feedback, RTX and cross traffic are not supported
+func RunRealtime(sc Scenario, opt RunOptions) (RunResult, error) {
+	return runRealtime(sc, opt, &udpTransport{})
+}
+
+// rtTransport carries the packets of a realtime run from the FlexFEC interceptor to the relay
+// and from the relay to the receiver
+type rtTransport interface {
+	// open connects sender and receiver and returns the SSRCs and payload types on the wire
+	open(sc Scenario, bus *adapter.RuntimeBus) (RTPIDs, error)
+	// start hands the transport the run and returns where media enters the FlexFEC interceptor
+	// (written with the run's mutex held)
+	start(rt *rtRun) (interceptor.RTPWriter, error)
+	// forward sends a datagram the relay delivers on to the receiver
+	forward(b []byte)
+	// close releases the transport; it is called once, also after a failed open
+	close()
+}
+
+// rtRun is the side of a realtime run its transport calls into
+type rtRun struct {
+	// sent books a packet of n bytes the FlexFEC interceptor wrote (called with the run's mutex held)
+	sent func(h *rtp.Header, n int)
+	// relay puts a datagram the sender wrote on the path; it takes b and never blocks
+	relay func(b []byte, at time.Time)
+	// receive hands a packet that reached the receiver to the decoder
+	receive func(pkt rtp.Packet, at time.Time)
+}
+
+// runRealtime is RunRealtime over the transport tr
+func runRealtime(sc Scenario, opt RunOptions, tr rtTransport) (RunResult, error) {
+	res := RunResult{
+		Scenario: sc.Name,
+		Mode:     opt.Mode,
+		Seed:     opt.Seed,
+		Duration: sc.Duration,
+	}
+	if err := CheckRealtime(sc); err != nil {
+		return res, err
+	}
+	if opt.Pcap != nil {
+		return res, errors.New("pcap capture needs the virtual clock")
+	}
+
+	hops, _, pathDelay := seedHops(sc, opt.Seed)
+
+	bus := adapter.NewRuntimeBus()
+	flexAdapter := adapter.NewFlexFECAdapter(bus)
+	ids, err := tr.open(sc, bus)
+	closed := false
+	defer func() {
+		if !closed {
+			tr.close()
+		}
+	}()
+	if err != nil {
+		return res, err
+	}
+
+	interval := sc.Sender.Interval()
+	if interval <= 0 {
+		interval = 20 * time.Millisecond
+	}
+	statsEvery := sc.StatsInterval
+	if statsEvery <= 0 {
+		statsEvery = 200 * time.Millisecond
+	}
+	deadline := sc.PlayoutDeadline
+	if deadline <= 0 {
+		deadline = 200 * time.Millisecond
+	}
+
+	// mu guards everything the sender (this goroutine), the relay and the receiver share:
+	// the path, the receiver, send times and counters
+	var (
+		mu      sync.Mutex
+		stopped bool // set when the run ends; the receiver ignores late packets
+	)
+
+	var (
+		sentMediaPkts  int64
+		sentFECPkts    int64
+		sentMediaBytes int64
+		sentFECBytes   int64
+
+		droppedMediaPkts int64
+		droppedFECPkts   int64
+		droppedQueuePkts int64
+		droppedWirePkts  int64
+		droppedAQMPkts   int64
+		dropsByReason    = make(map[DropReason]int64)
+
+		winSentMedia       int64
+		winDropMedia       int64
+		winCongestionMedia int64
+		winBytesTotal      int64
+	)
+
+	returnDelay := sc.ReturnDelay
+	if returnDelay <= 0 {
+		returnDelay = pathDelay
+	}
+	rttWin := newRTTWindow(time.Duration(sc.RTTMs)*time.Millisecond, returnDelay)
+	var jitter jitterEstimator
+
+	sendAt := make(map[uint64]time.Time, int(sc.Duration/interval)+8)
+	var mediaSeqs seqUnwrapper
+	latency := newLatencyTracker(pathDelay)
+	recv := NewReceiver(ids,
+		WithFECDecoderMode(opt.FECDecoder),
+		WithClockRate(sc.Sender.ClockRate()),
+		WithStartSeq(sc.Sender.StartSeq),
+		withOnAvailable(func(seq uint64, at time.Time, via availSource) {
+			if sAt, ok := sendAt[seq]; ok {
+				latency.Record(seq, sAt, at, via)
+			}
+		}),
+	)
+
+	polEnabled := sc.StaticR > 0
+	polK := sc.K
+	polR := sc.StaticR
+	polOver := overhead(polK, polR)
+
+	isFEC := func(h *rtp.Header) bool {
+		return h.SSRC == ids.FECSSRC || h.PayloadType == ids.FECPT
+	}
+
+	sink := adapter.SinkFunc(func(d recovery.PolicyDecision) {
+		flexAdapter.Apply(ids.MediaSSRC, d)
+
+		f := d.FEC
+		polEnabled = f.Enabled
+		polK = f.NumMediaPackets
+		polR = f.NumFECPackets
+		polOver = overhead(polK, polR)
+	})
+	policy, err := newRunPolicy(sc, opt, hops, statsEvery)
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		if policy != nil {
+			_ = policy.Close()
+		}
+	}()
+
+	type datagram struct {
+		b  []byte
+		at time.Time
+	}
+	var (
+		wg      sync.WaitGroup
+		stop    = make(chan struct{})
+		qmu     sync.Mutex
+		queue   []datagram
+		arrived = make(chan struct{}, 1)
+		// datagrams handed to the relay but not yet on the path
+		inFlight atomic.Int64
+	)
+	rt := &rtRun{
+		sent: func(h *rtp.Header, n int) {
+			if isFEC(h) {
+				sentFECPkts++
+				sentFECBytes += int64(n)
+			} else {
+				sentMediaPkts++
+				sentMediaBytes += int64(n)
+				winSentMedia++
+			}
+			winBytesTotal += int64(n)
+		},
+		relay: func(b []byte, at time.Time) {
+			inFlight.Add(1)
+			qmu.Lock()
+			queue = append(queue, datagram{b: b, at: at})
+			qmu.Unlock()
+			select {
+			case arrived <- struct{}{}:
+			default:
+			}
+		},
+		receive: func(pkt rtp.Packet, at time.Time) {
+			mu.Lock()
+			if !stopped {
+				recv.OnPacket(pkt, at)
+			}
+			mu.Unlock()
+		},
+	}
+	pipelineWriter, err := tr.start(rt)
+	if err != nil {
+		return res, err
+	}
+
+	start := time.Now()
+	end := start.Add(sc.Duration)
+	path := NewPath(hops, start)
+
+	// countDrop books a forward-path drop (called with mu held)
+	countDrop := func(meta PacketMeta, reason DropReason) {
+		if meta.IsFEC {
+			droppedFECPkts++
+		} else {
+			droppedMediaPkts++
+			winDropMedia++
+			latency.Lost(mediaSeqs.extend(meta.Seq), meta.ExpectedAt)
+		}
+		dropsByReason[reason]++
+		switch {
+		case reason == DropQueue:
+			droppedQueuePkts++
+		case reason == DropWireLoss || reason == DropZeroCap:
+			droppedWirePkts++
+		case reason.IsAQM():
+			droppedAQMPkts++
+		}
+		if !meta.IsFEC && (reason == DropQueue || reason.IsAQM()) {
+			winCongestionMedia++
+		}
+	}
+	path.OnDrop(countDrop)
+
+	// due hands back the deliveries of the path up to now (called with mu held)
+	due := func(now time.Time, out []DeliveredPacket) []DeliveredPacket {
+		for {
+			t, ok := path.NextEvent()
+			if !ok || t.After(now) {
+				return out
+			}
+			if path.Service(t) {
+				continue
+			}
+			dp, _ := path.Next()
+			rttWin.Add(dp.SentAt, dp.Arrives)
+			if dp.Pkt.SSRC == ids.MediaSSRC {
+				jitter.Update(dp.SentAt, dp.Arrives)
+			}
+			out = append(out, dp)
+		}
+	}
+
+	// Relay: put datagrams on the path and forward its deliveries to the receiver when they are due
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+		var (
+			batch []datagram
+			out   []DeliveredPacket
+		)
+		for {
+			mu.Lock()
+			next, pending := path.NextEvent()
+			mu.Unlock()
+			var wake <-chan time.Time
+			if pending {
+				timer.Reset(time.Until(next))
+				wake = timer.C
+			}
+
+			out = out[:0]
+			select {
+			case <-arrived:
+				qmu.Lock()
+				batch, queue = queue, batch[:0]
+				qmu.Unlock()
+				for _, d := range batch {
+					var pkt rtp.Packet
+					if err := pkt.Unmarshal(d.b); err != nil {
+						inFlight.Add(-1)
+						continue
+					}
+					fec := isFEC(&pkt.Header)
+					mu.Lock()
+					out = due(d.at, out)
+					if o := path.Send(pkt, d.at, fec); o.Dropped {
+						countDrop(PacketMeta{SSRC: pkt.SSRC, Seq: pkt.SequenceNumber, IsFEC: fec, ExpectedAt: o.ExpectedAt}, o.Reason)
+					}
+					mu.Unlock()
+					inFlight.Add(-1)
+				}
+			case <-wake:
+				mu.Lock()
+				out = due(time.Now(), out)
+				mu.Unlock()
+			case <-stop:
+				return
+			}
+			for _, dp := range out {
+				b, err := dp.Pkt.Marshal()
+				if err != nil {
+					continue
+				}
+				tr.forward(b)
+			}
+		}
+	}()
+
+	shutdown := func() {
+		close(stop)
+		wg.Wait()
+		closed = true
+		tr.close()
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+	}
+
+	var (
+		video  *videoSource
+		frames *frameTracker
+	)
+	if sc.Sender.Video != nil {
+		video = newVideoSource(sc.Sender, opt.Seed)
+		frames = newFrameTracker(start, deadline, interval)
+	}
+	var mediaCount uint32
+
+	// sendMedia is called with mu held
+	sendMedia := func(now time.Time, ts uint32, size int, marker bool) error {
+		seq := sc.Sender.StartSeq + uint16(mediaCount)
+		ext := mediaSeqs.Unwrap(seq)
+		mediaCount++
+
+		h := &rtp.Header{
+			Version:        2,
+			Marker:         marker,
+			PayloadType:    ids.MediaPT,
+			SequenceNumber: seq,
+			Timestamp:      ts,
+			SSRC:           ids.MediaSSRC,
+		}
+		sendAt[ext] = now
+		_, err := pipelineWriter.Write(h, makePayload(opt.Seed, seq, size), interceptor.Attributes{})
+		return err
+	}
+
+	sendNext := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		if video == nil {
+			return sendMedia(now, sc.Sender.StartTS+mediaCount*sc.Sender.TimestampStep, sc.Sender.PayloadBytes, false)
+		}
+		f := video.Next()
+		sizes := packetSizes(f.Bytes, sc.Sender.Video.mtu())
+		frames.OnFrame(frameInfo{
+			firstSeq: uint64(sc.Sender.StartSeq) + uint64(mediaCount),
+			packets:  len(sizes),
+			keyframe: f.Keyframe,
+			sendAt:   now,
+		})
+		for j, size := range sizes {
+			if err := sendMedia(now, f.TS, size, j == len(sizes)-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// stats closes the window ending at the nominal time at
+	stats := func(at time.Time) error {
+		elapsed := at.Sub(start)
+
+		mu.Lock()
+		trueLoss, congestion := 0.0, 0.0
+		if winSentMedia > 0 {
+			trueLoss = clamp01(float64(winDropMedia) / float64(winSentMedia))
+			congestion = float64(winCongestionMedia) / float64(winSentMedia)
+		}
+		currentBps := float64(winBytesTotal*8) / statsEvery.Seconds()
+		rtt := rttWin.Close()
+		jitterMs := float64(sc.JitterMs)
+		if jitter.hasPrev {
+			jitterMs = float64(jitter.Jitter()) / float64(time.Millisecond)
+		}
+		winSentMedia, winDropMedia, winCongestionMedia, winBytesTotal = 0, 0, 0, 0
+		mu.Unlock()
+
+		targetBWE := 0.0
+		if sc.BWE != nil {
+			targetBWE = sc.BWE.At(elapsed)
+		}
+		capBps, limited := 0.0, false
+		for _, h := range hops {
+			if c, ok := h.Link.capacityBps(elapsed-statsEvery, elapsed); ok && (!limited || c < capBps) {
+				capBps, limited = c, true
+			}
+		}
+
+		// the policy runs without mu so relay and receiver keep going while it decides
+		if policy != nil {
+			d, ok, err := policy.Decide(PolicyInput{
+				T:      elapsed,
+				Window: statsEvery,
+				Stats: recovery.NetworkStats{
+					RTTMs:          durationMs(rtt),
+					JitterMs:       int(math.Round(jitterMs)),
+					LossRate:       trueLoss,
+					TargetBitrate:  targetBWE,
+					CurrentBitrate: currentBps,
+					Timestamp:      at,
+				},
+				TrueLoss:       trueLoss,
+				CongestionLoss: congestion,
+				Current: recovery.FECDecision{
+					Enabled:         polEnabled,
+					NumMediaPackets: polK,
+					NumFECPackets:   polR,
+					TargetOverhead:  polOver,
+				},
+			})
+			if err != nil {
+				return err
+			}
+			if ok {
+				sink.Publish(d)
+			}
+		}
+		if opt.Recorder == nil {
+			return nil
+		}
+
+		now := time.Now()
+		mu.Lock()
+		s := TimeSample{
+			T:                 elapsed,
+			LossWindow:        trueLoss,
+			TrueLossWindow:    trueLoss,
+			TargetBWE:         targetBWE,
+			MediaRate:         sc.Sender.MediaBitrateBps(true),
+			CapacityBps:       capBps,
+			CurrentBitrateBps: currentBps,
+			QueueDelayMs:      float64(path.QueueDelay(now).Milliseconds()),
+			RTTMs:             float64(rtt) / float64(time.Millisecond),
+			JitterMs:          jitterMs,
+			PolicyEnabled:     polEnabled,
+			PolicyK:           polK,
+			PolicyR:           polR,
+			PolicyOverhead:    polOver,
+			SentMedia:         sentMediaPkts,
+			SentFEC:           sentFECPkts,
+			DroppedMedia:      droppedMediaPkts,
+			DroppedFEC:        droppedFECPkts,
+			QueueDrops:        droppedQueuePkts,
+			WireDrops:         droppedWirePkts,
+			AQMDrops:          droppedAQMPkts,
+		}
+		if len(sc.Path) > 0 {
+			s.Hops = path.Samples(now)
+		}
+		if frames != nil {
+			frames.Advance(now, recv.availAt)
+			s.Frames = int64(frames.decided)
+			s.DecodableFrames = frames.decodable
+			s.Frozen = frames.frozen
+			s.FreezeCount = frames.FreezeCount()
+			s.FreezeMs = float64(frames.FreezeTotal(now)) / float64(time.Millisecond)
+		}
+		mu.Unlock()
+		opt.Recorder.OnSample(s)
+		return nil
+	}
+
+	// Sender: media and stats windows by wall clock (stats first on ties, as in RunScenario)
+	nextMedia := start
+	nextStats := start.Add(statsEvery)
+	for {
+		mediaDue := !nextMedia.After(end)
+		statsDue := !nextStats.After(end)
+		if !mediaDue && !statsDue {
+			break
+		}
+		if statsDue && (!mediaDue || !nextMedia.Before(nextStats)) {
+			time.Sleep(time.Until(nextStats))
+			if err := stats(nextStats); err != nil {
+				shutdown()
+				return res, err
+			}
+			nextStats = nextStats.Add(statsEvery)
+			continue
+		}
+		time.Sleep(time.Until(nextMedia))
+		if err := sendNext(); err != nil {
+			shutdown()
+			return res, err
+		}
+		nextMedia = nextMedia.Add(interval)
+	}
+
+	// Drain: wait until the relay has put every datagram on the path and the path is empty
+	for {
+		time.Sleep(realtimeGrace)
+		mu.Lock()
+		_, pending := path.NextEvent()
+		mu.Unlock()
+		if !pending && inFlight.Load() == 0 {
+			break
+		}
+	}
+	time.Sleep(realtimeGrace)
+	shutdown()
+
+	if policy != nil {
+		err := policy.Close()
+		policy = nil
+		if err != nil {
+			return res, err
+		}
+	}
+	if opt.Recorder != nil {
+		_ = opt.Recorder.Close()
+	}
+
+	snap := recv.Snapshot()
+
+	res.SentMediaPkts = sentMediaPkts
+	res.SentFECPkts = sentFECPkts
+	res.SentMediaBytes = sentMediaBytes
+	res.SentFECBytes = sentFECBytes
+
+	res.DroppedMediaPkts = droppedMediaPkts
+	res.DroppedFECPkts = droppedFECPkts
+	res.DroppedQueuePkts = droppedQueuePkts
+	res.DroppedWirePkts = droppedWirePkts
+	res.DroppedAQMPkts = droppedAQMPkts
+	res.DropsByReason = dropsByReason
+	res.Hops = path.Results()
+
+	res.RecvMediaPkts = snap.RecvMedia
+	res.RecvFECPkts = snap.RecvFEC
+	res.RecoveredPkts = snap.Recovered
+	res.UniquePkts = snap.Unique
+	res.DuplicatePkts = snap.Duplicates
+	res.ReorderedPkts = snap.Reordered
+	res.MaxReorderDepth = snap.MaxReorderDepth
+
+	if sentMediaPkts > 0 {
+		res.OverheadRatioPkts = float64(sentFECPkts) / float64(sentMediaPkts)
+		res.FinalLossNoDeadline = clamp01(1.0 - float64(snap.Unique)/float64(sentMediaPkts))
+	}
+	if sentMediaBytes > 0 {
+		res.OverheadRatioBytes = float64(sentFECBytes) / float64(sentMediaBytes)
+	}
+	res.finishDelivery(sendAt, recv.availAt, deadline, latency, frames)
+	return res, nil
+}
+
+// udpTransport carries a realtime run over loopback UDP: the FlexFEC interceptor writes to
+// the relay's socket, which forwards to the receiver's socket
+type udpTransport struct {
+	sendConn  *net.UDPConn
+	relayConn *net.UDPConn
+	recvConn  *net.UDPConn
+	recvAddr  *net.UDPAddr
+
+	fec  interceptor.Interceptor
+	info *interceptor.StreamInfo
+	wg   sync.WaitGroup
+}
+
+func (t *udpTransport) open(sc Scenario, bus *adapter.RuntimeBus) (RTPIDs, error) {
+	var err error
+	if t.recvConn, err = listenLoopback(); err != nil {
+		return RTPIDs{}, err
+	}
+	if t.relayConn, err = listenLoopback(); err != nil {
+		return RTPIDs{}, err
+	}
+	if t.sendConn, err = net.DialUDP("udp", nil, t.relayConn.LocalAddr().(*net.UDPAddr)); err != nil {
+		return RTPIDs{}, err
+	}
+	t.recvAddr = t.recvConn.LocalAddr().(*net.UDPAddr)
+
+	if t.fec, err = newFECInterceptor(sc, bus); err != nil {
+		return RTPIDs{}, err
+	}
+	t.info = &interceptor.StreamInfo{
+		SSRC:                              sc.IDs.MediaSSRC,
+		PayloadType:                       sc.IDs.MediaPT,
+		SSRCForwardErrorCorrection:        sc.IDs.FECSSRC,
+		PayloadTypeForwardErrorCorrection: sc.IDs.FECPT,
+	}
+	return sc.IDs, nil
+}
+
+func (t *udpTransport) start(rt *rtRun) (interceptor.RTPWriter, error) {
+	socketWriter := interceptor.RTPWriterFunc(func(h *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		b, err := (&rtp.Packet{Header: *h, Payload: payload}).Marshal()
+		if err != nil {
+			return 0, err
+		}
+		if _, err := t.sendConn.Write(b); err != nil {
+			return 0, err
+		}
+		rt.sent(h, len(b))
+		return len(payload), nil
+	})
+
+	// Relay: read datagrams from the sender
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		buf := make([]byte, 1<<16)
+		for {
+			n, err := t.relayConn.Read(buf)
+			if err != nil {
+				return
+			}
+			rt.relay(append([]byte(nil), buf[:n]...), time.Now())
+		}
+	}()
+
+	// Receiver: decode datagrams as they arrive
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		buf := make([]byte, 1<<16)
+		for {
+			n, err := t.recvConn.Read(buf)
+			if err != nil {
+				return
+			}
+			at := time.Now()
+			var pkt rtp.Packet
+			if err := pkt.Unmarshal(append([]byte(nil), buf[:n]...)); err != nil {
+				continue
+			}
+			rt.receive(pkt, at)
+		}
+	}()
+
+	return t.fec.BindLocalStream(t.info, socketWriter), nil
+}
+
+func (t *udpTransport) forward(b []byte) {
+	_, _ = t.relayConn.WriteToUDP(b, t.recvAddr)
+}
+
+func (t *udpTransport) close() {
+	for _, c := range []*net.UDPConn{t.sendConn, t.relayConn, t.recvConn} {
+		if c != nil {
+			_ = c.Close()
+		}
+	}
+	t.wg.Wait()
+	if t.fec != nil {
+		t.fec.UnbindLocalStream(t.info)
+		_ = t.fec.Close()
+	}
+}
+
+// CheckRealtime reports whether RunRealtime supports sc
+func CheckRealtime(sc Scenario) error {
+	switch {
+	case sc.Feedback != nil:
+		return fmt.Errorf("%s: realtime runs do not support feedback", sc.Name)
+	case sc.RTX != nil:
+		return fmt.Errorf("%s: realtime runs do not support rtx", sc.Name)
+	case len(sc.CrossTraffic) > 0:
+		return fmt.Errorf("%s: realtime runs do not support cross traffic", sc.Name)
+	case sc.Sender.Replay != nil:
+		return fmt.Errorf("%s: realtime runs do not support sender replay", sc.Name)
+	}
+	return nil
+}
+
+// listenLoopback opens a UDP socket on an ephemeral port of 127.0.0.1 with room for
+// keyframe bursts in its receive buffer
+func listenLoopback() (*net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetReadBuffer(4 << 20)
+	return conn, nil
+}
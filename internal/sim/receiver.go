@@ -3,74 +3,299 @@ package sim
 import (
 	"time"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 )
 
 type Receiver struct {
 	decoder *FlexFEC03Decoder
-	availAt map[uint16]time.Time
+	// availAt is keyed by extended media sequence number
+	availAt map[uint64]time.Time
+	seqs    seqUnwrapper
 
-	recvMedia int64
-	recvFEC   int64
-	recovered int64
+	recvMedia    int64
+	recvFEC      int64
+	recvRTX      int64
+	recovered    int64
+	recoveredRTX int64
+
+	// duplicates and reordering of packets received directly; duplicates are
+	// tracked by extended sequence number, FEC and RTX numbers extended by fecSeqs and rtxSeqs
+	gotMedia        seqWindow
+	gotFEC          seqWindow
+	gotRTX          seqWindow
+	fecSeqs         seqUnwrapper
+	rtxSeqs         seqUnwrapper
+	duplicates      int64
+	hasMaxSeq       bool
+	maxSeq          uint64
+	reordered       int64
+	maxReorderDepth int64
 
 	mediaSSRC uint32
 	fecSSRC   uint32
 	mediaPT   uint8
 	fecPT     uint8
+	rtxSSRC   uint32
+
+	ssrc  uint32
+	stats receptionStats
+	nack  *nackTracker
+
+	onAvailable func(seq uint64, at time.Time, via availSource)
 }
 
-func NewReceiver(ids RTPIDs) *Receiver {
-	return &Receiver{
+// availSource says how a media packet became available
+type availSource int
+
+const (
+	availDirect availSource = iota
+	availFEC
+	availRTX
+)
+
+// ReceiverOption configures optional Receiver behaviour
+type ReceiverOption func(r *Receiver)
+
+// WithFECDecoderMode selects the FlexFEC recovery strategy (default FECDecoderPeeling)
+func WithFECDecoderMode(m FECDecoderMode) ReceiverOption {
+	return func(r *Receiver) {
+		if m != "" {
+			r.decoder.SetMode(m)
+		}
+	}
+}
+
+// WithClockRate sets the media RTP clock used for the RFC 3550 jitter in receiver reports (default 90 kHz)
+func WithClockRate(hz uint32) ReceiverOption {
+	return func(r *Receiver) {
+		if hz > 0 {
+			r.stats.clockRate = float64(hz)
+		}
+	}
+}
+
+// WithStartSeq counts extended sequence numbers from the sender's first media
+// sequence number (default: the first one received)
+func WithStartSeq(seq uint16) ReceiverOption {
+	return func(r *Receiver) {
+		r.seqs.start(seq)
+	}
+}
+
+// withOnAvailable calls fn once per media packet (extended seq) when it first becomes available
+func withOnAvailable(fn func(seq uint64, at time.Time, via availSource)) ReceiverOption {
+	return func(r *Receiver) {
+		r.onAvailable = fn
+	}
+}
+
+// WithNACK makes the receiver request missing media packets (see NACK)
+func WithNACK(spec RTXSpec) ReceiverOption {
+	return func(r *Receiver) {
+		r.nack = newNACKTracker(spec)
+	}
+}
+
+func NewReceiver(ids RTPIDs, opts ...ReceiverOption) *Receiver {
+	ssrc := ids.ReceiverSSRC
+	if ssrc == 0 {
+		ssrc = 1
+	}
+	r := &Receiver{
 		decoder:   NewFlexFEC03Decoder(ids.FECSSRC, ids.MediaSSRC),
-		availAt:   make(map[uint16]time.Time, 4096),
+		availAt:   make(map[uint64]time.Time, 4096),
 		mediaSSRC: ids.MediaSSRC,
 		fecSSRC:   ids.FECSSRC,
 		mediaPT:   ids.MediaPT,
 		fecPT:     ids.FECPT,
+		rtxSSRC:   ids.RTXSSRC,
+		ssrc:      ssrc,
+		stats:     receptionStats{clockRate: 90000},
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *Receiver) OnPacket(pkt rtp.Packet, at time.Time) {
+	if r.rtxSSRC != 0 && pkt.SSRC == r.rtxSSRC {
+		if r.gotRTX.Seen(r.rtxSeqs.Unwrap(pkt.SequenceNumber)) {
+			// a network copy of a retransmission, not a new one
+			r.duplicates++
+			return
+		}
+		r.recvRTX++
+		orig, ok := unwrapRTX(pkt, r.mediaSSRC, r.mediaPT)
+		if !ok {
+			return
+		}
+		ext := r.seqs.Unwrap(orig.SequenceNumber)
+		if r.nack != nil {
+			r.nack.Forget(ext)
+		}
+		if r.markAvailable(ext, at, availRTX) {
+			r.recoveredRTX++
+		}
+		// the restored packet may complete FEC equations for other losses
+		r.pushDecoder(orig, at)
+		return
+	}
+
 	isFEC := (pkt.SSRC == r.fecSSRC) || (pkt.PayloadType == r.fecPT)
+	var ext uint64
+	if isFEC {
+		ext = r.fecSeqs.Unwrap(pkt.SequenceNumber)
+	} else {
+		ext = r.seqs.Unwrap(pkt.SequenceNumber)
+	}
+	if r.duplicate(ext, isFEC) {
+		// a copy made by the network: counted, but neither the stats nor the decoder see it again
+		r.duplicates++
+		return
+	}
 	if isFEC {
 		r.recvFEC++
 	} else {
+		r.trackOrder(ext)
 		r.recvMedia++
-		r.markAvailable(pkt.SequenceNumber, at)
+		r.stats.OnPacket(pkt.SequenceNumber, pkt.Timestamp, at)
+		if r.nack != nil {
+			r.nack.OnMedia(ext, at)
+		}
+		r.markAvailable(ext, at, availDirect)
 	}
 
+	r.pushDecoder(pkt, at)
+}
+
+// duplicate records a directly received packet (extended seq) and reports whether it was seen before
+func (r *Receiver) duplicate(ext uint64, isFEC bool) bool {
+	if isFEC {
+		return r.gotFEC.Seen(ext)
+	}
+	return r.gotMedia.Seen(ext)
+}
+
+// trackOrder counts media packets (extended seq) arriving after a newer one; the depth
+// is the sequence distance to the newest packet received so far
+func (r *Receiver) trackOrder(ext uint64) {
+	if !r.hasMaxSeq || ext > r.maxSeq {
+		r.hasMaxSeq = true
+		r.maxSeq = ext
+		return
+	}
+	r.reordered++
+	if depth := int64(r.maxSeq - ext); depth > r.maxReorderDepth {
+		r.maxReorderDepth = depth
+	}
+}
+
+func (r *Receiver) pushDecoder(pkt rtp.Packet, at time.Time) {
 	recovered := r.decoder.Push(pkt)
 	for _, rp := range recovered {
 		if rp.SSRC != r.mediaSSRC {
 			continue
 		}
-		if r.markAvailable(rp.SequenceNumber, at) {
+		ext := r.seqs.Unwrap(rp.SequenceNumber)
+		if r.nack != nil {
+			r.nack.Forget(ext)
+		}
+		if r.markAvailable(ext, at, availFEC) {
 			r.recovered++
 		}
 	}
 }
 
-func (r *Receiver) markAvailable(seq uint16, at time.Time) bool {
-	if _, ok := r.availAt[seq]; ok {
+// markAvailable records the first availability of the media packet with extended sequence number ext
+func (r *Receiver) markAvailable(ext uint64, at time.Time, via availSource) bool {
+	if _, ok := r.availAt[ext]; ok {
 		return false
 	}
-	r.availAt[seq] = at
+	r.availAt[ext] = at
+	if r.onAvailable != nil {
+		r.onAvailable(ext, at, via)
+	}
 	return true
 }
 
+// OnRTCP consumes RTCP arriving from the sender (sender reports for LSR/DLSR)
+func (r *Receiver) OnRTCP(raw []byte, at time.Time) error {
+	pkts, err := rtcp.Unmarshal(raw)
+	if err != nil {
+		return err
+	}
+	for _, p := range pkts {
+		if sr, ok := p.(*rtcp.SenderReport); ok && sr.SSRC == r.mediaSSRC {
+			r.stats.OnSenderReport(sr, at)
+		}
+	}
+	return nil
+}
+
+// ReceiverReport builds a marshalled RTCP receiver report for the media stream
+// Counts cover packets received directly (pre-FEC), as the report describes the path
+func (r *Receiver) ReceiverReport(now time.Time) ([]byte, error) {
+	return rtcp.Marshal([]rtcp.Packet{&rtcp.ReceiverReport{
+		SSRC:    r.ssrc,
+		Reports: []rtcp.ReceptionReport{r.stats.Report(r.mediaSSRC, now)},
+	}})
+}
+
+// NextNACK reports when the next NACK is due (false without NACK or pending gaps)
+func (r *Receiver) NextNACK() (time.Time, bool) {
+	if r.nack == nil {
+		return time.Time{}, false
+	}
+	return r.nack.NextDue()
+}
+
+// NACK builds a marshalled generic NACK for all gaps due at now; n is the number of requested packets
+func (r *Receiver) NACK(now time.Time) (raw []byte, n int, err error) {
+	if r.nack == nil {
+		return nil, 0, nil
+	}
+	due := r.nack.Due(now)
+	if len(due) == 0 {
+		return nil, 0, nil
+	}
+	seqs := make([]uint16, len(due))
+	for i, ext := range due {
+		seqs[i] = uint16(ext)
+	}
+	raw, err = rtcp.Marshal([]rtcp.Packet{&rtcp.TransportLayerNack{
+		SenderSSRC: r.ssrc,
+		MediaSSRC:  r.mediaSSRC,
+		Nacks:      rtcp.NackPairsFromSequenceNumbers(seqs),
+	}})
+	return raw, len(seqs), err
+}
+
 type ReceiverSnapshot struct {
-	RecvMedia int64
-	RecvFEC   int64
-	Recovered int64
-	Unique    int64
+	RecvMedia    int64
+	RecvFEC      int64
+	RecvRTX      int64
+	Recovered    int64
+	RecoveredRTX int64
+	Unique       int64
+
+	Duplicates      int64
+	Reordered       int64
+	MaxReorderDepth int64
 }
 
 func (r *Receiver) Snapshot() ReceiverSnapshot {
 	return ReceiverSnapshot{
-		RecvMedia: r.recvMedia,
-		RecvFEC:   r.recvFEC,
-		Recovered: r.recovered,
-		Unique:    int64(len(r.availAt)),
+		RecvMedia:    r.recvMedia,
+		RecvFEC:      r.recvFEC,
+		RecvRTX:      r.recvRTX,
+		Recovered:    r.recovered,
+		RecoveredRTX: r.recoveredRTX,
+		Unique:       int64(len(r.availAt)),
+
+		Duplicates:      r.duplicates,
+		Reordered:       r.reordered,
+		MaxReorderDepth: r.maxReorderDepth,
 	}
 }
@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+var testIDs = RTPIDs{MediaSSRC: 1111, FECSSRC: 2222, MediaPT: 96, FECPT: 97}
+
+func testMedia(seq uint16) rtp.Packet {
+	return rtp.Packet{
+		Header:  rtp.Header{Version: 2, SSRC: testIDs.MediaSSRC, PayloadType: testIDs.MediaPT, SequenceNumber: seq},
+		Payload: []byte{byte(seq), byte(seq >> 8)},
+	}
+}
+
+// A lost packet half a cycle after seq used to leave seq in the duplicate filter, so the
+// next packet with seq after the wrap was dropped as a duplicate
+func TestReceiverDuplicatesAcrossWrap(t *testing.T) {
+	r := NewReceiver(testIDs, WithStartSeq(0))
+	at := time.Unix(0, 0)
+
+	const (
+		lost  = 0x8000 + 5
+		total = 0x10000 + 10
+	)
+	var received int64
+	for i := 0; i < total; i++ {
+		if i == lost {
+			continue
+		}
+		r.OnPacket(testMedia(uint16(i)), at.Add(time.Duration(i)*time.Millisecond))
+		received++
+	}
+	// a copy made by the network is still a duplicate
+	last := total - 1
+	r.OnPacket(testMedia(uint16(last)), at.Add(total*time.Millisecond))
+
+	snap := r.Snapshot()
+	if snap.Duplicates != 1 {
+		t.Errorf("duplicates = %d, want 1", snap.Duplicates)
+	}
+	if snap.RecvMedia != received {
+		t.Errorf("received media = %d, want %d", snap.RecvMedia, received)
+	}
+	if snap.Unique != received {
+		t.Errorf("unique = %d, want %d", snap.Unique, received)
+	}
+}
+
+// A network copy of a retransmission is a duplicate: it must neither count as a second
+// RTX packet nor reach the decoder again
+func TestReceiverDuplicateRTX(t *testing.T) {
+	ids := testIDs
+	ids.RTXSSRC, ids.RTXPT = 3333, 98
+	r := NewReceiver(ids, WithStartSeq(0))
+	s := newRTXSender(ids, 0)
+	at := time.Unix(0, 0)
+
+	for i := 0; i < 10; i++ {
+		pkt := testMedia(uint16(i))
+		s.Store(pkt)
+		if i != 5 {
+			r.OnPacket(pkt, at)
+		}
+	}
+	rtx, ok := s.Retransmission(5)
+	if !ok {
+		t.Fatal("packet 5 not in the RTX history")
+	}
+	r.OnPacket(rtx, at.Add(time.Millisecond))
+	r.OnPacket(rtx, at.Add(2*time.Millisecond))
+
+	snap := r.Snapshot()
+	if snap.RecvRTX != 1 || snap.Duplicates != 1 || snap.Unique != 10 {
+		t.Errorf("rtx = %d, duplicates = %d, unique = %d, want 1, 1, 10", snap.RecvRTX, snap.Duplicates, snap.Unique)
+	}
+}
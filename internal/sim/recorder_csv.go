@@ -12,13 +12,19 @@ import (
 type TimeSample struct {
 	T time.Duration
 
-	LossWindow float64
-	TargetBWE  float64
-	MediaRate  float64
+	// LossWindow is the loss the policy saw; TrueLossWindow the sender-side drop ratio
+	LossWindow     float64
+	TrueLossWindow float64
+	// FeedbackAgeMs is the age of the newest receiver report (-1 before the first, 0 without feedback)
+	FeedbackAgeMs float64
+	TargetBWE     float64
+	MediaRate     float64
 
 	CapacityBps       float64
 	CurrentBitrateBps float64
 	QueueDelayMs      float64
+	RTTMs             float64
+	JitterMs          float64
 
 	PolicyEnabled  bool
 	PolicyK        uint32
@@ -31,6 +37,20 @@ type TimeSample struct {
 	DroppedFEC   int64
 	QueueDrops   int64
 	WireDrops    int64
+	AQMDrops     int64
+
+	// Frame accounting (video senders): frames decided at their playout deadline so far,
+	// whether playback is frozen at T, and the freezes and freeze time up to T
+	Frames          int64
+	DecodableFrames int64
+	Frozen          bool
+	FreezeCount     int64
+	FreezeMs        float64
+
+	// CrossFlows has one entry per competing flow, in Scenario.CrossTraffic order
+	CrossFlows []CrossFlowSample
+	// Hops has one entry per hop of Scenario.Path (empty for a single link)
+	Hops []HopSample
 }
 
 type Recorder interface {
@@ -41,6 +61,9 @@ type Recorder interface {
 type CSVRecorder struct {
 	f *os.File
 	w *csv.Writer
+
+	// the header is written with the first sample, which names the cross traffic flows and hops
+	wroteHeader bool
 }
 
 func NewCSVRecorder(path string) (*CSVRecorder, error) {
@@ -51,16 +74,23 @@ func NewCSVRecorder(path string) (*CSVRecorder, error) {
 	if err != nil {
 		return nil, err
 	}
-	w := csv.NewWriter(f)
+	return &CSVRecorder{f: f, w: csv.NewWriter(f)}, nil
+}
 
+func (r *CSVRecorder) writeHeader(s TimeSample) error {
+	r.wroteHeader = true
 	hdr := []string{
 		"t_ms",
 		"loss_window",
+		"true_loss_window",
+		"feedback_age_ms",
 		"target_bwe_bps",
 		"media_rate_bps",
 		"capacity_bps",
 		"current_bitrate_bps",
 		"queue_delay_ms",
+		"rtt_ms",
+		"jitter_ms",
 		"policy_enabled",
 		"policy_k",
 		"policy_r",
@@ -71,25 +101,38 @@ func NewCSVRecorder(path string) (*CSVRecorder, error) {
 		"dropped_fec",
 		"queue_drops",
 		"wire_drops",
+		"aqm_drops",
+		"frames",
+		"decodable_frames",
+		"frozen",
+		"freeze_count",
+		"freeze_ms",
 	}
-	if err := w.Write(hdr); err != nil {
-		_ = f.Close()
-		return nil, err
+	for _, c := range s.CrossFlows {
+		hdr = append(hdr, "cross_"+c.Name+"_bps")
 	}
-	w.Flush()
-
-	return &CSVRecorder{f: f, w: w}, nil
+	for _, h := range s.Hops {
+		hdr = append(hdr, "hop_"+h.Name+"_queue_delay_ms", "hop_"+h.Name+"_drops")
+	}
+	return r.w.Write(hdr)
 }
 
 func (r *CSVRecorder) OnSample(s TimeSample) {
+	if !r.wroteHeader {
+		_ = r.writeHeader(s)
+	}
 	row := []string{
 		strconv.FormatInt(s.T.Milliseconds(), 10),
 		ff(s.LossWindow),
+		ff(s.TrueLossWindow),
+		ff(s.FeedbackAgeMs),
 		ff(s.TargetBWE),
 		ff(s.MediaRate),
 		ff(s.CapacityBps),
 		ff(s.CurrentBitrateBps),
 		ff(s.QueueDelayMs),
+		ff(s.RTTMs),
+		ff(s.JitterMs),
 		strconv.FormatBool(s.PolicyEnabled),
 		strconv.FormatUint(uint64(s.PolicyK), 10),
 		strconv.FormatUint(uint64(s.PolicyR), 10),
@@ -100,11 +143,26 @@ func (r *CSVRecorder) OnSample(s TimeSample) {
 		strconv.FormatInt(s.DroppedFEC, 10),
 		strconv.FormatInt(s.QueueDrops, 10),
 		strconv.FormatInt(s.WireDrops, 10),
+		strconv.FormatInt(s.AQMDrops, 10),
+		strconv.FormatInt(s.Frames, 10),
+		strconv.FormatInt(s.DecodableFrames, 10),
+		strconv.FormatBool(s.Frozen),
+		strconv.FormatInt(s.FreezeCount, 10),
+		ff(s.FreezeMs),
+	}
+	for _, c := range s.CrossFlows {
+		row = append(row, ff(c.ThroughputBps))
+	}
+	for _, h := range s.Hops {
+		row = append(row, ff(h.QueueDelayMs), strconv.FormatInt(h.Dropped, 10))
 	}
 	_ = r.w.Write(row)
 }
 
 func (r *CSVRecorder) Close() error {
+	if !r.wroteHeader {
+		_ = r.writeHeader(TimeSample{})
+	}
 	r.w.Flush()
 	if err := r.w.Error(); err != nil {
 		_ = r.f.Close()
@@ -0,0 +1,445 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// RTPReplay is a captured RTP stream the sender replays instead of generating packets.
+// It repeats with Period when the run is longer than the capture; Period must end after the
+// last packet
+type RTPReplay struct {
+	Name    string
+	Packets []ReplayPacket
+	Period  time.Duration
+	// ClockRate is the RTP clock of the stream
+	ClockRate uint32
+}
+
+// ReplayPacket is one captured packet: send time and RTP timestamp relative to the first
+// packet, payload size (with padding) and marker bit
+type ReplayPacket struct {
+	At     time.Duration
+	TS     uint32
+	Size   int
+	Marker bool
+}
+
+// CaptureFilter selects the RTP stream of a capture; zero values match every stream
+type CaptureFilter struct {
+	SSRC        uint32
+	PayloadType *uint8
+}
+
+// LoadRTPCapture reads the RTP stream selected by filter from a pcap or pcapng file. Only
+// UDP over IPv4/IPv6 is decoded (Ethernet, raw IP, Linux cooked and BSD loopback captures);
+// packets repeating a sequence number are skipped. clockRate 0 means 90 kHz
+func LoadRTPCapture(path string, filter CaptureFilter, clockRate uint32) (*RTPReplay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	r, err := ParseRTPCapture(bufio.NewReader(f), filter, clockRate)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r.Name = path
+	return r, nil
+}
+
+// ParseRTPCapture is LoadRTPCapture on a reader
+func ParseRTPCapture(r io.Reader, filter CaptureFilter, clockRate uint32) (*RTPReplay, error) {
+	if clockRate == 0 {
+		clockRate = 90000
+	}
+	type captured struct {
+		at  time.Time
+		pkt rtp.Packet
+	}
+	streams := make(map[uint32][]captured)
+	err := readCapture(r, func(at time.Time, linkType uint32, frame []byte) {
+		b, ok := udpPayload(linkType, frame)
+		if !ok || !isRTP(b) {
+			return
+		}
+		var pkt rtp.Packet
+		if pkt.Unmarshal(append([]byte(nil), b...)) != nil {
+			return
+		}
+		if filter.SSRC != 0 && pkt.SSRC != filter.SSRC {
+			return
+		}
+		if filter.PayloadType != nil && pkt.PayloadType != *filter.PayloadType {
+			return
+		}
+		streams[pkt.SSRC] = append(streams[pkt.SSRC], captured{at: at, pkt: pkt})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(streams) {
+	case 0:
+		return nil, errors.New("no RTP packets match")
+	case 1:
+	default:
+		var list []string
+		for ssrc, pkts := range streams {
+			list = append(list, fmt.Sprintf("ssrc %d (pt %d, %d packets)", ssrc, pkts[0].pkt.PayloadType, len(pkts)))
+		}
+		sort.Strings(list)
+		return nil, fmt.Errorf("%d RTP streams match, select one by ssrc or payload_type: %s", len(streams), strings.Join(list, ", "))
+	}
+
+	out := &RTPReplay{ClockRate: clockRate}
+	for _, pkts := range streams {
+		var (
+			seqs = make(map[uint64]bool, len(pkts))
+			u    seqUnwrapper
+		)
+		first := pkts[0]
+		for _, c := range pkts {
+			ext := u.Unwrap(c.pkt.SequenceNumber)
+			if seqs[ext] {
+				continue
+			}
+			seqs[ext] = true
+			at := c.at.Sub(first.at)
+			if n := len(out.Packets); n > 0 && at < out.Packets[n-1].At {
+				// capture clocks can step back; keep the packet order
+				at = out.Packets[n-1].At
+			}
+			out.Packets = append(out.Packets, ReplayPacket{
+				At:     at,
+				TS:     c.pkt.Timestamp - first.pkt.Timestamp,
+				Size:   len(c.pkt.Payload) + int(c.pkt.PaddingSize),
+				Marker: c.pkt.Marker,
+			})
+		}
+	}
+	out.Period = out.Packets[len(out.Packets)-1].At + out.meanGap()
+	return out, nil
+}
+
+// validate rejects replays the sender can't loop: no packets, or a period that doesn't
+// end after the last packet
+func (r *RTPReplay) validate() error {
+	if len(r.Packets) == 0 {
+		return fmt.Errorf("replay %q: no packets", r.Name)
+	}
+	if last := r.Packets[len(r.Packets)-1].At; r.Period <= 0 || r.Period <= last {
+		return fmt.Errorf("replay %q: period %v must be longer than the last packet offset %v", r.Name, r.Period, last)
+	}
+	return nil
+}
+
+// meanGap is the average time between packets (20ms for a single packet)
+func (r *RTPReplay) meanGap() time.Duration {
+	n := len(r.Packets)
+	if n < 2 || r.Packets[n-1].At <= 0 {
+		return 20 * time.Millisecond
+	}
+	return r.Packets[n-1].At / time.Duration(n-1)
+}
+
+// bitrateBps is the average payload (plus RTP header) bitrate of one period
+func (r *RTPReplay) bitrateBps(includeRTPHeader bool) float64 {
+	var bytes int
+	for _, p := range r.Packets {
+		bytes += p.Size
+		if includeRTPHeader {
+			bytes += 12
+		}
+	}
+	return float64(bytes*8) / r.Period.Seconds()
+}
+
+// replaySource walks an RTPReplay and loops it with its period; RTP timestamps keep
+// advancing by the period in clock ticks
+type replaySource struct {
+	r        *RTPReplay
+	idx      int
+	loop     int64
+	tsPeriod uint32
+}
+
+func newReplaySource(r *RTPReplay) *replaySource {
+	ticks := math.Round(r.Period.Seconds() * float64(r.ClockRate))
+	return &replaySource{r: r, tsPeriod: uint32(int64(ticks))}
+}
+
+// NextAt is the send offset of the next packet from the start of the run
+func (s *replaySource) NextAt() time.Duration {
+	return time.Duration(s.loop)*s.r.Period + s.r.Packets[s.idx].At
+}
+
+// Next returns the next packet with its RTP timestamp offset
+func (s *replaySource) Next() (ReplayPacket, uint32) {
+	p := s.r.Packets[s.idx]
+	ts := p.TS + uint32(s.loop)*s.tsPeriod
+	if s.idx++; s.idx == len(s.r.Packets) {
+		s.idx = 0
+		s.loop++
+	}
+	return p, ts
+}
+
+// isRTP tells RTP from STUN, DTLS and RTCP on a shared socket (RFC 7983, RFC 5761)
+func isRTP(b []byte) bool {
+	return len(b) >= 12 && b[0] >= 128 && b[0] <= 191 && (b[1] < 192 || b[1] > 223)
+}
+
+// Link types of captures LoadRTPCapture decodes
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRawAlt   = 12 // DLT_RAW on OpenBSD
+	linkTypeRawAlt2  = 14 // DLT_RAW on BSD/OS
+	linkTypeLoop     = 108
+	linkTypeSLL      = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+// maxCaptureRecord bounds a captured packet, so a corrupt length can't make the reader
+// allocate gigabytes; pcapng blocks may add up to 64 KiB of headers and options
+const maxCaptureRecord = 256 << 10
+
+// readCapture calls fn for every packet of a pcap or pcapng stream
+func readCapture(r io.Reader, fn func(at time.Time, linkType uint32, frame []byte)) error {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return fmt.Errorf("not a pcap or pcapng file: %w", err)
+	}
+	switch m := binary.LittleEndian.Uint32(magic[:]); m {
+	case pcapngSHB:
+		return readPcapng(io.MultiReader(bytes.NewReader(magic[:]), r), fn)
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		return readPcap(r, binary.LittleEndian, m == 0xa1b23c4d, fn)
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		return readPcap(r, binary.BigEndian, m == 0x4d3cb2a1, fn)
+	}
+	return errors.New("not a pcap or pcapng file")
+}
+
+// readPcap reads a classic pcap stream after its magic number
+func readPcap(r io.Reader, bo binary.ByteOrder, nanos bool, fn func(time.Time, uint32, []byte)) error {
+	var hdr [20]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return fmt.Errorf("pcap header: %w", err)
+	}
+	snaplen := bo.Uint32(hdr[12:])
+	linkType := bo.Uint32(hdr[16:]) & 0xffff
+	var rec [16]byte
+	for {
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("pcap record: %w", err)
+		}
+		sec, frac := int64(bo.Uint32(rec[0:])), int64(bo.Uint32(rec[4:]))
+		if !nanos {
+			frac *= 1000
+		}
+		n := bo.Uint32(rec[8:])
+		if n > maxCaptureRecord || (snaplen > 0 && n > snaplen) {
+			return fmt.Errorf("pcap record: length %d exceeds the snaplen %d or %d bytes", n, snaplen, maxCaptureRecord)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("pcap record: %w", err)
+		}
+		fn(time.Unix(sec, frac), linkType, data)
+	}
+}
+
+// readPcapng reads the Enhanced (and obsolete) Packet Blocks of a pcapng stream
+func readPcapng(r io.Reader, fn func(time.Time, uint32, []byte)) error {
+	type iface struct {
+		linkType uint32
+		// ticks per second and offset of the timestamps
+		resol  uint64
+		offset int64
+	}
+	var (
+		bo     binary.ByteOrder = binary.LittleEndian
+		ifaces []iface
+		head   [8]byte
+	)
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("pcapng block: %w", err)
+		}
+		typ := binary.LittleEndian.Uint32(head[0:])
+		if typ == pcapngSHB {
+			// the byte-order magic of each section decides the byte order of its blocks
+			var bom [4]byte
+			if _, err := io.ReadFull(r, bom[:]); err != nil {
+				return fmt.Errorf("pcapng section header: %w", err)
+			}
+			bo = binary.LittleEndian
+			if binary.BigEndian.Uint32(bom[:]) == 0x1a2b3c4d {
+				bo = binary.BigEndian
+			}
+			n := bo.Uint32(head[4:])
+			if n < 28 || n%4 != 0 {
+				return fmt.Errorf("pcapng section header: bad length %d", n)
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(n)-12); err != nil {
+				return fmt.Errorf("pcapng section header: %w", err)
+			}
+			ifaces = ifaces[:0]
+			continue
+		}
+		n := bo.Uint32(head[4:])
+		if n < 12 || n%4 != 0 || n > maxCaptureRecord+64<<10 {
+			return fmt.Errorf("pcapng block: bad length %d", n)
+		}
+		body := make([]byte, n-12)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return fmt.Errorf("pcapng block: %w", err)
+		}
+		if _, err := io.CopyN(io.Discard, r, 4); err != nil {
+			return fmt.Errorf("pcapng block: %w", err)
+		}
+
+		switch bo.Uint32(head[0:]) {
+		case pcapngIDB:
+			if len(body) < 8 {
+				return errors.New("pcapng interface block: too short")
+			}
+			itf := iface{linkType: uint32(bo.Uint16(body[0:])), resol: 1e6}
+			for opts := body[8:]; len(opts) >= 4; {
+				code, l := bo.Uint16(opts[0:]), int(bo.Uint16(opts[2:]))
+				if code == pcapngOptEnd || 4+l > len(opts) {
+					break
+				}
+				val := opts[4 : 4+l]
+				switch {
+				case code == pcapngOptTSResol && l == 1:
+					if val[0]&0x80 != 0 {
+						itf.resol = 1 << (val[0] & 0x7f)
+					} else {
+						itf.resol = uint64(math.Pow10(int(val[0])))
+					}
+				case code == 14 && l == 8: // if_tsoffset
+					itf.offset = int64(bo.Uint64(val))
+				}
+				opts = opts[4+l+pad4(l):]
+			}
+			ifaces = append(ifaces, itf)
+		case pcapngEPB, 2: // enhanced and obsolete packet blocks
+			if len(body) < 20 {
+				return errors.New("pcapng packet block: too short")
+			}
+			id := bo.Uint32(body[0:])
+			if bo.Uint32(head[0:]) == 2 {
+				id = uint32(bo.Uint16(body[0:]))
+			}
+			if int(id) >= len(ifaces) {
+				return fmt.Errorf("pcapng packet block: unknown interface %d", id)
+			}
+			itf := ifaces[id]
+			ts := uint64(bo.Uint32(body[4:]))<<32 | uint64(bo.Uint32(body[8:]))
+			capLen := bo.Uint32(body[12:])
+			if int(capLen) > len(body)-20 {
+				return errors.New("pcapng packet block: captured length exceeds block")
+			}
+			sec := int64(ts / itf.resol)
+			nsec := int64(float64(ts%itf.resol) * 1e9 / float64(itf.resol))
+			fn(time.Unix(sec+itf.offset, nsec), itf.linkType, body[20:20+capLen])
+		}
+	}
+}
+
+// udpPayload strips link, IP and UDP headers; IP fragments and IPv6 extension headers are not decoded
+func udpPayload(linkType uint32, b []byte) ([]byte, bool) {
+	var ip []byte
+	switch linkType {
+	case linkTypeNull, linkTypeLoop:
+		if len(b) < 4 {
+			return nil, false
+		}
+		ip = b[4:]
+	case linkTypeEthernet:
+		if len(b) < 14 {
+			return nil, false
+		}
+		etherType, off := binary.BigEndian.Uint16(b[12:]), 14
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(b) >= off+4 {
+			etherType, off = binary.BigEndian.Uint16(b[off+2:]), off+4
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return nil, false
+		}
+		ip = b[off:]
+	case linkTypeRaw, linkTypeRawAlt, linkTypeRawAlt2, linkTypeIPv4, linkTypeIPv6:
+		ip = b
+	case linkTypeSLL:
+		if len(b) < 16 {
+			return nil, false
+		}
+		ip = b[16:]
+	case linkTypeSLL2:
+		if len(b) < 20 {
+			return nil, false
+		}
+		ip = b[20:]
+	default:
+		return nil, false
+	}
+	if len(ip) < 1 {
+		return nil, false
+	}
+
+	var udp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		if len(ip) < 20 {
+			return nil, false
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		if ip[9] != 17 || binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 || ihl < 20 || total < ihl || total > len(ip) {
+			return nil, false
+		}
+		udp = ip[ihl:total]
+	case 6:
+		if len(ip) < 40 || ip[6] != 17 {
+			return nil, false
+		}
+		total := 40 + int(binary.BigEndian.Uint16(ip[4:]))
+		if total > len(ip) {
+			return nil, false
+		}
+		udp = ip[40:total]
+	default:
+		return nil, false
+	}
+	if len(udp) < 8 {
+		return nil, false
+	}
+	l := int(binary.BigEndian.Uint16(udp[4:]))
+	if l < 8 || l > len(udp) {
+		return nil, false
+	}
+	return udp[8:l], true
+}
@@ -0,0 +1,43 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPcapRejectsOversizedRecord(t *testing.T) {
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	_ = binary.Write(&b, le, [6]uint32{0xa1b2c3d4, 0x00040002, 0, 0, 65535, linkTypeEthernet})
+	// a corrupt record claiming 4 GiB must fail before it is allocated
+	_ = binary.Write(&b, le, [4]uint32{0, 0, 0xffffffff, 0xffffffff})
+
+	err := readCapture(&b, func(time.Time, uint32, []byte) { t.Fatal("record delivered") })
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("err = %v, want an oversized record error", err)
+	}
+}
+
+// A hand-built replay without packets or with a period that doesn't pass its last packet
+// would panic or never leave the send loop
+func TestRunRejectsBadReplay(t *testing.T) {
+	for _, r := range []*RTPReplay{
+		{Name: "empty", Period: time.Second},
+		{Name: "zero_period", Packets: []ReplayPacket{{Size: 100}}},
+		{Name: "short_period", Packets: []ReplayPacket{{Size: 100}, {At: time.Second, Size: 100}}, Period: time.Second},
+	} {
+		sc := Scenario{
+			Name:     r.Name,
+			Duration: time.Second,
+			IDs:      testIDs,
+			Sender:   SenderSpec{Replay: r},
+			K:        10,
+		}
+		if _, err := RunScenario(sc, RunOptions{Mode: ModeStatic, Seed: 1}); err == nil {
+			t.Errorf("%s: RunScenario accepted the replay", r.Name)
+		}
+	}
+}
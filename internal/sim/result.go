@@ -18,17 +18,57 @@ type RunResult struct {
 	DroppedFECPkts   int64
 	DroppedQueuePkts int64
 	DroppedWirePkts  int64
+	DroppedAQMPkts   int64
+	// DropsByReason counts forward-link media and FEC drops per DropReason
+	DropsByReason map[DropReason]int64
 
 	RecvMediaPkts int64
 	RecvFECPkts   int64
 
+	// RecoveredPkts counts media packets restored by FEC, RecoveredRTXPkts by retransmission
 	RecoveredPkts int64
 	UniquePkts    int64
 
+	// DuplicatePkts counts media and FEC packets received more than once; ReorderedPkts media
+	// packets arriving after a newer one, MaxReorderDepth the largest sequence distance to it
+	DuplicatePkts   int64
+	ReorderedPkts   int64
+	MaxReorderDepth int64
+
+	NACKedPkts         int64
+	RetransmittedPkts  int64
+	RetransmittedBytes int64
+	DroppedRTXPkts     int64
+	RecvRTXPkts        int64
+	RecoveredRTXPkts   int64
+
 	GoodWithinDeadline  int64
 	FinalLossNoDeadline float64
 	FinalLossDeadline   float64
 
+	// Latency is the time from sending a media packet until it is available at the receiver,
+	// also split by how it got there (received, restored by FEC or by RTX). RecoveryDelay is,
+	// for restored packets, the time from their expected arrival (PacketMeta.ExpectedAt of the
+	// drop, with queueing and serialization) until they were restored
+	Latency       *Histogram
+	DirectLatency *Histogram
+	FECLatency    *Histogram
+	RTXLatency    *Histogram
+	RecoveryDelay *Histogram
+
 	OverheadRatioPkts  float64
 	OverheadRatioBytes float64
+
+	// Frame-level quality (video senders only, zero otherwise)
+	Frames              int64
+	DecodableFrames     int64
+	DecodableFrameRatio float64
+	FreezeCount         int64
+	TotalFreeze         time.Duration
+	MaxFreeze           time.Duration
+	Freezes             []Freeze
+
+	CrossTraffic []CrossFlowResult
+	// Hops has per-hop drops and queue delays of the forward path (a single entry without Scenario.Path)
+	Hops []HopResult
 }
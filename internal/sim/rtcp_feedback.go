@@ -0,0 +1,169 @@
+package sim
+
+import (
+	"math"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// receptionStats keeps the RFC 3550 (A.1, A.3, A.8) per-source receive statistics
+// a receiver needs for its reception report blocks
+type receptionStats struct {
+	clockRate float64
+
+	started  bool
+	baseSeq  uint16
+	maxSeq   uint16
+	cycles   uint32
+	received uint32
+
+	expectedPrior uint32
+	receivedPrior uint32
+
+	hasPrev     bool
+	prevArrival time.Time
+	prevTS      uint32
+	jitter      float64 // timestamp units
+
+	lastSR   uint32 // middle 32 bits of the last SR NTP timestamp
+	lastSRAt time.Time
+}
+
+func (s *receptionStats) OnPacket(seq uint16, ts uint32, at time.Time) {
+	if !s.started {
+		s.started = true
+		s.baseSeq = seq
+		s.maxSeq = seq
+	} else if isNewerSeq(s.maxSeq, seq) {
+		if seq < s.maxSeq {
+			s.cycles += 1 << 16
+		}
+		s.maxSeq = seq
+	}
+	s.received++
+
+	if s.hasPrev {
+		// D(i,j) = (Rj - Ri) - (Sj - Si)
+		d := at.Sub(s.prevArrival).Seconds()*s.clockRate - float64(int32(ts-s.prevTS))
+		s.jitter += (math.Abs(d) - s.jitter) / 16
+	}
+	s.prevArrival = at
+	s.prevTS = ts
+	s.hasPrev = true
+}
+
+func (s *receptionStats) OnSenderReport(sr *rtcp.SenderReport, at time.Time) {
+	s.lastSR = uint32(sr.NTPTime >> 16)
+	s.lastSRAt = at
+}
+
+func (s *receptionStats) extendedMax() uint32 { return s.cycles + uint32(s.maxSeq) }
+
+// Report builds the reception report block for ssrc and starts a new reporting interval
+func (s *receptionStats) Report(ssrc uint32, now time.Time) rtcp.ReceptionReport {
+	rr := rtcp.ReceptionReport{SSRC: ssrc}
+	if !s.started {
+		return rr
+	}
+
+	expected := s.extendedMax() - uint32(s.baseSeq) + 1
+	lost := int64(expected) - int64(s.received)
+	if lost < 0 {
+		lost = 0
+	}
+
+	expectedInterval := expected - s.expectedPrior
+	receivedInterval := s.received - s.receivedPrior
+	s.expectedPrior = expected
+	s.receivedPrior = s.received
+	lostInterval := int64(expectedInterval) - int64(receivedInterval)
+	if expectedInterval > 0 && lostInterval > 0 {
+		rr.FractionLost = uint8((lostInterval << 8) / int64(expectedInterval))
+	}
+
+	if lost > 0x7fffff {
+		lost = 0x7fffff
+	}
+	rr.TotalLost = uint32(lost)
+	rr.LastSequenceNumber = s.extendedMax()
+	rr.Jitter = uint32(s.jitter)
+	if !s.lastSRAt.IsZero() {
+		rr.LastSenderReport = s.lastSR
+		rr.Delay = uint32(now.Sub(s.lastSRAt).Seconds() * 65536)
+	}
+	return rr
+}
+
+// feedbackTracker is the sender's view of the path, built only from RTCP receiver reports
+// that made it back over the reverse link
+type feedbackTracker struct {
+	clockRate float64
+
+	reports  int64
+	lastAt   time.Time
+	loss     float64
+	jitter   time.Duration
+	rtt      time.Duration
+	hasRTT   bool
+	inWindow int
+
+	newExt, newLost   uint32
+	usedExt, usedLost uint32
+	usedAny           bool
+}
+
+func (f *feedbackTracker) OnReport(rr rtcp.ReceptionReport, at time.Time) {
+	if f.reports > 0 && !isNewerExt(f.newExt, rr.LastSequenceNumber) && rr.LastSequenceNumber != f.newExt {
+		return // reordered stale report
+	}
+	f.reports++
+	f.inWindow++
+	f.lastAt = at
+	f.loss = float64(rr.FractionLost) / 256
+	f.newExt = rr.LastSequenceNumber
+	f.newLost = rr.TotalLost
+	if f.clockRate > 0 {
+		f.jitter = time.Duration(float64(rr.Jitter) / f.clockRate * float64(time.Second))
+	}
+
+	if rr.LastSenderReport != 0 {
+		// RTT = A - LSR - DLSR in 1/65536 s (RFC 3550 6.4.1)
+		a := uint32(toNTP(at) >> 16)
+		rtt := int64(int32(a - rr.LastSenderReport - rr.Delay))
+		if rtt >= 0 {
+			f.rtt = time.Duration(rtt) * time.Second / 65536
+			f.hasRTT = true
+		}
+	}
+}
+
+// WindowLoss returns the loss over all reports received since the previous call,
+// derived from cumulative counters; without new reports the last value is held (stale)
+func (f *feedbackTracker) WindowLoss() float64 {
+	if f.inWindow == 0 {
+		return f.loss
+	}
+	loss := f.loss
+	if f.usedAny && f.newExt != f.usedExt {
+		expected := float64(f.newExt - f.usedExt)
+		lost := float64(int64(f.newLost) - int64(f.usedLost))
+		loss = clamp01(lost / expected)
+	}
+	f.usedExt, f.usedLost, f.usedAny = f.newExt, f.newLost, true
+	f.inWindow = 0
+	f.loss = loss
+	return loss
+}
+
+func isNewerExt(prev, v uint32) bool { return int32(v-prev) > 0 }
+
+// ntpEpochOffset is the number of seconds between 1900-01-01 and 1970-01-01
+const ntpEpochOffset = 2208988800
+
+func toNTP(t time.Time) uint64 {
+	ns := t.UnixNano()
+	secs := uint64(ns/int64(time.Second)) + ntpEpochOffset
+	frac := uint64(ns%int64(time.Second)) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
@@ -2,15 +2,15 @@
 package sim
 
 import (
-	"context"
+	"fmt"
 	"math"
-	"runtime"
 	"time"
 
 	"github.com/lars-sto/adaptive-error-recovery-controller/recovery"
 	"github.com/lars-sto/error-recovery-simulation/internal/adapter"
 	"github.com/pion/interceptor"
 	"github.com/pion/interceptor/pkg/flexfec"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 )
 
@@ -18,31 +18,19 @@ type RunOptions struct {
 	Mode     Mode
 	Seed     int64
 	Recorder Recorder
-}
-
-type simStatsSource struct {
-	ch chan recovery.NetworkStats
-}
-
-func newSimStatsSource() *simStatsSource {
-	// unbuffered helps ordering; we additionally wait for observer-ack
-	return &simStatsSource{ch: make(chan recovery.NetworkStats)}
-}
-
-func (s *simStatsSource) Stats() <-chan recovery.NetworkStats { return s.ch }
-func (s *simStatsSource) Close()                              { close(s.ch) }
-
-type simObserver struct {
-	processed chan struct{}
-}
 
-func (o *simObserver) OnSample(_ recovery.NetworkStats, _ recovery.PolicyDecision, _ bool) {
-	// signal "engine processed one stats sample"
-	select {
-	case o.processed <- struct{}{}:
-	default:
-		// never block
-	}
+	// FECDecoder selects the receiver's FlexFEC recovery (empty = peeling)
+	FECDecoder FECDecoderMode
+
+	// Engine configures the adaptive controller (nil = DefaultEngineConfig); the baselines
+	// take their max_overhead and protection_scale from it
+	Engine *recovery.Config
+	// OracleTarget is the residual loss ModeOracle aims for (0 = DefaultOracleTarget)
+	OracleTarget float64
+	// Policy replaces the built-in policy of Mode, which then only labels the run
+	Policy PolicyFactory
+	// Pcap captures the run's RTP packets (nil disables); the run closes it
+	Pcap *PcapWriter
 }
 
 func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
@@ -53,10 +41,17 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 		Duration: sc.Duration,
 	}
 
-	// Seed link jitter + loss model deterministically per run
-	linkSpec := sc.Link
-	linkSpec.Seed = opt.Seed
-	linkSpec.Loss = reseedLossModel(sc.Link.Loss, opt.Seed)
+	hops, hopDelay, pathDelay := seedHops(sc, opt.Seed)
+	for i, c := range sc.CrossTraffic {
+		if c.Hop < 0 || c.Hop >= len(hops) {
+			return res, fmt.Errorf("cross traffic %d: hop %d out of range (path has %d hops)", i, c.Hop, len(hops))
+		}
+	}
+	if r := sc.Sender.Replay; r != nil {
+		if err := r.validate(); err != nil {
+			return res, err
+		}
+	}
 
 	start := sc.Sender.StartTime
 	if start.IsZero() {
@@ -64,30 +59,69 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 	}
 	end := start.Add(sc.Duration)
 
-	link := NewLink(linkSpec, start)
-	recv := NewReceiver(sc.IDs)
+	link := NewPath(hops, start)
+	recvOpts := []ReceiverOption{
+		WithFECDecoderMode(opt.FECDecoder),
+		WithClockRate(sc.Sender.ClockRate()),
+		WithStartSeq(sc.Sender.StartSeq),
+	}
+	if sc.RTX != nil {
+		recvOpts = append(recvOpts, WithNACK(*sc.RTX))
+	}
 
-	// Maps for deadline metric
-	sendAt := make(map[uint16]time.Time, int(sc.Duration/sc.Sender.Interval())+8)
+	// a Scenario built in code may leave the sender without a rate
+	interval := sc.Sender.Interval()
+	if interval <= 0 {
+		interval = 20 * time.Millisecond
+	}
 
-	// Pion interceptor stack (FlexFEC encoder)
-	bus := adapter.NewRuntimeBus()
-	flexAdapter := adapter.NewFlexFECAdapter(bus)
+	// Maps for deadline metric, keyed by extended sequence number (StartSeq + packet index)
+	sendAt := make(map[uint64]time.Time, int(sc.Duration/interval)+8)
+	var mediaSeqs seqUnwrapper
 
-	reg := &interceptor.Registry{}
+	latency := newLatencyTracker(pathDelay)
+	recvOpts = append(recvOpts, withOnAvailable(func(seq uint64, at time.Time, via availSource) {
+		if sAt, ok := sendAt[seq]; ok {
+			latency.Record(seq, sAt, at, via)
+		}
+	}))
+	recv := NewReceiver(sc.IDs, recvOpts...)
 
-	initialR := sc.StaticR
-	fecFactory, err := flexfec.NewFecInterceptor(
-		flexfec.WithConfigSource(bus),
-		flexfec.NumMediaPackets(sc.K),
-		flexfec.NumFECPackets(initialR),
+	// Optional reverse path (RTCP feedback and/or NACKs), seeded independently of the forward link
+	var (
+		revLink  *Link
+		feedback *feedbackTracker
+		rtx      *rtxSender
 	)
-	if err != nil {
-		return res, err
+	if sc.Feedback != nil || sc.RTX != nil {
+		revSeed := int64(splitmix64(uint64(opt.Seed) ^ 0x7266656564626163))
+		var revSpec LinkSpec
+		if sc.Feedback != nil {
+			revSpec = sc.Feedback.Link
+		} else {
+			revSpec = sc.RTX.Link
+		}
+		revSpec.Seed = revSeed
+		revSpec.Loss = reseedLossModel(revSpec.Loss, revSeed)
+		revLink = NewLink(revSpec, start)
 	}
-	reg.Add(fecFactory)
+	if sc.Feedback != nil {
+		feedback = &feedbackTracker{clockRate: float64(sc.Sender.ClockRate())}
+	}
+	if sc.RTX != nil {
+		rtx = newRTXSender(sc.IDs, sc.RTX.HistorySize)
+	}
+	receiverSSRC := sc.IDs.ReceiverSSRC
+	if receiverSSRC == 0 {
+		receiverSSRC = 1
+	}
+
+	// Pion interceptor stack (FlexFEC encoder)
+	bus := adapter.NewRuntimeBus()
+	flexAdapter := adapter.NewFlexFECAdapter(bus)
 
-	i, err := reg.Build("")
+	initialR := sc.StaticR
+	i, err := newFECInterceptor(sc, bus)
 	if err != nil {
 		return res, err
 	}
@@ -112,13 +146,67 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 		droppedFECPkts   int64
 		droppedQueuePkts int64
 		droppedWirePkts  int64
+		droppedAQMPkts   int64
+		dropsByReason    = make(map[DropReason]int64)
+
+		nackedPkts         int64
+		retransmittedPkts  int64
+		retransmittedBytes int64
+		droppedRTXPkts     int64
 	)
 
 	// Per-stats-window deltas (media-focused for loss rate)
 	var winSentMedia int64
 	var winDropMedia int64
+	var winCongestionMedia int64 // queue and AQM drops, part of winDropMedia
 	var winBytesTotal int64
 
+	// Measured RTT (forward delay of delivered packets + return path) and RFC 3550 jitter
+	returnDelay := sc.ReturnDelay
+	if returnDelay <= 0 {
+		returnDelay = pathDelay
+	}
+	rttWin := newRTTWindow(time.Duration(sc.RTTMs)*time.Millisecond, returnDelay)
+	var jitter jitterEstimator
+
+	cross := newCrossTraffic(sc.CrossTraffic, start, end, opt.Seed, hopDelay)
+
+	// countDrop books a forward-link drop, either from a SendOutcome or reported later by the queue
+	countDrop := func(meta PacketMeta, reason DropReason) {
+		if opt.Pcap != nil && !meta.IsRTCP && !meta.IsCross {
+			opt.Pcap.Dropped(meta, reason, now)
+		}
+		switch {
+		case meta.IsRTCP:
+			return
+		case meta.IsCross:
+			cross.OnDrop(meta, now)
+			return
+		case sc.RTX != nil && meta.SSRC == sc.IDs.RTXSSRC:
+			droppedRTXPkts++
+			return
+		case meta.IsFEC:
+			droppedFECPkts++
+		default:
+			droppedMediaPkts++
+			winDropMedia++
+			latency.Lost(mediaSeqs.extend(meta.Seq), meta.ExpectedAt)
+		}
+		dropsByReason[reason]++
+		switch {
+		case reason == DropQueue:
+			droppedQueuePkts++
+		case reason == DropWireLoss || reason == DropZeroCap:
+			droppedWirePkts++
+		case reason.IsAQM():
+			droppedAQMPkts++
+		}
+		if !meta.IsFEC && (reason == DropQueue || reason.IsAQM()) {
+			winCongestionMedia++
+		}
+	}
+	link.OnDrop(countDrop)
+
 	linkWriter := interceptor.RTPWriterFunc(func(h *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
 		p := make([]byte, len(payload))
 		copy(p, payload)
@@ -126,7 +214,13 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 		pkt := rtp.Packet{Header: *h, Payload: p}
 
 		isFEC := (h.SSRC == sc.IDs.FECSSRC) || (h.PayloadType == sc.IDs.FECPT)
+		if opt.Pcap != nil {
+			opt.Pcap.Sent(pkt, now)
+		}
 		out := link.Send(pkt, now, isFEC)
+		if out.Dropped {
+			countDrop(PacketMeta{SSRC: h.SSRC, Seq: h.SequenceNumber, IsFEC: isFEC, ExpectedAt: out.ExpectedAt}, out.Reason)
+		}
 
 		if isFEC {
 			sentFECPkts++
@@ -136,23 +230,11 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 			sentMediaBytes += int64(out.SizeBytes)
 
 			winSentMedia++
-		}
-		winBytesTotal += int64(out.SizeBytes)
-
-		if out.Dropped {
-			if isFEC {
-				droppedFECPkts++
-			} else {
-				droppedMediaPkts++
-				winDropMedia++
-			}
-			switch out.Reason {
-			case DropQueue:
-				droppedQueuePkts++
-			case DropWireLoss, DropZeroCap:
-				droppedWirePkts++
+			if rtx != nil {
+				rtx.Store(pkt)
 			}
 		}
+		winBytesTotal += int64(out.SizeBytes)
 
 		return len(payload), nil
 	})
@@ -166,55 +248,98 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 	pipelineWriter := i.BindLocalStream(streamInfo, linkWriter)
 	defer i.UnbindLocalStream(streamInfo)
 
-	// Adaptive engine (optional)
-	var (
-		statsSrc  *simStatsSource
-		observer  *simObserver
-		engineCtx context.Context
-		cancel    context.CancelFunc
-	)
-	if opt.Mode == ModeAdaptive {
-		statsSrc = newSimStatsSource()
-		observer = &simObserver{processed: make(chan struct{}, 16)}
+	// Event times
+	statsEvery := sc.StatsInterval
+	if statsEvery <= 0 {
+		statsEvery = 200 * time.Millisecond
+	}
 
-		sink := adapter.SinkFunc(func(d recovery.PolicyDecision) {
-			flexAdapter.Apply(sc.IDs.MediaSSRC, d)
+	reportEvery := statsEvery
+	if sc.Feedback != nil && sc.Feedback.ReportInterval > 0 {
+		reportEvery = sc.Feedback.ReportInterval
+	}
 
-			// update policy snapshot for recorder
-			f := d.FEC
-			polEnabled = f.Enabled
-			polK = f.NumMediaPackets
-			polR = f.NumFECPackets
-			polOver = overhead(polK, polR)
-		})
+	// Decisions of every policy take the same path to the encoder
+	sink := adapter.SinkFunc(func(d recovery.PolicyDecision) {
+		flexAdapter.Apply(sc.IDs.MediaSSRC, d)
 
-		engineCfg := recovery.DefaultConfig()
-		engineCfg.Scheme = recovery.FECSchemeFlexFEC03
+		// update policy snapshot for recorder
+		f := d.FEC
+		polEnabled = f.Enabled
+		polK = f.NumMediaPackets
+		polR = f.NumFECPackets
+		polOver = overhead(polK, polR)
+	})
 
-		engine := recovery.NewEngine(engineCfg, statsSrc, sink, observer)
-		engineCtx, cancel = context.WithCancel(context.Background())
-		go engine.Run(engineCtx)
+	policy, err := newRunPolicy(sc, opt, hops, statsEvery)
+	if err != nil {
+		return res, err
 	}
+	defer func() {
+		if policy != nil {
+			_ = policy.Close()
+		}
+	}()
 
-	// Event times
-	interval := sc.Sender.Interval()
-	if interval <= 0 {
-		interval = 20 * time.Millisecond
+	nextMedia := start
+	nextStats := start.Add(statsEvery)
+	nextReport := start.Add(reportEvery)
+
+	var (
+		lastTS     uint32
+		mediaCount uint32
+	)
+
+	deadline := sc.PlayoutDeadline
+	if deadline <= 0 {
+		deadline = 200 * time.Millisecond
 	}
-	statsEvery := sc.StatsInterval
-	if statsEvery <= 0 {
-		statsEvery = 200 * time.Millisecond
+
+	var (
+		video  *videoSource
+		replay *replaySource
+		frames *frameTracker
+	)
+	if sc.Sender.Replay != nil {
+		replay = newReplaySource(sc.Sender.Replay)
+	} else if sc.Sender.Video != nil {
+		video = newVideoSource(sc.Sender, opt.Seed)
+		frames = newFrameTracker(start, deadline, interval)
 	}
 
-	nextMedia := start
-	nextStats := start.Add(statsEvery)
+	sendMedia := func(ts uint32, size int, marker bool) error {
+		seq := sc.Sender.StartSeq + uint16(mediaCount)
+		ext := mediaSeqs.Unwrap(seq)
+		mediaCount++
+
+		h := &rtp.Header{
+			Version:        2,
+			Marker:         marker,
+			PayloadType:    sc.IDs.MediaPT,
+			SequenceNumber: seq,
+			Timestamp:      ts,
+			SSRC:           sc.IDs.MediaSSRC,
+		}
+
+		payload := makePayload(opt.Seed, seq, size)
 
-	// Main event loop: process next (delivery | stats | media) in time order
+		sendAt[ext] = now
+		lastTS = ts
+
+		_, err := pipelineWriter.Write(h, payload, interceptor.Attributes{})
+		return err
+	}
+
+	// Main event loop: process next (delivery | feedback | nack | stats | report | cross | media) in time order
 	for {
-		tDel, hasDel := peekDelivery(link)
+		tDel, hasDel := link.NextEvent()
+		tFb, hasFb := peekDelivery(revLink)
+		tNack, hasNack := recv.NextNACK()
+		tCross, hasCross := cross.Next()
 
 		mediaEnabled := nextMedia.Before(end) || nextMedia.Equal(end)
 		statsEnabled := nextStats.Before(end) || nextStats.Equal(end)
+		reportEnabled := feedback != nil && (nextReport.Before(end) || nextReport.Equal(end))
 
 		next := time.Time{}
 		set := false
@@ -223,10 +348,26 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 			next = tDel
 			set = true
 		}
+		if hasFb && (!set || tFb.Before(next)) {
+			next = tFb
+			set = true
+		}
+		if hasNack && (!set || tNack.Before(next)) {
+			next = tNack
+			set = true
+		}
 		if statsEnabled && (!set || nextStats.Before(next)) {
 			next = nextStats
 			set = true
 		}
+		if reportEnabled && (!set || nextReport.Before(next)) {
+			next = nextReport
+			set = true
+		}
+		if hasCross && (!set || tCross.Before(next)) {
+			next = tCross
+			set = true
+		}
 		if mediaEnabled && (!set || nextMedia.Before(next)) {
 			next = nextMedia
 			set = true
@@ -238,21 +379,100 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 
 		now = next
 
-		// Priority: deliver first if equal time, then stats, then media
+		// Priority: deliver first if equal time, then feedback, NACKs, stats, reports, cross traffic, media
 		if hasDel && now.Equal(tDel) {
+			if link.Service(now) {
+				continue
+			}
 			dp, _ := link.Next()
+			if dp.RTCP != nil {
+				if err := recv.OnRTCP(dp.RTCP, dp.Arrives); err != nil {
+					return res, err
+				}
+				continue
+			}
+			if dp.Cross != nil {
+				cross.OnDelivered(dp.Cross, dp.Arrives)
+				continue
+			}
+			rttWin.Add(dp.SentAt, dp.Arrives)
+			if dp.Pkt.SSRC == sc.IDs.MediaSSRC {
+				jitter.Update(dp.SentAt, dp.Arrives)
+			}
+			if opt.Pcap != nil {
+				opt.Pcap.Delivered(dp.Pkt, dp.Arrives)
+			}
 			recv.OnPacket(dp.Pkt, dp.Arrives)
 			continue
 		}
 
+		if hasFb && now.Equal(tFb) {
+			if revLink.Service(now) {
+				continue
+			}
+			dp, _ := revLink.Next()
+			pkts, err := rtcp.Unmarshal(dp.RTCP)
+			if err != nil {
+				return res, err
+			}
+			for _, p := range pkts {
+				switch fb := p.(type) {
+				case *rtcp.ReceiverReport:
+					if feedback == nil {
+						continue
+					}
+					for _, rep := range fb.Reports {
+						if rep.SSRC == sc.IDs.MediaSSRC {
+							feedback.OnReport(rep, dp.Arrives)
+						}
+					}
+				case *rtcp.TransportLayerNack:
+					if rtx == nil || fb.MediaSSRC != sc.IDs.MediaSSRC {
+						continue
+					}
+					for _, pair := range fb.Nacks {
+						for _, seq := range pair.PacketList() {
+							rp, ok := rtx.Retransmission(seq)
+							if !ok {
+								continue
+							}
+							if opt.Pcap != nil {
+								opt.Pcap.Sent(rp, now)
+							}
+							out := link.Send(rp, now, false)
+							retransmittedPkts++
+							retransmittedBytes += int64(out.SizeBytes)
+							winBytesTotal += int64(out.SizeBytes)
+							if out.Dropped {
+								countDrop(PacketMeta{SSRC: rp.SSRC, Seq: rp.SequenceNumber}, out.Reason)
+							}
+						}
+					}
+				}
+			}
+			continue
+		}
+
+		if hasNack && now.Equal(tNack) {
+			raw, n, err := recv.NACK(now)
+			if err != nil {
+				return res, err
+			}
+			if n > 0 {
+				nackedPkts += int64(n)
+				revLink.SendRTCP(raw, receiverSSRC, now)
+			}
+			continue
+		}
+
 		if statsEnabled && now.Equal(nextStats) {
 			elapsed := now.Sub(start)
 
 			// Loss window (pre-FEC media drop ratio)
-			loss := 0.0
+			trueLoss := 0.0
 			if winSentMedia > 0 {
-				loss = float64(winDropMedia) / float64(winSentMedia)
-				loss = clamp01(loss)
+				trueLoss = float64(winDropMedia) / float64(winSentMedia)
+				trueLoss = clamp01(trueLoss)
 			}
 
 			// BWE schedule given to engine as TargetBitrate
@@ -261,9 +481,12 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 				targetBWE = sc.BWE.At(elapsed)
 			}
 
-			capBps := 0.0
-			if sc.Link.CapacityBps != nil {
-				capBps = sc.Link.CapacityBps.At(elapsed)
+			// the bottleneck of the path (0 if no hop is limited)
+			capBps, limited := 0.0, false
+			for _, h := range hops {
+				if c, ok := h.Link.capacityBps(elapsed-statsEvery, elapsed); ok && (!limited || c < capBps) {
+					capBps, limited = c, true
+				}
 			}
 
 			// Current bitrate: bytes sent in window / window duration
@@ -273,23 +496,81 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 				currentBps = float64(winBytesTotal*8) / winSec
 			}
 
-			// Push to engine, wait for observer ack (engine processed)
-			if opt.Mode == ModeAdaptive && statsSrc != nil && observer != nil {
-				statsSrc.ch <- recovery.NetworkStats{
-					RTTMs:          sc.RTTMs,
-					JitterMs:       sc.JitterMs,
-					LossRate:       loss,
-					TargetBitrate:  targetBWE,
-					CurrentBitrate: currentBps,
-					Timestamp:      now,
+			rtt := rttWin.Close()
+			jitterMs := float64(sc.JitterMs)
+			if jitter.hasPrev {
+				jitterMs = float64(jitter.Jitter()) / float64(time.Millisecond)
+			}
+
+			// With feedback the engine only sees what receiver reports told the sender
+			loss := trueLoss
+			feedbackAgeMs := 0.0
+			if feedback != nil {
+				loss = feedback.WindowLoss()
+				rtt = time.Duration(sc.RTTMs) * time.Millisecond
+				if feedback.hasRTT {
+					rtt = feedback.rtt
+				}
+				jitterMs = float64(sc.JitterMs)
+				feedbackAgeMs = -1
+				if feedback.reports > 0 {
+					jitterMs = float64(feedback.jitter) / float64(time.Millisecond)
+					feedbackAgeMs = float64(now.Sub(feedback.lastAt)) / float64(time.Millisecond)
+				}
+			}
+
+			// The policy decides on the window that just ended
+			if policy != nil {
+				congestion := 0.0
+				if winSentMedia > 0 {
+					congestion = float64(winCongestionMedia) / float64(winSentMedia)
+				}
+				d, ok, err := policy.Decide(PolicyInput{
+					T:      elapsed,
+					Window: statsEvery,
+					Stats: recovery.NetworkStats{
+						RTTMs:          durationMs(rtt),
+						JitterMs:       int(math.Round(jitterMs)),
+						LossRate:       loss,
+						TargetBitrate:  targetBWE,
+						CurrentBitrate: currentBps,
+						Timestamp:      now,
+					},
+					TrueLoss:       trueLoss,
+					CongestionLoss: congestion,
+					Current: recovery.FECDecision{
+						Enabled:         polEnabled,
+						NumMediaPackets: polK,
+						NumFECPackets:   polR,
+						TargetOverhead:  polOver,
+					},
+				})
+				if err != nil {
+					return res, err
 				}
-				// Ensure engine has consumed this stats sample (even if it didn't publish a new decision)
-				<-observer.processed
+				if ok {
+					sink.Publish(d)
+				}
+			}
+
+			queueDelay := float64(link.QueueDelay(now).Milliseconds())
+
+			crossSamples := cross.Sample(statsEvery)
+			var hopSamples []HopSample
+			if len(sc.Path) > 0 {
+				hopSamples = link.Samples(now)
 			}
 
-			queueDelay := 0.0
-			if link.nextAvail.After(now) {
-				queueDelay = float64(link.nextAvail.Sub(now).Milliseconds())
+			var fs frameSample
+			if frames != nil {
+				frames.Advance(now, recv.availAt)
+				fs = frameSample{
+					frames:    int64(frames.decided),
+					decodable: frames.decodable,
+					frozen:    frames.frozen,
+					freezes:   frames.FreezeCount(),
+					freezeMs:  float64(frames.FreezeTotal(now)) / float64(time.Millisecond),
+				}
 			}
 
 			// Recorder sample (always)
@@ -297,11 +578,15 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 				opt.Recorder.OnSample(TimeSample{
 					T:                 elapsed,
 					LossWindow:        loss,
+					TrueLossWindow:    trueLoss,
+					FeedbackAgeMs:     feedbackAgeMs,
 					TargetBWE:         targetBWE,
 					MediaRate:         sc.Sender.MediaBitrateBps(true),
 					CapacityBps:       capBps,
 					CurrentBitrateBps: currentBps,
 					QueueDelayMs:      queueDelay,
+					RTTMs:             float64(rtt) / float64(time.Millisecond),
+					JitterMs:          jitterMs,
 					PolicyEnabled:     polEnabled,
 					PolicyK:           polK,
 					PolicyR:           polR,
@@ -312,38 +597,89 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 					DroppedFEC:        droppedFECPkts,
 					QueueDrops:        droppedQueuePkts,
 					WireDrops:         droppedWirePkts,
+					AQMDrops:          droppedAQMPkts,
+					CrossFlows:        crossSamples,
+					Hops:              hopSamples,
+					Frames:            fs.frames,
+					DecodableFrames:   fs.decodable,
+					Frozen:            fs.frozen,
+					FreezeCount:       fs.freezes,
+					FreezeMs:          fs.freezeMs,
 				})
 			}
 
 			// Reset window counters
 			winSentMedia = 0
 			winDropMedia = 0
+			winCongestionMedia = 0
 			winBytesTotal = 0
 
 			nextStats = nextStats.Add(statsEvery)
 			continue
 		}
 
-		if mediaEnabled && now.Equal(nextMedia) {
-			seq := sc.Sender.StartSeq + uint16(len(sendAt))
-			ts := sc.Sender.StartTS + uint32(len(sendAt))*sc.Sender.TimestampStep
-
-			h := &rtp.Header{
-				Version:        2,
-				PayloadType:    sc.IDs.MediaPT,
-				SequenceNumber: seq,
-				Timestamp:      ts,
-				SSRC:           sc.IDs.MediaSSRC,
+		if reportEnabled && now.Equal(nextReport) {
+			// Sender report on the forward path (LSR/DLSR for RTT), receiver report on the reverse path
+			sr, err := rtcp.Marshal([]rtcp.Packet{&rtcp.SenderReport{
+				SSRC:        sc.IDs.MediaSSRC,
+				NTPTime:     toNTP(now),
+				RTPTime:     lastTS,
+				PacketCount: uint32(sentMediaPkts),
+				OctetCount:  uint32(sentMediaBytes),
+			}})
+			if err != nil {
+				return res, err
 			}
+			link.SendRTCP(sr, sc.IDs.MediaSSRC, now)
 
-			payload := makePayload(opt.Seed, seq, sc.Sender.PayloadBytes)
-
-			sendAt[seq] = now
-
-			_, err := pipelineWriter.Write(h, payload, interceptor.Attributes{})
+			rr, err := recv.ReceiverReport(now)
 			if err != nil {
 				return res, err
 			}
+			revLink.SendRTCP(rr, receiverSSRC, now)
+
+			nextReport = nextReport.Add(reportEvery)
+			continue
+		}
+
+		if hasCross && now.Equal(tCross) {
+			cross.Run(now, link)
+			continue
+		}
+
+		if mediaEnabled && now.Equal(nextMedia) {
+			if replay != nil {
+				// every captured packet that is due, with its size, marker and timestamp offset
+				for replay.NextAt() <= now.Sub(start) {
+					p, ts := replay.Next()
+					if err := sendMedia(sc.Sender.StartTS+ts, p.Size, p.Marker); err != nil {
+						return res, err
+					}
+				}
+				nextMedia = start.Add(replay.NextAt())
+				continue
+			}
+			if video != nil {
+				// one frame per event: all packets leave as a burst and share the timestamp
+				f := video.Next()
+				sizes := packetSizes(f.Bytes, sc.Sender.Video.mtu())
+				frames.OnFrame(frameInfo{
+					firstSeq: uint64(sc.Sender.StartSeq) + uint64(mediaCount),
+					packets:  len(sizes),
+					keyframe: f.Keyframe,
+					sendAt:   now,
+				})
+				for j, size := range sizes {
+					if err := sendMedia(f.TS, size, j == len(sizes)-1); err != nil {
+						return res, err
+					}
+				}
+			} else {
+				ts := sc.Sender.StartTS + mediaCount*sc.Sender.TimestampStep
+				if err := sendMedia(ts, sc.Sender.PayloadBytes, false); err != nil {
+					return res, err
+				}
+			}
 
 			nextMedia = nextMedia.Add(interval)
 			continue
@@ -352,25 +688,44 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 
 	// Drain remaining deliveries after end (queue may push them beyond end)
 	for {
-		tDel, hasDel := peekDelivery(link)
+		tDel, hasDel := link.NextEvent()
 		if !hasDel {
 			break
 		}
 		now = tDel
+		if link.Service(now) {
+			continue
+		}
 		dp, _ := link.Next()
+		if dp.RTCP != nil {
+			continue
+		}
+		if dp.Cross != nil {
+			cross.OnDelivered(dp.Cross, dp.Arrives)
+			continue
+		}
+		if opt.Pcap != nil {
+			opt.Pcap.Delivered(dp.Pkt, dp.Arrives)
+		}
 		recv.OnPacket(dp.Pkt, dp.Arrives)
 	}
 
-	// Stop engine + recorder
-	if opt.Mode == ModeAdaptive && statsSrc != nil && cancel != nil {
-		statsSrc.Close()
-		cancel()
-		// give engine goroutine a chance to exit cleanly
-		runtime.Gosched()
+	// Stop policy + recorder
+	if policy != nil {
+		err := policy.Close()
+		policy = nil
+		if err != nil {
+			return res, err
+		}
 	}
 	if opt.Recorder != nil {
 		_ = opt.Recorder.Close()
 	}
+	if opt.Pcap != nil {
+		if err := opt.Pcap.Close(); err != nil {
+			return res, err
+		}
+	}
 
 	// Receiver snapshot
 	snap := recv.Snapshot()
@@ -384,11 +739,25 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 	res.DroppedFECPkts = droppedFECPkts
 	res.DroppedQueuePkts = droppedQueuePkts
 	res.DroppedWirePkts = droppedWirePkts
+	res.DroppedAQMPkts = droppedAQMPkts
+	res.DropsByReason = dropsByReason
+	res.CrossTraffic = cross.Results(sc.Duration)
+	res.Hops = link.Results()
 
 	res.RecvMediaPkts = snap.RecvMedia
 	res.RecvFECPkts = snap.RecvFEC
 	res.RecoveredPkts = snap.Recovered
 	res.UniquePkts = snap.Unique
+	res.DuplicatePkts = snap.Duplicates
+	res.ReorderedPkts = snap.Reordered
+	res.MaxReorderDepth = snap.MaxReorderDepth
+
+	res.NACKedPkts = nackedPkts
+	res.RetransmittedPkts = retransmittedPkts
+	res.RetransmittedBytes = retransmittedBytes
+	res.DroppedRTXPkts = droppedRTXPkts
+	res.RecvRTXPkts = snap.RecvRTX
+	res.RecoveredRTXPkts = snap.RecoveredRTX
 
 	if sentMediaPkts > 0 {
 		res.OverheadRatioPkts = float64(sentFECPkts) / float64(sentMediaPkts)
@@ -398,33 +767,134 @@ func RunScenario(sc Scenario, opt RunOptions) (RunResult, error) {
 		res.OverheadRatioBytes = float64(sentFECBytes) / float64(sentMediaBytes)
 	}
 
-	// Deadline-aware goodput: packet counts available by (sendAt + deadline)
-	deadline := sc.PlayoutDeadline
-	if deadline <= 0 {
-		deadline = 200 * time.Millisecond
+	res.finishDelivery(sendAt, recv.availAt, deadline, latency, frames)
+	return res, nil
+}
+
+// newFECInterceptor builds the pion interceptor stack of a run: the FlexFEC encoder with
+// K and the static R of sc, reconfigured at runtime through bus
+func newFECInterceptor(sc Scenario, bus *adapter.RuntimeBus) (interceptor.Interceptor, error) {
+	reg := &interceptor.Registry{}
+	fecFactory, err := flexfec.NewFecInterceptor(fecOptions(sc, bus)...)
+	if err != nil {
+		return nil, err
 	}
+	reg.Add(fecFactory)
+	return reg.Build("")
+}
 
+// fecOptions configures the FlexFEC interceptor with the scenario's K and R, updated through bus
+func fecOptions(sc Scenario, bus *adapter.RuntimeBus) []flexfec.FecOption {
+	return []flexfec.FecOption{
+		flexfec.WithConfigSource(bus),
+		flexfec.NumMediaPackets(sc.K),
+		flexfec.NumFECPackets(sc.StaticR),
+	}
+}
+
+// seedHops copies the forward path of sc and seeds link jitter + loss model deterministically
+// per run; every further hop gets its own seed
+func seedHops(sc Scenario, seed int64) (hops []HopSpec, hopDelay []time.Duration, pathDelay time.Duration) {
+	hops = append([]HopSpec(nil), sc.hops()...)
+	hopDelay = make([]time.Duration, len(hops))
+	for i := range hops {
+		hopSeed := seed
+		if i > 0 {
+			hopSeed = int64(splitmix64(uint64(seed) ^ 0x686f70 ^ uint64(i)<<32))
+		}
+		hops[i].Link.Seed = hopSeed
+		hops[i].Link.Loss = reseedLossModel(hops[i].Link.Loss, hopSeed)
+		hopDelay[i] = hops[i].Link.BaseOneWayDelay
+		pathDelay += hopDelay[i]
+	}
+	return hops, hopDelay, pathDelay
+}
+
+// newRunPolicy builds opt.Policy or the built-in policy of opt.Mode (nil for static)
+func newRunPolicy(sc Scenario, opt RunOptions, hops []HopSpec, statsEvery time.Duration) (Policy, error) {
+	newPolicy := opt.Policy
+	if newPolicy == nil {
+		var err error
+		if newPolicy, err = builtinPolicy(opt.Mode); err != nil {
+			return nil, err
+		}
+	}
+	if newPolicy == nil {
+		return nil, nil
+	}
+	engineCfg := DefaultEngineConfig()
+	if opt.Engine != nil {
+		engineCfg = *opt.Engine
+	}
+	oracleTarget := opt.OracleTarget
+	if oracleTarget <= 0 {
+		oracleTarget = DefaultOracleTarget
+	}
+	return newPolicy(PolicyEnv{
+		Scenario:      sc,
+		Seed:          opt.Seed,
+		Engine:        engineCfg,
+		MaxFECPackets: maxFECPackets(sc.K, engineCfg),
+		StatsInterval: statsEvery,
+		oracleTarget:  oracleTarget,
+		hops:          hops,
+	})
+}
+
+// finishDelivery fills the deadline, latency and frame metrics from the send and
+// availability times of every media packet (keyed by extended sequence number)
+func (res *RunResult) finishDelivery(sendAt, availAt map[uint64]time.Time, deadline time.Duration, latency *latencyTracker, frames *frameTracker) {
+	// Deadline-aware goodput: packet counts available by (sendAt + deadline)
 	var good int64
 	for seq, sAt := range sendAt {
-		if aAt, ok := recv.availAt[seq]; ok {
+		if aAt, ok := availAt[seq]; ok {
 			if !aAt.After(sAt.Add(deadline)) {
 				good++
 			}
 		}
 	}
 	res.GoodWithinDeadline = good
-	if sentMediaPkts > 0 {
-		res.FinalLossDeadline = clamp01(1.0 - float64(good)/float64(sentMediaPkts))
+	res.Latency = latency.all
+	res.DirectLatency = latency.direct
+	res.FECLatency = latency.fec
+	res.RTXLatency = latency.rtx
+	res.RecoveryDelay = latency.recovery
+	if res.SentMediaPkts > 0 {
+		res.FinalLossDeadline = clamp01(1.0 - float64(good)/float64(res.SentMediaPkts))
 	}
 
-	return res, nil
+	if frames != nil {
+		frames.Finish(availAt)
+		res.Frames = int64(len(frames.frames))
+		res.DecodableFrames = frames.decodable
+		if res.Frames > 0 {
+			res.DecodableFrameRatio = float64(res.DecodableFrames) / float64(res.Frames)
+		}
+		res.Freezes = frames.freezes
+		res.FreezeCount = int64(len(frames.freezes))
+		for _, f := range frames.freezes {
+			res.TotalFreeze += f.Duration
+			if f.Duration > res.MaxFreeze {
+				res.MaxFreeze = f.Duration
+			}
+		}
+	}
+}
+
+// frameSample is the frame accounting state copied into a TimeSample
+type frameSample struct {
+	frames    int64
+	decodable int64
+	frozen    bool
+	freezes   int64
+	freezeMs  float64
 }
 
 func peekDelivery(l *Link) (time.Time, bool) {
-	if l == nil || l.pq.Len() == 0 {
+	if l == nil {
 		return time.Time{}, false
 	}
-	return l.pq[0].at, true
+	return l.NextEvent()
 }
 
 func makePayload(seed int64, seq uint16, size int) []byte {
@@ -458,6 +928,8 @@ func clamp01(x float64) float64 {
 	return math.Max(0, math.Min(1, x))
 }
 
+// reseedLossModel gives a run its own copy of m seeded with seed; other models are used
+// as they are (see LossModelReseeder and Scenario.CopiesLossModels)
 func reseedLossModel(m LossModel, seed int64) LossModel {
 	switch v := m.(type) {
 	case *ScheduledBernoulliLoss:
@@ -465,7 +937,21 @@ func reseedLossModel(m LossModel, seed int64) LossModel {
 	case *GilbertElliottLoss:
 		// re-create to reset per-SSRC states deterministically
 		return NewGilbertElliottLoss(v.NameStr, seed, v.PGB, v.PBG, v.PG, v.PB)
+	case *TraceLoss:
+		// fresh counters and a seed-specific start offset
+		return v.reseed(seed)
+	case LossModelReseeder:
+		return v.Reseed(seed)
 	default:
 		return m
 	}
 }
+
+// copiesLossModel tells whether reseedLossModel gives every run its own copy of m
+func copiesLossModel(m LossModel) bool {
+	switch m.(type) {
+	case nil, *ScheduledBernoulliLoss, *GilbertElliottLoss, *TraceLoss, LossModelReseeder:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,992 @@
+package sim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Loss model identifiers accepted in the "model" field of a scenario file loss section
+const (
+	LossModelNone           = "none"
+	LossModelBernoulli      = "bernoulli"
+	LossModelGilbertElliott = "gilbert_elliott"
+	LossModelTrace          = "trace"
+)
+
+// Loss trace formats accepted in the "format" field of a trace loss section
+const (
+	LossTraceBits   = "bits"
+	LossTraceEvents = "events"
+)
+
+// ScenarioFile is the on-disk representation of a Scenario (YAML or JSON)
+type ScenarioFile struct {
+	Name     string       `yaml:"name"`
+	Duration FileDuration `yaml:"duration"`
+	Seed     *int64       `yaml:"seed"`
+
+	IDs    RTPIDsFile     `yaml:"ids"`
+	Sender SenderSpecFile `yaml:"sender"`
+
+	K       uint32 `yaml:"k"`
+	StaticR uint32 `yaml:"static_r"`
+
+	StatsInterval   FileDuration  `yaml:"stats_interval"`
+	BWE             *ScheduleFile `yaml:"bwe"`
+	RTTMs           int           `yaml:"rtt_ms"`
+	JitterMs        int           `yaml:"jitter_ms"`
+	ReturnDelay     FileDuration  `yaml:"return_delay"`
+	PlayoutDeadline FileDuration  `yaml:"playout_deadline"`
+
+	Link     LinkSpecFile  `yaml:"link"`
+	Path     []HopFile     `yaml:"path"`
+	Feedback *FeedbackFile `yaml:"feedback"`
+	RTX      *RTXFile      `yaml:"rtx"`
+
+	CrossTraffic []CrossTrafficFile `yaml:"cross_traffic"`
+
+	// dir resolves relative trace paths (the directory of the scenario file)
+	dir string
+}
+
+// RTXFile enables NACK/RTX retransmission; link is only used without a feedback section
+type RTXFile struct {
+	ReorderTolerance FileDuration  `yaml:"reorder_tolerance"`
+	RetryInterval    FileDuration  `yaml:"retry_interval"`
+	MaxRetries       int           `yaml:"max_retries"`
+	HistorySize      int           `yaml:"history_size"`
+	Link             *LinkSpecFile `yaml:"link"`
+}
+
+// HopFile is one hop of a multi-hop path: a name plus the link fields
+type HopFile struct {
+	Name         string `yaml:"name"`
+	LinkSpecFile `yaml:",inline"`
+}
+
+// CrossTrafficFile is one competing flow on the forward link; kind selects which parameters apply
+type CrossTrafficFile struct {
+	Name        string           `yaml:"name"`
+	Kind        CrossTrafficKind `yaml:"kind"`
+	Hop         int              `yaml:"hop"`
+	SSRC        uint32           `yaml:"ssrc"`
+	PacketBytes int              `yaml:"packet_bytes"`
+	Start       FileDuration     `yaml:"start"`
+	Stop        FileDuration     `yaml:"stop"`
+	RateBps     float64          `yaml:"rate_bps"`
+
+	OnMean      FileDuration `yaml:"on_mean"`
+	OffMean     FileDuration `yaml:"off_mean"`
+	ParetoShape float64      `yaml:"pareto_shape"`
+
+	InitialWindow int          `yaml:"initial_window"`
+	MaxWindow     int          `yaml:"max_window"`
+	AckDelay      FileDuration `yaml:"ack_delay"`
+}
+
+func (c CrossTrafficFile) spec() CrossTrafficSpec {
+	return CrossTrafficSpec{
+		Name:          c.Name,
+		Kind:          c.Kind,
+		Hop:           c.Hop,
+		SSRC:          c.SSRC,
+		PacketBytes:   c.PacketBytes,
+		Start:         time.Duration(c.Start),
+		Stop:          time.Duration(c.Stop),
+		RateBps:       c.RateBps,
+		OnMean:        time.Duration(c.OnMean),
+		OffMean:       time.Duration(c.OffMean),
+		ParetoShape:   c.ParetoShape,
+		InitialWindow: c.InitialWindow,
+		MaxWindow:     c.MaxWindow,
+		AckDelay:      time.Duration(c.AckDelay),
+	}
+}
+
+func (c CrossTrafficFile) validate(field string, ids RTPIDsFile) []error {
+	var errs []error
+	bad := func(sub, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, sub, fmt.Sprintf(format, args...)))
+	}
+	switch c.Kind {
+	case CrossCBR, CrossParetoOnOff:
+		if c.RateBps <= 0 {
+			bad("rate_bps", "must be > 0 for %s, got %g", c.Kind, c.RateBps)
+		}
+	case CrossAIMD:
+	default:
+		bad("kind", "unknown cross traffic kind %q (expected one of %q, %q, %q)",
+			c.Kind, CrossCBR, CrossParetoOnOff, CrossAIMD)
+	}
+	if c.SSRC != 0 && (c.SSRC == ids.MediaSSRC || c.SSRC == ids.FECSSRC || c.SSRC == ids.RTXSSRC) {
+		bad("ssrc", "must differ from media, fec and rtx ssrc, got %d", c.SSRC)
+	}
+	if c.ParetoShape != 0 && c.ParetoShape <= 1 {
+		bad("pareto_shape", "must be > 1, got %g", c.ParetoShape)
+	}
+	if c.Stop != 0 && c.Stop <= c.Start {
+		bad("stop", "must be after start (%s), got %s", time.Duration(c.Start), time.Duration(c.Stop))
+	}
+	for _, v := range []struct {
+		sub string
+		n   float64
+	}{
+		{"hop", float64(c.Hop)},
+		{"packet_bytes", float64(c.PacketBytes)},
+		{"start", float64(c.Start)},
+		{"stop", float64(c.Stop)},
+		{"rate_bps", c.RateBps},
+		{"on_mean", float64(c.OnMean)},
+		{"off_mean", float64(c.OffMean)},
+		{"initial_window", float64(c.InitialWindow)},
+		{"max_window", float64(c.MaxWindow)},
+		{"ack_delay", float64(c.AckDelay)},
+	} {
+		if v.n < 0 {
+			bad(v.sub, "must be >= 0")
+		}
+	}
+	return errs
+}
+
+// FeedbackFile enables RTCP receiver reports over a reverse link
+type FeedbackFile struct {
+	ReportInterval FileDuration `yaml:"report_interval"`
+	Link           LinkSpecFile `yaml:"link"`
+}
+
+type RTPIDsFile struct {
+	MediaSSRC uint32 `yaml:"media_ssrc"`
+	FECSSRC   uint32 `yaml:"fec_ssrc"`
+	MediaPT   uint8  `yaml:"media_pt"`
+	FECPT     uint8  `yaml:"fec_pt"`
+
+	ReceiverSSRC uint32 `yaml:"receiver_ssrc"`
+	RTXSSRC      uint32 `yaml:"rtx_ssrc"`
+	RTXPT        uint8  `yaml:"rtx_pt"`
+}
+
+type SenderSpecFile struct {
+	PacketRateHz  int    `yaml:"packet_rate_hz"`
+	PayloadBytes  int    `yaml:"payload_bytes"`
+	StartSeq      uint16 `yaml:"start_seq"`
+	StartTS       uint32 `yaml:"start_ts"`
+	TimestampStep uint32 `yaml:"timestamp_step"`
+
+	Video *VideoSpecFile `yaml:"video"`
+	// Replay sends a captured RTP stream instead; packet_rate_hz and payload_bytes are then not used
+	Replay *ReplayFile `yaml:"replay"`
+}
+
+// ReplayFile selects the RTP stream of a pcap/pcapng file (relative to the scenario file)
+// by SSRC and/or payload type; clock_rate defaults to 90000
+type ReplayFile struct {
+	File        string `yaml:"file"`
+	SSRC        uint32 `yaml:"ssrc"`
+	PayloadType *uint8 `yaml:"payload_type"`
+	ClockRate   uint32 `yaml:"clock_rate"`
+}
+
+// VideoSpecFile switches the sender to frame-based video; packet_rate_hz and payload_bytes are then not used
+type VideoSpecFile struct {
+	FPS              int               `yaml:"fps"`
+	KeyframeInterval int               `yaml:"keyframe_interval"`
+	IFrame           FrameSizeDistFile `yaml:"i_frame"`
+	PFrame           FrameSizeDistFile `yaml:"p_frame"`
+	TargetBitrateBps float64           `yaml:"target_bitrate_bps"`
+	IFrameRatio      float64           `yaml:"i_frame_ratio"`
+	MTU              int               `yaml:"mtu"`
+}
+
+type FrameSizeDistFile struct {
+	MeanBytes int     `yaml:"mean_bytes"`
+	CV        float64 `yaml:"cv"`
+}
+
+type LinkSpecFile struct {
+	BaseOneWayDelay FileDuration  `yaml:"base_one_way_delay"`
+	Jitter          FileDuration  `yaml:"jitter"`
+	MaxQueueDelay   FileDuration  `yaml:"max_queue_delay"`
+	CapacityBps     *ScheduleFile `yaml:"capacity_bps"`
+	// Trace is a Mahimahi delivery trace, relative to the scenario file; replaces capacity_bps
+	Trace       string           `yaml:"trace"`
+	Queue       *QueueFile       `yaml:"queue"`
+	Impairments *ImpairmentsFile `yaml:"impairments"`
+	Loss        *LossFile        `yaml:"loss"`
+}
+
+// ImpairmentsFile adds reordering, duplication and delay spikes to a link
+type ImpairmentsFile struct {
+	ReorderProb    float64      `yaml:"reorder_prob"`
+	ReorderGap     int          `yaml:"reorder_gap"`
+	ReorderDelay   FileDuration `yaml:"reorder_delay"`
+	DuplicateProb  float64      `yaml:"duplicate_prob"`
+	DuplicateDelay FileDuration `yaml:"duplicate_delay"`
+	SpikeProb      float64      `yaml:"spike_prob"`
+	SpikeDuration  FileDuration `yaml:"spike_duration"`
+}
+
+func (im *ImpairmentsFile) spec() Impairments {
+	if im == nil {
+		return Impairments{}
+	}
+	return Impairments{
+		ReorderProb:    im.ReorderProb,
+		ReorderGap:     im.ReorderGap,
+		ReorderDelay:   time.Duration(im.ReorderDelay),
+		DuplicateProb:  im.DuplicateProb,
+		DuplicateDelay: time.Duration(im.DuplicateDelay),
+		SpikeProb:      im.SpikeProb,
+		SpikeDuration:  time.Duration(im.SpikeDuration),
+	}
+}
+
+func (im *ImpairmentsFile) validate(field string) []error {
+	if im == nil {
+		return nil
+	}
+	var errs []error
+	bad := func(sub, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, sub, fmt.Sprintf(format, args...)))
+	}
+	for _, p := range []struct {
+		sub string
+		p   float64
+	}{{"reorder_prob", im.ReorderProb}, {"duplicate_prob", im.DuplicateProb}, {"spike_prob", im.SpikeProb}} {
+		if p.p < 0 || p.p > 1 {
+			bad(p.sub, "must be in [0,1], got %g", p.p)
+		}
+	}
+	if im.ReorderGap < 0 {
+		bad("reorder_gap", "must be >= 0, got %d", im.ReorderGap)
+	}
+	if im.ReorderDelay < 0 || (im.ReorderProb > 0 && im.ReorderDelay == 0) {
+		bad("reorder_delay", "must be > 0 with reorder_prob, got %s", time.Duration(im.ReorderDelay))
+	}
+	if im.DuplicateDelay < 0 {
+		bad("duplicate_delay", "must be >= 0, got %s", time.Duration(im.DuplicateDelay))
+	}
+	if im.SpikeDuration < 0 || (im.SpikeProb > 0 && im.SpikeDuration == 0) {
+		bad("spike_duration", "must be > 0 with spike_prob, got %s", time.Duration(im.SpikeDuration))
+	}
+	return errs
+}
+
+// QueueFile selects the link's queue discipline; omitted fields use the RFC defaults
+type QueueFile struct {
+	Discipline     QueueDiscipline `yaml:"discipline"`
+	LimitBytes     int             `yaml:"limit_bytes"`
+	LimitPackets   int             `yaml:"limit_packets"`
+	Target         FileDuration    `yaml:"target"`
+	Interval       FileDuration    `yaml:"interval"`
+	UpdateInterval FileDuration    `yaml:"update_interval"`
+	Alpha          float64         `yaml:"alpha"`
+	Beta           float64         `yaml:"beta"`
+	Quantum        int             `yaml:"quantum"`
+}
+
+func (q *QueueFile) spec() QueueSpec {
+	if q == nil {
+		return QueueSpec{}
+	}
+	return QueueSpec{
+		Discipline:     q.Discipline,
+		LimitBytes:     q.LimitBytes,
+		LimitPackets:   q.LimitPackets,
+		Target:         time.Duration(q.Target),
+		Interval:       time.Duration(q.Interval),
+		UpdateInterval: time.Duration(q.UpdateInterval),
+		Alpha:          q.Alpha,
+		Beta:           q.Beta,
+		Quantum:        q.Quantum,
+	}
+}
+
+func (q *QueueFile) validate(field string) []error {
+	if q == nil {
+		return nil
+	}
+	var errs []error
+	bad := func(sub, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, sub, fmt.Sprintf(format, args...)))
+	}
+	switch q.Discipline {
+	case "", QueueDropTail, QueueCoDel, QueuePIE, QueueFQCoDel:
+	default:
+		bad("discipline", "unknown queue discipline %q (expected one of %q, %q, %q, %q)",
+			q.Discipline, QueueDropTail, QueueCoDel, QueuePIE, QueueFQCoDel)
+	}
+	for _, v := range []struct {
+		sub string
+		n   float64
+	}{
+		{"limit_bytes", float64(q.LimitBytes)},
+		{"limit_packets", float64(q.LimitPackets)},
+		{"target", float64(q.Target)},
+		{"interval", float64(q.Interval)},
+		{"update_interval", float64(q.UpdateInterval)},
+		{"alpha", q.Alpha},
+		{"beta", q.Beta},
+		{"quantum", float64(q.Quantum)},
+	} {
+		if v.n < 0 {
+			bad(v.sub, "must be >= 0")
+		}
+	}
+	return errs
+}
+
+// LossFile is a tagged loss model section; Model selects which parameters apply
+type LossFile struct {
+	Model string `yaml:"model"`
+	Name  string `yaml:"name"`
+
+	// bernoulli
+	P *ScheduleFile `yaml:"p"`
+
+	// gilbert_elliott
+	PGB float64 `yaml:"p_gb"`
+	PBG float64 `yaml:"p_bg"`
+	PG  float64 `yaml:"p_g"`
+	PB  float64 `yaml:"p_b"`
+
+	// trace: replay a recorded loss pattern (bits) or timestamped loss events, relative to the scenario file
+	Trace        string         `yaml:"trace"`
+	Format       string         `yaml:"format"`
+	Period       FileDuration   `yaml:"period"`
+	Scope        TraceLossScope `yaml:"scope"`
+	RandomOffset bool           `yaml:"random_offset"`
+}
+
+// ScheduleFile is a FloatSchedule; a plain number is accepted as a constant schedule
+type ScheduleFile struct {
+	Default float64             `yaml:"default"`
+	Points  []SchedulePointFile `yaml:"points"`
+}
+
+type SchedulePointFile struct {
+	At    FileDuration `yaml:"at"`
+	Value float64      `yaml:"value"`
+}
+
+func (s *ScheduleFile) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var v float64
+		if err := n.Decode(&v); err != nil {
+			return fmt.Errorf("line %d: schedule must be a number or a {default, points} mapping", n.Line)
+		}
+		*s = ScheduleFile{Default: v}
+		return nil
+	}
+	type plain ScheduleFile
+	return n.Decode((*plain)(s))
+}
+
+func (s *ScheduleFile) schedule() *FloatSchedule {
+	if s == nil {
+		return nil
+	}
+	points := make([]FloatPoint, 0, len(s.Points))
+	for _, p := range s.Points {
+		points = append(points, FloatPoint{At: time.Duration(p.At), Value: p.Value})
+	}
+	return NewFloatSchedule(s.Default, points...)
+}
+
+// FileDuration accepts Go duration strings ("200ms", "1m30s") or integer milliseconds
+type FileDuration time.Duration
+
+func (d *FileDuration) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: duration must be a string like \"200ms\" or a number of milliseconds", n.Line)
+	}
+	var ms int64
+	if err := n.Decode(&ms); err == nil {
+		*d = FileDuration(time.Duration(ms) * time.Millisecond)
+		return nil
+	}
+	v, err := time.ParseDuration(n.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q (use e.g. \"200ms\" or \"10s\")", n.Line, n.Value)
+	}
+	*d = FileDuration(v)
+	return nil
+}
+
+// LoadScenarioFile reads and validates a single YAML or JSON scenario file
+// seed is used for scenarios that don't pin their own seed
+func LoadScenarioFile(path string, seed int64) (Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Scenario{}, err
+	}
+
+	var f ScenarioFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return Scenario{}, fmt.Errorf("%s: %w", path, err)
+	}
+	f.dir = filepath.Dir(path)
+
+	sc, err := f.Scenario(seed)
+	if err != nil {
+		return Scenario{}, prefixErrors(path, err)
+	}
+	return sc, nil
+}
+
+// prefixErrors prepends prefix to every error of a joined error so each line names its file
+func prefixErrors(prefix string, err error) error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return fmt.Errorf("%s: %w", prefix, err)
+	}
+	errs := joined.Unwrap()
+	out := make([]error, 0, len(errs))
+	for _, e := range errs {
+		out = append(out, fmt.Errorf("%s: %w", prefix, e))
+	}
+	return errors.Join(out...)
+}
+
+// LoadScenarioDir loads all *.yaml, *.yml and *.json files of dir in lexical order
+// All files are validated; errors of every broken file are reported together
+func LoadScenarioDir(dir string, seed int64) ([]Scenario, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".json":
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s: no scenario files (*.yaml, *.yml, *.json) found", dir)
+	}
+
+	var (
+		out  []Scenario
+		errs []error
+		seen = make(map[string]string, len(paths))
+	)
+	for _, p := range paths {
+		sc, err := LoadScenarioFile(p, seed)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if prev, ok := seen[sc.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s: duplicate scenario name %q (also defined in %s)", p, sc.Name, prev))
+			continue
+		}
+		seen[sc.Name] = p
+		out = append(out, sc)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// Scenario validates the file and converts it into a runnable Scenario
+func (f ScenarioFile) Scenario(seed int64) (Scenario, error) {
+	if err := f.Validate(); err != nil {
+		return Scenario{}, err
+	}
+	if f.Seed != nil {
+		seed = *f.Seed
+	}
+
+	link, err := f.Link.linkSpec("link", f.dir, f.Name, seed)
+	if err != nil {
+		return Scenario{}, err
+	}
+	var path []HopSpec
+	for i, h := range f.Path {
+		name := hopName(h.Name, i)
+		spec, err := h.linkSpec(fmt.Sprintf("path[%d]", i), f.dir, f.Name+"_"+name, seed)
+		if err != nil {
+			return Scenario{}, err
+		}
+		path = append(path, HopSpec{Name: name, Link: spec})
+	}
+	var feedback *FeedbackSpec
+	if f.Feedback != nil {
+		rev, err := f.Feedback.Link.linkSpec("feedback.link", f.dir, f.Name+"_feedback", seed)
+		if err != nil {
+			return Scenario{}, err
+		}
+		feedback = &FeedbackSpec{
+			ReportInterval: time.Duration(f.Feedback.ReportInterval),
+			Link:           rev,
+		}
+	}
+
+	var video *VideoSpec
+	if v := f.Sender.Video; v != nil {
+		video = &VideoSpec{
+			FPS:              v.FPS,
+			KeyframeInterval: v.KeyframeInterval,
+			IFrame:           FrameSizeDist{MeanBytes: v.IFrame.MeanBytes, CV: v.IFrame.CV},
+			PFrame:           FrameSizeDist{MeanBytes: v.PFrame.MeanBytes, CV: v.PFrame.CV},
+			TargetBitrateBps: v.TargetBitrateBps,
+			IFrameRatio:      v.IFrameRatio,
+			MTU:              v.MTU,
+		}
+	}
+
+	var replay *RTPReplay
+	if r := f.Sender.Replay; r != nil {
+		path := r.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(f.dir, path)
+		}
+		replay, err = LoadRTPCapture(path, CaptureFilter{SSRC: r.SSRC, PayloadType: r.PayloadType}, r.ClockRate)
+		if err != nil {
+			return Scenario{}, fmt.Errorf("sender.replay.file: %w", err)
+		}
+	}
+
+	var rtx *RTXSpec
+	if f.RTX != nil {
+		rtx = &RTXSpec{
+			ReorderTolerance: time.Duration(f.RTX.ReorderTolerance),
+			RetryInterval:    time.Duration(f.RTX.RetryInterval),
+			MaxRetries:       f.RTX.MaxRetries,
+			HistorySize:      f.RTX.HistorySize,
+		}
+		if f.RTX.Link != nil {
+			rev, err := f.RTX.Link.linkSpec("rtx.link", f.dir, f.Name+"_nack", seed)
+			if err != nil {
+				return Scenario{}, err
+			}
+			rtx.Link = rev
+		}
+	}
+
+	var cross []CrossTrafficSpec
+	for _, c := range f.CrossTraffic {
+		cross = append(cross, c.spec())
+	}
+
+	return Scenario{
+		Name:     f.Name,
+		Duration: time.Duration(f.Duration),
+		IDs: RTPIDs{
+			MediaSSRC: f.IDs.MediaSSRC,
+			FECSSRC:   f.IDs.FECSSRC,
+			MediaPT:   f.IDs.MediaPT,
+			FECPT:     f.IDs.FECPT,
+
+			ReceiverSSRC: f.IDs.ReceiverSSRC,
+			RTXSSRC:      f.IDs.RTXSSRC,
+			RTXPT:        f.IDs.RTXPT,
+		},
+		Sender: SenderSpec{
+			PacketRateHz:  f.Sender.PacketRateHz,
+			PayloadBytes:  f.Sender.PayloadBytes,
+			StartSeq:      f.Sender.StartSeq,
+			StartTS:       f.Sender.StartTS,
+			TimestampStep: f.Sender.TimestampStep,
+			StartTime:     time.Unix(0, 0),
+			Video:         video,
+			Replay:        replay,
+		},
+		K:               f.K,
+		StaticR:         f.StaticR,
+		StatsInterval:   time.Duration(f.StatsInterval),
+		BWE:             f.BWE.schedule(),
+		RTTMs:           f.RTTMs,
+		JitterMs:        f.JitterMs,
+		ReturnDelay:     time.Duration(f.ReturnDelay),
+		PlayoutDeadline: time.Duration(f.PlayoutDeadline),
+		Link:            link,
+		Path:            path,
+		Feedback:        feedback,
+		RTX:             rtx,
+		CrossTraffic:    cross,
+		Seed:            seed,
+	}, nil
+}
+
+func (l LinkSpecFile) linkSpec(field, dir, lossName string, seed int64) (LinkSpec, error) {
+	loss, err := l.Loss.model(field+".loss", dir, lossName, seed)
+	if err != nil {
+		return LinkSpec{}, err
+	}
+	var trace *LinkTrace
+	if l.Trace != "" {
+		path := l.Trace
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if trace, err = LoadMahimahiTrace(path); err != nil {
+			return LinkSpec{}, fmt.Errorf("%s.trace: %w", field, err)
+		}
+	}
+	return LinkSpec{
+		BaseOneWayDelay: time.Duration(l.BaseOneWayDelay),
+		Jitter:          time.Duration(l.Jitter),
+		MaxQueueDelay:   time.Duration(l.MaxQueueDelay),
+		CapacityBps:     l.CapacityBps.schedule(),
+		Trace:           trace,
+		Queue:           l.Queue.spec(),
+		Impairments:     l.Impairments.spec(),
+		Loss:            loss,
+		Seed:            seed,
+	}, nil
+}
+
+// Validate reports all problems of the file at once, one line per field
+func (f ScenarioFile) Validate() error {
+	var errs []error
+	bad := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if strings.TrimSpace(f.Name) == "" {
+		bad("name", "must not be empty")
+	}
+	if f.Duration <= 0 {
+		bad("duration", "must be > 0, got %s", time.Duration(f.Duration))
+	}
+
+	if f.IDs.MediaSSRC == 0 {
+		bad("ids.media_ssrc", "must not be 0")
+	}
+	if f.IDs.FECSSRC == 0 {
+		bad("ids.fec_ssrc", "must not be 0")
+	}
+	if f.IDs.MediaSSRC != 0 && f.IDs.MediaSSRC == f.IDs.FECSSRC {
+		bad("ids.fec_ssrc", "must differ from ids.media_ssrc (%d)", f.IDs.MediaSSRC)
+	}
+	if f.IDs.MediaPT > 127 {
+		bad("ids.media_pt", "must be <= 127, got %d", f.IDs.MediaPT)
+	}
+	if f.IDs.FECPT == 0 || f.IDs.FECPT > 127 {
+		bad("ids.fec_pt", "must be in 1..127, got %d", f.IDs.FECPT)
+	}
+	if f.IDs.FECPT == f.IDs.MediaPT {
+		bad("ids.fec_pt", "must differ from ids.media_pt (%d)", f.IDs.MediaPT)
+	}
+
+	switch r := f.Sender.Replay; {
+	case r != nil:
+		if r.File == "" {
+			bad("sender.replay.file", "must not be empty")
+		}
+		if r.PayloadType != nil && *r.PayloadType > 127 {
+			bad("sender.replay.payload_type", "must be <= 127, got %d", *r.PayloadType)
+		}
+		if f.Sender.Video != nil {
+			bad("sender.replay", "must not be combined with sender.video")
+		}
+	case f.Sender.Video != nil:
+		errs = append(errs, f.Sender.Video.validate("sender.video")...)
+	default:
+		if f.Sender.PacketRateHz <= 0 {
+			bad("sender.packet_rate_hz", "must be > 0, got %d", f.Sender.PacketRateHz)
+		}
+		if f.Sender.PayloadBytes <= 0 {
+			bad("sender.payload_bytes", "must be > 0, got %d", f.Sender.PayloadBytes)
+		}
+	}
+
+	if f.K == 0 {
+		bad("k", "must be > 0")
+	}
+
+	if f.StatsInterval < 0 {
+		bad("stats_interval", "must be >= 0, got %s", time.Duration(f.StatsInterval))
+	}
+	if f.PlayoutDeadline < 0 {
+		bad("playout_deadline", "must be >= 0, got %s", time.Duration(f.PlayoutDeadline))
+	}
+	if f.RTTMs < 0 {
+		bad("rtt_ms", "must be >= 0, got %d", f.RTTMs)
+	}
+	if f.JitterMs < 0 {
+		bad("jitter_ms", "must be >= 0, got %d", f.JitterMs)
+	}
+	if f.ReturnDelay < 0 {
+		bad("return_delay", "must be >= 0, got %s", time.Duration(f.ReturnDelay))
+	}
+	errs = append(errs, f.BWE.validate("bwe", 0, -1)...)
+
+	if len(f.Path) > 0 {
+		if f.Link != (LinkSpecFile{}) {
+			bad("link", "must be empty when path is set")
+		}
+		names := make(map[string]bool, len(f.Path))
+		for i, h := range f.Path {
+			field := fmt.Sprintf("path[%d]", i)
+			errs = append(errs, h.validate(field)...)
+			name := hopName(h.Name, i)
+			if names[name] {
+				bad(field+".name", "duplicate hop name %q", name)
+			}
+			names[name] = true
+		}
+	} else {
+		errs = append(errs, f.Link.validate("link")...)
+	}
+
+	if f.Feedback != nil {
+		if f.Feedback.ReportInterval < 0 {
+			bad("feedback.report_interval", "must be >= 0, got %s", time.Duration(f.Feedback.ReportInterval))
+		}
+		errs = append(errs, f.Feedback.Link.validate("feedback.link")...)
+	}
+
+	if f.RTX != nil {
+		if f.IDs.RTXSSRC == 0 || f.IDs.RTXSSRC == f.IDs.MediaSSRC || f.IDs.RTXSSRC == f.IDs.FECSSRC {
+			bad("ids.rtx_ssrc", "must be set and differ from media and fec ssrc when rtx is enabled, got %d", f.IDs.RTXSSRC)
+		}
+		if f.IDs.RTXPT == 0 || f.IDs.RTXPT > 127 || f.IDs.RTXPT == f.IDs.MediaPT || f.IDs.RTXPT == f.IDs.FECPT {
+			bad("ids.rtx_pt", "must be in 1..127 and differ from media and fec pt when rtx is enabled, got %d", f.IDs.RTXPT)
+		}
+		if f.RTX.ReorderTolerance < 0 {
+			bad("rtx.reorder_tolerance", "must be >= 0, got %s", time.Duration(f.RTX.ReorderTolerance))
+		}
+		if f.RTX.RetryInterval < 0 {
+			bad("rtx.retry_interval", "must be >= 0, got %s", time.Duration(f.RTX.RetryInterval))
+		}
+		if f.RTX.MaxRetries < 0 {
+			bad("rtx.max_retries", "must be >= 0, got %d", f.RTX.MaxRetries)
+		}
+		if f.RTX.HistorySize < 0 {
+			bad("rtx.history_size", "must be >= 0, got %d", f.RTX.HistorySize)
+		}
+		if f.RTX.Link != nil {
+			errs = append(errs, f.RTX.Link.validate("rtx.link")...)
+		}
+	}
+
+	names := make(map[string]bool, len(f.CrossTraffic))
+	ssrcs := make(map[uint32]bool, len(f.CrossTraffic))
+	for i, c := range f.CrossTraffic {
+		field := fmt.Sprintf("cross_traffic[%d]", i)
+		errs = append(errs, c.validate(field, f.IDs)...)
+		if hops := max(len(f.Path), 1); c.Hop >= hops {
+			bad(field+".hop", "must be < %d (number of path hops), got %d", hops, c.Hop)
+		}
+		name := c.Name
+		if name == "" {
+			name = crossFlowName(c.Kind, i)
+		}
+		if names[name] {
+			bad(field+".name", "duplicate flow name %q", name)
+		}
+		names[name] = true
+		if c.SSRC != 0 {
+			if ssrcs[c.SSRC] {
+				bad(field+".ssrc", "duplicate flow ssrc %d", c.SSRC)
+			}
+			ssrcs[c.SSRC] = true
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// hopName is the name of the i-th path hop (hop<i> if unnamed)
+func hopName(name string, i int) string {
+	if name == "" {
+		return fmt.Sprintf("hop%d", i)
+	}
+	return name
+}
+
+func (v VideoSpecFile) validate(field string) []error {
+	var errs []error
+	bad := func(sub, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, sub, fmt.Sprintf(format, args...)))
+	}
+	if v.FPS <= 0 || v.FPS > videoClockRate {
+		bad("fps", "must be in 1..%d, got %d", videoClockRate, v.FPS)
+	}
+	if v.KeyframeInterval < 0 {
+		bad("keyframe_interval", "must be >= 0, got %d", v.KeyframeInterval)
+	}
+	if v.MTU < 0 {
+		bad("mtu", "must be >= 0, got %d", v.MTU)
+	}
+	if v.TargetBitrateBps < 0 {
+		bad("target_bitrate_bps", "must be >= 0, got %g", v.TargetBitrateBps)
+	}
+	if v.IFrameRatio < 0 {
+		bad("i_frame_ratio", "must be >= 0, got %g", v.IFrameRatio)
+	}
+	for _, fd := range []struct {
+		sub string
+		d   FrameSizeDistFile
+	}{{"i_frame", v.IFrame}, {"p_frame", v.PFrame}} {
+		sub, d := fd.sub, fd.d
+		if v.TargetBitrateBps == 0 && d.MeanBytes <= 0 {
+			bad(sub+".mean_bytes", "must be > 0 without target_bitrate_bps, got %d", d.MeanBytes)
+		}
+		if d.CV < 0 {
+			bad(sub+".cv", "must be >= 0, got %g", d.CV)
+		}
+	}
+	return errs
+}
+
+func (l LinkSpecFile) validate(field string) []error {
+	var errs []error
+	nonNeg := func(sub string, d FileDuration) {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s.%s: must be >= 0, got %s", field, sub, time.Duration(d)))
+		}
+	}
+	nonNeg("base_one_way_delay", l.BaseOneWayDelay)
+	nonNeg("jitter", l.Jitter)
+	nonNeg("max_queue_delay", l.MaxQueueDelay)
+	errs = append(errs, l.CapacityBps.validate(field+".capacity_bps", 0, -1)...)
+	if l.Trace != "" && l.CapacityBps != nil {
+		errs = append(errs, fmt.Errorf("%s.trace: must not be combined with %s.capacity_bps", field, field))
+	}
+	errs = append(errs, l.Queue.validate(field+".queue")...)
+	errs = append(errs, l.Impairments.validate(field+".impairments")...)
+	errs = append(errs, l.Loss.validate(field+".loss")...)
+	return errs
+}
+
+func (l *LossFile) validate(field string) []error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	bad := func(sub, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, sub, fmt.Sprintf(format, args...)))
+	}
+	prob := func(sub string, v float64) {
+		if v < 0 || v > 1 {
+			bad(sub, "must be a probability in [0,1], got %g", v)
+		}
+	}
+
+	switch l.Model {
+	case "", LossModelNone:
+	case LossModelBernoulli:
+		if l.P == nil {
+			bad("p", "required for model %q", l.Model)
+		}
+		errs = append(errs, l.P.validate(field+".p", 0, 1)...)
+	case LossModelGilbertElliott:
+		prob("p_gb", l.PGB)
+		prob("p_bg", l.PBG)
+		prob("p_g", l.PG)
+		prob("p_b", l.PB)
+	case LossModelTrace:
+		if l.Trace == "" {
+			bad("trace", "required for model %q", l.Model)
+		}
+		switch l.Format {
+		case LossTraceBits:
+			if l.Period != 0 {
+				bad("period", "only applies to format %q", LossTraceEvents)
+			}
+		case LossTraceEvents:
+			if l.Period < 0 {
+				bad("period", "must be >= 0, got %s", time.Duration(l.Period))
+			}
+		default:
+			bad("format", "must be %q or %q, got %q", LossTraceBits, LossTraceEvents, l.Format)
+		}
+		switch l.Scope {
+		case "", TraceLossShared, TraceLossSeparate, TraceLossMediaOnly:
+		default:
+			bad("scope", "must be one of %q, %q, %q, got %q", TraceLossShared, TraceLossSeparate, TraceLossMediaOnly, l.Scope)
+		}
+	default:
+		bad("model", "unknown loss model %q (expected one of %q, %q, %q, %q)",
+			l.Model, LossModelNone, LossModelBernoulli, LossModelGilbertElliott, LossModelTrace)
+	}
+	return errs
+}
+
+func (l *LossFile) model(field, dir, scenario string, seed int64) (LossModel, error) {
+	if l == nil {
+		return nil, nil
+	}
+	name := l.Name
+	if name == "" {
+		name = scenario
+	}
+	switch l.Model {
+	case "", LossModelNone:
+		return nil, nil
+	case LossModelBernoulli:
+		return NewScheduledBernoulliLoss(name, seed, l.P.schedule()), nil
+	case LossModelGilbertElliott:
+		return NewGilbertElliottLoss(name, seed, l.PGB, l.PBG, l.PG, l.PB), nil
+	case LossModelTrace:
+		path := l.Trace
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if l.Format == LossTraceEvents {
+			events, err := LoadLossEvents(path)
+			if err != nil {
+				return nil, fmt.Errorf("%s.trace: %w", field, err)
+			}
+			if l.Period > 0 {
+				last := events[len(events)-1]
+				if end := last.Start + last.Duration; time.Duration(l.Period) < end {
+					return nil, fmt.Errorf("%s.period: must cover the last event (ends at %s), got %s", field, end, time.Duration(l.Period))
+				}
+			}
+			return NewTimedTraceLoss(name, seed, events, time.Duration(l.Period), l.Scope, l.RandomOffset), nil
+		}
+		pattern, err := LoadLossPattern(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s.trace: %w", field, err)
+		}
+		return NewPacketTraceLoss(name, seed, pattern, l.Scope, l.RandomOffset), nil
+	default:
+		return nil, fmt.Errorf("%s.model: unknown loss model %q", field, l.Model)
+	}
+}
+
+// validate checks schedule values against [lo,hi]; hi < lo disables the upper bound
+func (s *ScheduleFile) validate(field string, lo, hi float64) []error {
+	if s == nil {
+		return nil
+	}
+	var errs []error
+	check := func(sub string, v float64) {
+		if v < lo || (hi >= lo && v > hi) {
+			rng := fmt.Sprintf(">= %g", lo)
+			if hi >= lo {
+				rng = fmt.Sprintf("in [%g,%g]", lo, hi)
+			}
+			errs = append(errs, fmt.Errorf("%s.%s: must be %s, got %g", field, sub, rng, v))
+		}
+	}
+	check("default", s.Default)
+	for i, p := range s.Points {
+		if p.At < 0 {
+			errs = append(errs, fmt.Errorf("%s.points[%d].at: must be >= 0, got %s", field, i, time.Duration(p.At)))
+		}
+		check(fmt.Sprintf("points[%d].value", i), p.Value)
+	}
+	return errs
+}
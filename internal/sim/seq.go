@@ -0,0 +1,79 @@
+package sim
+
+// seqUnwrapper extends 16-bit RTP sequence numbers to 64 bits (RFC 3550 A.1 style
+// rollover counting): every number is placed in the cycle closest to the highest one seen
+type seqUnwrapper struct {
+	started bool
+	max     uint64
+}
+
+// start makes seq the first extended number (otherwise the first Unwrap is)
+func (u *seqUnwrapper) start(seq uint16) {
+	u.started = true
+	u.max = uint64(seq)
+}
+
+func (u *seqUnwrapper) Unwrap(seq uint16) uint64 {
+	if !u.started {
+		u.start(seq)
+		return u.max
+	}
+	ext := u.extend(seq)
+	if ext > u.max {
+		u.max = ext
+	}
+	return ext
+}
+
+// extend places seq like Unwrap without recording it (e.g. for sequence numbers from
+// feedback, which must not move the highest number seen)
+func (u *seqUnwrapper) extend(seq uint16) uint64 {
+	if !u.started {
+		return uint64(seq)
+	}
+	ext := int64(u.max) + int64(int16(seq-uint16(u.max)))
+	if ext < 0 {
+		// older than the first packet: keep it in the first cycle
+		ext = int64(seq)
+	}
+	return uint64(ext)
+}
+
+// seqWindowSize is how far behind the highest extended sequence number a seqWindow remembers
+const seqWindowSize = 1 << 15
+
+// seqWindow is a bitmap of the extended sequence numbers seen within seqWindowSize of the
+// highest one; memory stays fixed however long the run and however many packets are lost
+type seqWindow struct {
+	started bool
+	max     uint64
+	bits    [seqWindowSize / 64]uint64
+}
+
+// Seen records ext and reports whether it was recorded before; numbers that fell out of
+// the window count as unseen
+func (w *seqWindow) Seen(ext uint64) bool {
+	switch {
+	case !w.started:
+		w.started = true
+		w.max = ext
+	case ext > w.max:
+		if ext-w.max >= seqWindowSize {
+			w.bits = [seqWindowSize / 64]uint64{}
+		} else {
+			// the slots up to the new highest still hold packets a window older
+			for s := w.max + 1; s <= ext; s++ {
+				w.bits[s/64%uint64(len(w.bits))] &^= 1 << (s % 64)
+			}
+		}
+		w.max = ext
+	case w.max-ext >= seqWindowSize:
+		return false
+	}
+	word, bit := &w.bits[ext/64%uint64(len(w.bits))], uint64(1)<<(ext%64)
+	if *word&bit != 0 {
+		return true
+	}
+	*word |= bit
+	return false
+}
@@ -0,0 +1,110 @@
+package sim
+
+import (
+	"testing"
+	"time"
+)
+
+// The first FEC block spans the wrap (65530..65535, 0..3); its lost packet 1 must be restored
+// and counted once under its extended number
+func TestRunWrapMidFECBlock(t *testing.T) {
+	const startSeq = 65530
+	pattern := make([]bool, 400)
+	pattern[7] = true // seq 1, extended 65537
+
+	sc := Scenario{
+		Name:     "wrap_mid_fec_block",
+		Duration: 2 * time.Second,
+		IDs:      testIDs,
+		Sender: SenderSpec{
+			PacketRateHz:  100,
+			PayloadBytes:  200,
+			StartSeq:      startSeq,
+			StartTS:       1,
+			TimestampStep: 900,
+		},
+		K:               10,
+		StaticR:         2,
+		StatsInterval:   200 * time.Millisecond,
+		BWE:             &FloatSchedule{Default: 2e6},
+		RTTMs:           40,
+		JitterMs:        5,
+		PlayoutDeadline: 200 * time.Millisecond,
+		Link: LinkSpec{
+			BaseOneWayDelay: 20 * time.Millisecond,
+			CapacityBps:     &FloatSchedule{Default: 5e6},
+			Loss:            NewPacketTraceLoss("one", 0, pattern, TraceLossMediaOnly, false),
+		},
+	}
+	res, err := RunScenario(sc, RunOptions{Mode: ModeStatic, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.SentMediaPkts < 100 {
+		t.Fatalf("sent %d media packets, the run should outlast the wrap", res.SentMediaPkts)
+	}
+	if res.DroppedMediaPkts != 1 {
+		t.Errorf("dropped media = %d, want 1", res.DroppedMediaPkts)
+	}
+	if res.RecoveredPkts != 1 {
+		t.Errorf("recovered = %d, want 1", res.RecoveredPkts)
+	}
+	if res.UniquePkts != res.SentMediaPkts {
+		t.Errorf("unique = %d, want %d", res.UniquePkts, res.SentMediaPkts)
+	}
+	if res.GoodWithinDeadline != res.SentMediaPkts {
+		t.Errorf("good within deadline = %d, want %d", res.GoodWithinDeadline, res.SentMediaPkts)
+	}
+	if res.DuplicatePkts != 0 {
+		t.Errorf("duplicates = %d, want 0", res.DuplicatePkts)
+	}
+}
+
+// Per-packet draws use the extended sequence number: the second cycle of 16-bit sequence
+// numbers must not repeat the loss pattern of the first
+func TestLinkDrawsAcrossWrap(t *testing.T) {
+	start := time.Unix(0, 0)
+	l := NewLink(LinkSpec{
+		Jitter: 5 * time.Millisecond,
+		Loss:   NewScheduledBernoulliLoss("", 1, &FloatSchedule{Default: 0.1}),
+	}, start)
+
+	var first [1 << 16]bool
+	same := 0
+	for i := 0; i < 2<<16; i++ {
+		out := l.Send(testMedia(uint16(i)), start.Add(time.Duration(i)*time.Millisecond), false)
+		if i < 1<<16 {
+			first[i] = out.Dropped
+		} else if first[i-1<<16] == out.Dropped {
+			same++
+		}
+		for {
+			if _, ok := l.Next(); !ok {
+				break
+			}
+		}
+	}
+	if same == 1<<16 {
+		t.Fatal("loss pattern repeats after the sequence number wraps")
+	}
+}
+
+func TestSeqUnwrapper(t *testing.T) {
+	var u seqUnwrapper
+	u.start(65534)
+	for _, c := range []struct {
+		seq  uint16
+		want uint64
+	}{
+		{65535, 65535},
+		{1, 65537},
+		{65533, 65533}, // late packet from before the wrap
+		{0, 65536},
+		{2, 65538},
+	} {
+		if got := u.Unwrap(c.seq); got != c.want {
+			t.Errorf("Unwrap(%d) = %d, want %d", c.seq, got, c.want)
+		}
+	}
+}
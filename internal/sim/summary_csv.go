@@ -35,10 +35,34 @@ type SummaryRow struct {
 	DroppedFEC    int64
 	QueueDrops    int64
 	WireDrops     int64
+	AQMDrops      int64
 
 	RecoveredPkts      int64
 	UniquePkts         int64
 	GoodWithinDeadline int64
+
+	DuplicatePkts   int64
+	ReorderedPkts   int64
+	MaxReorderDepth int64
+
+	NACKedPkts         int64
+	RetransmittedPkts  int64
+	RetransmittedBytes int64
+	RecoveredRTXPkts   int64
+
+	DecodableFrameRatio float64
+	FreezeCount         int64
+	TotalFreezeMs       float64
+	MaxFreezeMs         float64
+
+	CrossThroughputBps float64
+
+	// Latency percentiles of all, directly received and FEC-restored media packets and the
+	// recovery delay of restored packets (see RunResult)
+	Latency       Percentiles
+	DirectLatency Percentiles
+	FECLatency    Percentiles
+	RecoveryDelay Percentiles
 }
 
 type SummaryCSVWriter struct {
@@ -77,9 +101,25 @@ func NewSummaryCSVWriter(path string) (*SummaryCSVWriter, error) {
 		"dropped_fec_pkts",
 		"queue_drops_pkts",
 		"wire_drops_pkts",
+		"aqm_drops_pkts",
 		"recovered_pkts",
 		"unique_pkts",
 		"good_within_deadline",
+		"nacked_pkts",
+		"retransmitted_pkts",
+		"retransmitted_bytes",
+		"recovered_rtx_pkts",
+		"decodable_frame_ratio",
+		"freeze_count",
+		"total_freeze_ms",
+		"max_freeze_ms",
+		"cross_throughput_bps",
+		"duplicate_pkts",
+		"reordered_pkts",
+		"max_reorder_depth",
+	}
+	for _, p := range []string{"latency", "direct_latency", "fec_latency", "recovery_delay"} {
+		hdr = append(hdr, p+"_p50_ms", p+"_p95_ms", p+"_p99_ms", p+"_max_ms")
 	}
 	if err := w.Write(hdr); err != nil {
 		_ = f.Close()
@@ -117,9 +157,25 @@ func (s *SummaryCSVWriter) WriteRow(r SummaryRow) error {
 		strconv.FormatInt(r.DroppedFEC, 10),
 		strconv.FormatInt(r.QueueDrops, 10),
 		strconv.FormatInt(r.WireDrops, 10),
+		strconv.FormatInt(r.AQMDrops, 10),
 		strconv.FormatInt(r.RecoveredPkts, 10),
 		strconv.FormatInt(r.UniquePkts, 10),
 		strconv.FormatInt(r.GoodWithinDeadline, 10),
+		strconv.FormatInt(r.NACKedPkts, 10),
+		strconv.FormatInt(r.RetransmittedPkts, 10),
+		strconv.FormatInt(r.RetransmittedBytes, 10),
+		strconv.FormatInt(r.RecoveredRTXPkts, 10),
+		ff(r.DecodableFrameRatio),
+		strconv.FormatInt(r.FreezeCount, 10),
+		ff(r.TotalFreezeMs),
+		ff(r.MaxFreezeMs),
+		ff(r.CrossThroughputBps),
+		strconv.FormatInt(r.DuplicatePkts, 10),
+		strconv.FormatInt(r.ReorderedPkts, 10),
+		strconv.FormatInt(r.MaxReorderDepth, 10),
+	}
+	for _, p := range []Percentiles{r.Latency, r.DirectLatency, r.FECLatency, r.RecoveryDelay} {
+		row = append(row, ff(p.P50), ff(p.P95), ff(p.P99), ff(p.Max))
 	}
 	return s.w.Write(row)
 }
@@ -0,0 +1,138 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MahimahiMTU is the number of bytes one delivery opportunity of a Mahimahi trace can carry
+const MahimahiMTU = 1500
+
+// LinkTrace is a Mahimahi-style packet delivery trace: each entry is one opportunity to
+// deliver up to MahimahiMTU bytes, at an offset from the start of the trace
+// The trace repeats with Period when the run is longer than the trace
+type LinkTrace struct {
+	Name          string
+	Opportunities []time.Duration
+	Period        time.Duration
+}
+
+// LoadMahimahiTrace reads a Mahimahi trace file (one millisecond timestamp per line)
+func LoadMahimahiTrace(path string) (*LinkTrace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	tr, err := ParseMahimahiTrace(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	tr.Name = path
+	return tr, nil
+}
+
+// ParseMahimahiTrace parses non-decreasing millisecond timestamps, one per line
+// Like Mahimahi, the trace loops after its last timestamp, which must be > 0
+func ParseMahimahiTrace(r io.Reader) (*LinkTrace, error) {
+	tr := &LinkTrace{}
+	sc := bufio.NewScanner(r)
+	line := 0
+	var last int64
+	for sc.Scan() {
+		line++
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp %q", line, s)
+		}
+		if ms < last {
+			return nil, fmt.Errorf("line %d: timestamps must not decrease (%d after %d)", line, ms, last)
+		}
+		last = ms
+		tr.Opportunities = append(tr.Opportunities, time.Duration(ms)*time.Millisecond)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(tr.Opportunities) == 0 {
+		return nil, fmt.Errorf("trace has no delivery opportunities")
+	}
+	if last == 0 {
+		return nil, fmt.Errorf("last timestamp must be > 0 (it defines the loop period)")
+	}
+	tr.Period = time.Duration(last) * time.Millisecond
+	return tr, nil
+}
+
+// traceCursor points at the next opportunity with capacity left; unused bytes of
+// opportunities that pass while the queue is empty are lost, as in Mahimahi
+type traceCursor struct {
+	idx  int64
+	left int
+}
+
+func newTraceCursor() traceCursor { return traceCursor{left: MahimahiMTU} }
+
+// at returns the offset of the idx-th opportunity from the link start
+func (t *LinkTrace) at(idx int64) time.Duration {
+	n := int64(len(t.Opportunities))
+	return time.Duration(idx/n)*t.Period + t.Opportunities[idx%n]
+}
+
+// seek returns the index of the first opportunity at or after off
+func (t *LinkTrace) seek(off time.Duration) int64 {
+	if off < 0 {
+		off = 0
+	}
+	n := int64(len(t.Opportunities))
+	loop := int64(off / t.Period)
+	within := off - time.Duration(loop)*t.Period
+	i := sort.Search(len(t.Opportunities), func(i int) bool { return t.Opportunities[i] >= within })
+	return loop*n + int64(i)
+}
+
+// serve schedules size bytes enqueued at off and returns the offsets of the first and
+// the last opportunity used; a packet may span several opportunities
+func (t *LinkTrace) serve(c *traceCursor, off time.Duration, size int) (first, last time.Duration) {
+	if t.at(c.idx) < off {
+		c.idx = t.seek(off)
+		c.left = MahimahiMTU
+	}
+	first = t.at(c.idx)
+	for {
+		take := min(c.left, size)
+		c.left -= take
+		size -= take
+		if size == 0 {
+			break
+		}
+		c.idx++
+		c.left = MahimahiMTU
+	}
+	last = t.at(c.idx)
+	if c.left == 0 {
+		c.idx++
+		c.left = MahimahiMTU
+	}
+	return first, last
+}
+
+// RateBps is the average capacity offered by the trace in [from, to)
+func (t *LinkTrace) RateBps(from, to time.Duration) float64 {
+	if to <= from {
+		return 0
+	}
+	n := t.seek(to) - t.seek(from)
+	return float64(n) * MahimahiMTU * 8 / (to - from).Seconds()
+}
@@ -1,19 +1,61 @@
 package sim
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type Mode string
 
 const (
 	ModeStatic   Mode = "static_flexfec"
 	ModeAdaptive Mode = "adaptive_engine"
+
+	// Baselines for judging the engine; their decisions take the engine's path to the encoder
+
+	// ModeOracle knows the true loss of every stats window and picks the smallest R that
+	// meets RunOptions.OracleTarget
+	ModeOracle Mode = "oracle"
+	// ModeLossProportional protects ProtectionScale x the reported loss, without hysteresis
+	ModeLossProportional Mode = "loss_proportional"
+
+	// ModeExternal labels runs driven by RunOptions.Policy, e.g. NewProcessPolicy
+	ModeExternal Mode = "external"
 )
 
+// Modes lists the modes RunScenario accepts
+var Modes = []Mode{ModeStatic, ModeAdaptive, ModeOracle, ModeLossProportional, ModeExternal}
+
+// ParseMode accepts a Mode or its short form (static, adaptive, proportional)
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeStatic, "static":
+		return ModeStatic, nil
+	case ModeAdaptive, "adaptive":
+		return ModeAdaptive, nil
+	case ModeOracle:
+		return ModeOracle, nil
+	case ModeLossProportional, "proportional":
+		return ModeLossProportional, nil
+	case ModeExternal:
+		return ModeExternal, nil
+	default:
+		return "", fmt.Errorf("unknown mode %q (expected one of %v)", s, Modes)
+	}
+}
+
 type RTPIDs struct {
 	MediaSSRC uint32
 	FECSSRC   uint32
 	MediaPT   uint8
 	FECPT     uint8
+
+	// ReceiverSSRC is the sender SSRC of the receiver's RTCP feedback (0 defaults to 1)
+	ReceiverSSRC uint32
+
+	// RTX stream (RFC 4588) used for retransmissions when Scenario.RTX is set
+	RTXSSRC uint32
+	RTXPT   uint8
 }
 
 type SenderSpec struct {
@@ -23,16 +65,75 @@ type SenderSpec struct {
 	StartTS       uint32
 	TimestampStep uint32
 	StartTime     time.Time
+
+	// Video switches the sender from constant-size packets to frames (PacketRateHz,
+	// PayloadBytes and TimestampStep are then ignored)
+	Video *VideoSpec
+	// Replay sends a captured RTP stream instead (PacketRateHz, PayloadBytes, TimestampStep
+	// and Video are then ignored)
+	Replay *RTPReplay
+}
+
+// VideoSpec describes a frame-based video source; every frame is packetized into
+// MTU-sized RTP packets sharing one timestamp and sent as a burst
+type VideoSpec struct {
+	FPS int
+	// KeyframeInterval is the number of frames per GOP (0 = only the first frame is a keyframe)
+	KeyframeInterval int
+
+	IFrame FrameSizeDist
+	PFrame FrameSizeDist
+
+	// TargetBitrateBps (payload, > 0) overrides both means: the GOP averages the bitrate
+	// with keyframes IFrameRatio times the size of P-frames (default 5)
+	TargetBitrateBps float64
+	IFrameRatio      float64
+
+	// MTU is the maximum RTP payload per packet (default 1200)
+	MTU int
+}
+
+// FrameSizeDist is a normal frame size distribution; CV is the standard deviation relative to the mean
+type FrameSizeDist struct {
+	MeanBytes int
+	CV        float64
 }
 
 func (s SenderSpec) Interval() time.Duration {
+	if s.Replay != nil {
+		return s.Replay.meanGap()
+	}
+	if s.Video != nil {
+		return s.Video.interval()
+	}
 	if s.PacketRateHz <= 0 {
 		return 0
 	}
 	return time.Second / time.Duration(s.PacketRateHz)
 }
 
+// ClockRate is the RTP clock implied by TimestampStep and PacketRateHz (90 kHz if unset)
+// or the replayed stream's clock
+func (s SenderSpec) ClockRate() uint32 {
+	if s.Replay != nil {
+		return s.Replay.ClockRate
+	}
+	if s.Video != nil {
+		return videoClockRate
+	}
+	if s.PacketRateHz <= 0 || s.TimestampStep == 0 {
+		return 90000
+	}
+	return s.TimestampStep * uint32(s.PacketRateHz)
+}
+
 func (s SenderSpec) MediaBitrateBps(includeRTPHeader bool) float64 {
+	if s.Replay != nil {
+		return s.Replay.bitrateBps(includeRTPHeader)
+	}
+	if s.Video != nil {
+		return s.Video.expectedBitrateBps(includeRTPHeader)
+	}
 	if s.PacketRateHz <= 0 || s.PayloadBytes <= 0 {
 		return 0
 	}
@@ -48,8 +149,34 @@ type LinkSpec struct {
 	Jitter          time.Duration
 	MaxQueueDelay   time.Duration
 	CapacityBps     *FloatSchedule
-	Loss            LossModel
-	Seed            int64
+	// Trace replaces CapacityBps with Mahimahi-style delivery opportunities (nil disables)
+	Trace *LinkTrace
+	// Queue selects the queue discipline (zero value: drop-tail bounded by MaxQueueDelay)
+	Queue QueueSpec
+	// Impairments reorder, duplicate and stall packets after the queue (zero value disables)
+	Impairments Impairments
+	Loss        LossModel
+	Seed        int64
+}
+
+// Impairments are netem-style distortions of the packets leaving a link; draws are
+// deterministic per (seed, ssrc, seq) like the jitter
+type Impairments struct {
+	// ReorderProb holds a packet back by ReorderDelay so that packets sent after it overtake it;
+	// with ReorderGap > 1 only every ReorderGap-th packet of each SSRC is a candidate
+	ReorderProb  float64
+	ReorderGap   int
+	ReorderDelay time.Duration
+
+	// DuplicateProb delivers an RTP packet twice, the copy DuplicateDelay after the original
+	DuplicateProb  float64
+	DuplicateDelay time.Duration
+
+	// SpikeProb starts a stall at a packet (e.g. Wi-Fi retransmissions): it and every packet
+	// arriving during the stall are released in order when it ends; stalls last an
+	// exponentially distributed time with mean SpikeDuration
+	SpikeProb     float64
+	SpikeDuration time.Duration
 }
 
 type Scenario struct {
@@ -62,17 +189,77 @@ type Scenario struct {
 	K       uint32
 	StaticR uint32
 
-	StatsInterval   time.Duration
-	BWE             *FloatSchedule
-	RTTMs           int
-	JitterMs        int
+	StatsInterval time.Duration
+	BWE           *FloatSchedule
+	// RTTMs and JitterMs are reported to the engine until the first packets have been
+	// measured on the link; afterwards the per-window measurements replace them
+	RTTMs    int
+	JitterMs int
+	// ReturnDelay is the one-way delay of the feedback path added to measured RTT
+	// (zero assumes a symmetric path, i.e. Link.BaseOneWayDelay)
+	ReturnDelay     time.Duration
 	PlayoutDeadline time.Duration
 
 	Link LinkSpec
+	// Path chains several hops (access, core, Wi-Fi, ...) and replaces Link when set
+	Path []HopSpec
+
+	// Feedback enables simulated RTCP: the engine then only sees loss, jitter and RTT
+	// from receiver reports arriving over Feedback.Link (nil uses sender-side ground truth)
+	Feedback *FeedbackSpec
+
+	// RTX enables NACK-based retransmission next to FEC (nil disables)
+	RTX *RTXSpec
+
+	// CrossTraffic are competing flows sharing the forward link
+	CrossTraffic []CrossTrafficSpec
 
 	Seed int64
 }
 
+// hops is the forward path: Path, or Link as the only hop
+func (sc Scenario) hops() []HopSpec {
+	if len(sc.Path) > 0 {
+		return sc.Path
+	}
+	return []HopSpec{{Name: "link", Link: sc.Link}}
+}
+
+// CopiesLossModels tells whether every run of sc gets its own copy of each loss model;
+// runs of a scenario with a shared model (see LossModelReseeder) must not run in parallel
+func (sc Scenario) CopiesLossModels() bool {
+	for _, h := range sc.hops() {
+		if !copiesLossModel(h.Link.Loss) {
+			return false
+		}
+	}
+	if sc.Feedback != nil && !copiesLossModel(sc.Feedback.Link.Loss) {
+		return false
+	}
+	return sc.RTX == nil || copiesLossModel(sc.RTX.Link.Loss)
+}
+
+type FeedbackSpec struct {
+	// ReportInterval between receiver reports (and sender reports); zero uses StatsInterval
+	ReportInterval time.Duration
+	// Link is the reverse path from receiver to sender
+	Link LinkSpec
+}
+
+type RTXSpec struct {
+	// ReorderTolerance is how long a gap may stay open before it is NACKed (default 10ms)
+	ReorderTolerance time.Duration
+	// RetryInterval between repeated NACKs for the same packet (default 100ms)
+	RetryInterval time.Duration
+	// MaxRetries is the number of NACKs sent per missing packet (default 3)
+	MaxRetries int
+	// HistorySize is the number of media packets the sender keeps for retransmission (default 1024)
+	HistorySize int
+	// Link is the reverse path for NACKs when no Feedback is configured;
+	// with Feedback, NACKs share Feedback.Link
+	Link LinkSpec
+}
+
 type FloatSchedule struct {
 	Points  []FloatPoint
 	Default float64
@@ -83,6 +270,27 @@ type FloatPoint struct {
 	Value float64
 }
 
+// Mean is the time average of the schedule over [from, to)
+func (s *FloatSchedule) Mean(from, to time.Duration) float64 {
+	if s == nil || to <= from {
+		return s.At(from)
+	}
+	var sum float64
+	t := from
+	for _, p := range s.Points {
+		if p.At <= t {
+			continue
+		}
+		if p.At >= to {
+			break
+		}
+		sum += s.At(t) * float64(p.At-t)
+		t = p.At
+	}
+	sum += s.At(t) * float64(to-t)
+	return sum / float64(to-from)
+}
+
 func (s *FloatSchedule) At(t time.Duration) float64 {
 	if s == nil || len(s.Points) == 0 {
 		if s == nil {
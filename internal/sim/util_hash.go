@@ -10,11 +10,12 @@ func splitmix64(x uint64) uint64 {
 	return x ^ (x >> 31)
 }
 
-// u01 returns a deterministic float in [0,1) from (seed,ssrc,seq)
-func u01(seed int64, ssrc uint32, seq uint16) float64 {
+// u01 returns a deterministic float in [0,1) from (seed,ssrc,seq); seq is the extended
+// sequence number, so draws don't repeat when the 16-bit one wraps
+func u01(seed int64, ssrc uint32, seq uint64) float64 {
 	// pack inputs
 	x := uint64(seed)
-	x ^= uint64(ssrc)<<32 | uint64(seq)
+	x ^= uint64(ssrc)<<32 ^ seq
 
 	y := splitmix64(x)
 
@@ -0,0 +1,156 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	videoClockRate     = 90000
+	defaultVideoMTU    = 1200
+	defaultIFrameRatio = 5
+)
+
+// videoFrame is one encoded frame as produced by videoSource
+type videoFrame struct {
+	Index    int64
+	Keyframe bool
+	Bytes    int
+	TS       uint32
+}
+
+// videoSource generates frame sizes deterministically per (seed, frame index)
+type videoSource struct {
+	spec    VideoSpec
+	seed    int64
+	startTS uint32
+	tsStep  uint32
+
+	iMean, pMean float64
+	next         int64
+}
+
+func newVideoSource(s SenderSpec, seed int64) *videoSource {
+	v := &videoSource{
+		spec:    *s.Video,
+		seed:    seed,
+		startTS: s.StartTS,
+	}
+	if v.spec.FPS > 0 {
+		v.tsStep = videoClockRate / uint32(v.spec.FPS)
+	}
+	v.iMean, v.pMean = v.spec.meanFrameBytes()
+	return v
+}
+
+// meanFrameBytes returns the mean I- and P-frame size, derived from TargetBitrateBps if set
+func (v VideoSpec) meanFrameBytes() (iMean, pMean float64) {
+	if v.TargetBitrateBps <= 0 || v.FPS <= 0 {
+		return float64(v.IFrame.MeanBytes), float64(v.PFrame.MeanBytes)
+	}
+	ratio := v.IFrameRatio
+	if ratio <= 0 {
+		ratio = defaultIFrameRatio
+	}
+	perFrame := v.TargetBitrateBps / 8 / float64(v.FPS)
+	switch v.KeyframeInterval {
+	case 0:
+		// the single initial keyframe is negligible for the average
+		return ratio * perFrame, perFrame
+	case 1:
+		return perFrame, perFrame
+	}
+	gop := float64(v.KeyframeInterval)
+	pMean = perFrame * gop / (gop - 1 + ratio)
+	return ratio * pMean, pMean
+}
+
+func (v VideoSpec) mtu() int {
+	if v.MTU <= 0 {
+		return defaultVideoMTU
+	}
+	return v.MTU
+}
+
+func (v VideoSpec) isKeyframe(idx int64) bool {
+	if idx == 0 {
+		return true
+	}
+	return v.KeyframeInterval > 0 && idx%int64(v.KeyframeInterval) == 0
+}
+
+// expectedBitrateBps approximates the long-run media rate (mean sizes, ignoring frame size variance)
+func (v VideoSpec) expectedBitrateBps(includeRTPHeader bool) float64 {
+	if v.FPS <= 0 {
+		return 0
+	}
+	iMean, pMean := v.meanFrameBytes()
+	perFrame := func(mean float64) float64 {
+		b := mean
+		if includeRTPHeader {
+			b += 12 * math.Ceil(mean/float64(v.mtu()))
+		}
+		return b
+	}
+	avg := perFrame(pMean)
+	if v.KeyframeInterval > 0 {
+		g := float64(v.KeyframeInterval)
+		avg = (perFrame(iMean) + (g-1)*perFrame(pMean)) / g
+	}
+	return avg * 8 * float64(v.FPS)
+}
+
+func (s *videoSource) Next() videoFrame {
+	idx := s.next
+	s.next++
+
+	key := s.spec.isKeyframe(idx)
+	mean, cv := s.pMean, s.spec.PFrame.CV
+	if key {
+		mean, cv = s.iMean, s.spec.IFrame.CV
+	}
+
+	size := mean
+	if cv > 0 {
+		size += mean * cv * s.normal(idx)
+	}
+	bytes := int(math.Round(size))
+	if bytes < 1 {
+		bytes = 1
+	}
+
+	return videoFrame{
+		Index:    idx,
+		Keyframe: key,
+		Bytes:    bytes,
+		TS:       s.startTS + uint32(idx)*s.tsStep,
+	}
+}
+
+// normal draws a standard normal sample for frame idx (Box-Muller)
+func (s *videoSource) normal(idx int64) float64 {
+	x := splitmix64(uint64(s.seed) ^ uint64(idx)*0x9e3779b97f4a7c15 ^ 0x766964656f)
+	u1 := (float64(x>>11) + 1) / (1 << 53) // (0,1]
+	u2 := float64(splitmix64(x)>>11) / (1 << 53)
+	return math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+}
+
+// packetSizes splits a frame into payload sizes of at most mtu bytes
+func packetSizes(frameBytes, mtu int) []int {
+	n := (frameBytes + mtu - 1) / mtu
+	out := make([]int, n)
+	for i := range out {
+		out[i] = mtu
+	}
+	if rem := frameBytes % mtu; rem != 0 {
+		out[n-1] = rem
+	}
+	return out
+}
+
+func (v VideoSpec) interval() time.Duration {
+	if v.FPS <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(v.FPS)
+}
@@ -0,0 +1,311 @@
+//go:build webrtc
+
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lars-sto/error-recovery-simulation/internal/adapter"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// webrtcConnectTimeout bounds ICE and DTLS between the two PeerConnections
+const webrtcConnectTimeout = 10 * time.Second
+
+// WebRTCSupported reports whether this binary was built with -tags webrtc
+const WebRTCSupported = true
+
+// RunWebRTC runs sc like RunRealtime, but between two pion/webrtc PeerConnections in this
+// process. They negotiate VP8 and FlexFEC-03 via SDP; the sender's interceptor registry holds
+// the FlexFEC interceptor with the run's RuntimeBus as its ConfigSource, and its SRTP leaves
+// through an ICE socket whose RTP the relay puts on the scenario's forward path. Media is read
+// from the receiving PeerConnection's track. pion does not read the FEC stream it negotiated,
+// so FEC packets reach the Receiver as the interceptor wrote them when the relay delivers their
+// SRTP counterpart. SSRCs are chosen by pion
+func RunWebRTC(sc Scenario, opt RunOptions) (RunResult, error) {
+	return runRealtime(sc, opt, &webrtcTransport{})
+}
+
+// webrtcTransport carries a realtime run between two PeerConnections
+type webrtcTransport struct {
+	offer, answer *webrtc.PeerConnection
+	track         *webrtc.TrackLocalStaticRTP
+	out           net.PacketConn // the offerer's socket, written by the relay
+	closers       []io.Closer    // ICE muxes and their sockets
+	ids           RTPIDs
+
+	rt  atomic.Pointer[rtRun]
+	dst atomic.Value // net.Addr of the answerer's ICE candidate
+
+	mu     sync.Mutex
+	fec    map[uint16]rtp.Packet // FEC packets as written by the interceptor, by sequence number
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func (t *webrtcTransport) open(sc Scenario, bus *adapter.RuntimeBus) (RTPIDs, error) {
+	t.fec = make(map[uint16]rtp.Packet)
+	video := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+
+	// Offerer: VP8 + FlexFEC-03, the tap below the FlexFEC interceptor, RTP to the relay
+	offerMedia := &webrtc.MediaEngine{}
+	if err := offerMedia.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: video,
+		PayloadType:        webrtc.PayloadType(sc.IDs.MediaPT),
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return RTPIDs{}, err
+	}
+	reg := &interceptor.Registry{}
+	reg.Add(webrtcTapFactory{t})
+	if err := webrtc.ConfigureFlexFEC03(webrtc.PayloadType(sc.IDs.FECPT), offerMedia, reg, fecOptions(sc, bus)...); err != nil {
+		return RTPIDs{}, err
+	}
+	var err error
+	if t.out, err = listenLoopback(); err != nil {
+		return RTPIDs{}, err
+	}
+	t.closers = append(t.closers, t.out)
+	if t.offer, err = t.peer(offerMedia, reg, &relayPacketConn{PacketConn: t.out, t: t}); err != nil {
+		return RTPIDs{}, err
+	}
+
+	// Answerer: the same codecs without interceptors
+	answerMedia := &webrtc.MediaEngine{}
+	for _, c := range []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: video, PayloadType: webrtc.PayloadType(sc.IDs.MediaPT)},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    webrtc.MimeTypeFlexFEC03,
+				ClockRate:   90000,
+				SDPFmtpLine: "repair-window=10000000",
+			},
+			PayloadType: webrtc.PayloadType(sc.IDs.FECPT),
+		},
+	} {
+		if err := answerMedia.RegisterCodec(c, webrtc.RTPCodecTypeVideo); err != nil {
+			return RTPIDs{}, err
+		}
+	}
+	answerConn, err := listenLoopback()
+	if err != nil {
+		return RTPIDs{}, err
+	}
+	t.closers = append(t.closers, answerConn)
+	if t.answer, err = t.peer(answerMedia, &interceptor.Registry{}, answerConn); err != nil {
+		return RTPIDs{}, err
+	}
+	t.answer.OnTrack(t.onTrack)
+
+	t.track, err = webrtc.NewTrackLocalStaticRTP(video, "video", "ersim")
+	if err != nil {
+		return RTPIDs{}, err
+	}
+	sender, err := t.offer.AddTrack(t.track)
+	if err != nil {
+		return RTPIDs{}, err
+	}
+
+	connected := make(chan struct{}, 2)
+	failed := make(chan struct{}, 2)
+	for _, pc := range []*webrtc.PeerConnection{t.offer, t.answer} {
+		pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+			var ch chan struct{}
+			switch s {
+			case webrtc.PeerConnectionStateConnected:
+				ch = connected
+			case webrtc.PeerConnectionStateFailed:
+				ch = failed
+			default:
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		})
+	}
+	if err := negotiate(t.offer, t.answer); err != nil {
+		return RTPIDs{}, err
+	}
+	timeout := time.After(webrtcConnectTimeout)
+	for n := 0; n < 2; {
+		select {
+		case <-connected:
+			n++
+		case <-failed:
+			return RTPIDs{}, errors.New("webrtc: connection failed")
+		case <-timeout:
+			return RTPIDs{}, fmt.Errorf("webrtc: not connected after %v", webrtcConnectTimeout)
+		}
+	}
+
+	// The SSRCs on the wire are pion's; the payload types are the scenario's
+	enc := sender.GetParameters().Encodings
+	if len(enc) == 0 || enc[0].FEC.SSRC == 0 {
+		return RTPIDs{}, errors.New("webrtc: FlexFEC-03 was not negotiated")
+	}
+	t.ids = sc.IDs
+	t.ids.MediaSSRC = uint32(enc[0].SSRC)
+	t.ids.FECSSRC = uint32(enc[0].FEC.SSRC)
+	return t.ids, nil
+}
+
+func (t *webrtcTransport) start(rt *rtRun) (interceptor.RTPWriter, error) {
+	t.rt.Store(rt)
+	return interceptor.RTPWriterFunc(func(h *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		if err := t.track.WriteRTP(&rtp.Packet{Header: *h, Payload: payload}); err != nil {
+			return 0, err
+		}
+		return len(payload), nil
+	}), nil
+}
+
+func (t *webrtcTransport) forward(b []byte) {
+	dst, _ := t.dst.Load().(net.Addr)
+	if dst == nil {
+		return
+	}
+	_, _ = t.out.WriteTo(b, dst)
+
+	var h rtp.Header
+	if _, err := h.Unmarshal(b); err != nil || h.SSRC != t.ids.FECSSRC {
+		return
+	}
+	t.mu.Lock()
+	pkt, ok := t.fec[h.SequenceNumber]
+	t.mu.Unlock()
+	if ok {
+		t.rt.Load().receive(pkt, time.Now())
+	}
+}
+
+func (t *webrtcTransport) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	for _, pc := range []*webrtc.PeerConnection{t.offer, t.answer} {
+		if pc != nil {
+			_ = pc.Close()
+		}
+	}
+	// muxes before their sockets
+	for i := len(t.closers) - 1; i >= 0; i-- {
+		_ = t.closers[i].Close()
+	}
+	t.wg.Wait()
+}
+
+// onTrack hands every media packet of the answerer's track to the Receiver
+func (t *webrtcTransport) onTrack(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.wg.Add(1)
+	t.mu.Unlock()
+	defer t.wg.Done()
+
+	rt := t.rt.Load()
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		if rt != nil {
+			rt.receive(*pkt, time.Now())
+		}
+	}
+}
+
+// peer builds a PeerConnection whose ICE agent uses conn (IPv4 host candidates only)
+func (t *webrtcTransport) peer(media *webrtc.MediaEngine, reg *interceptor.Registry, conn net.PacketConn) (*webrtc.PeerConnection, error) {
+	mux := webrtc.NewICEUDPMux(nil, conn)
+	t.closers = append(t.closers, mux)
+	se := webrtc.SettingEngine{}
+	se.SetICEUDPMux(mux)
+	se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	se.SetIncludeLoopbackCandidate(true)
+	api := webrtc.NewAPI(
+		webrtc.WithMediaEngine(media),
+		webrtc.WithInterceptorRegistry(reg),
+		webrtc.WithSettingEngine(se),
+	)
+	return api.NewPeerConnection(webrtc.Configuration{})
+}
+
+// negotiate runs offer/answer with complete candidate gathering (no trickle ICE)
+func negotiate(offer, answer *webrtc.PeerConnection) error {
+	exchange := func(from, to *webrtc.PeerConnection, create func(*webrtc.PeerConnection) (webrtc.SessionDescription, error)) error {
+		sd, err := create(from)
+		if err != nil {
+			return err
+		}
+		gathered := webrtc.GatheringCompletePromise(from)
+		if err := from.SetLocalDescription(sd); err != nil {
+			return err
+		}
+		<-gathered
+		return to.SetRemoteDescription(*from.LocalDescription())
+	}
+	if err := exchange(offer, answer, func(pc *webrtc.PeerConnection) (webrtc.SessionDescription, error) {
+		return pc.CreateOffer(nil)
+	}); err != nil {
+		return err
+	}
+	return exchange(answer, offer, func(pc *webrtc.PeerConnection) (webrtc.SessionDescription, error) {
+		return pc.CreateAnswer(nil)
+	})
+}
+
+// relayPacketConn is the offerer's ICE socket: RTP goes to the relay, which forwards it when
+// the path delivers it; STUN, DTLS and RTCP pass straight through
+type relayPacketConn struct {
+	net.PacketConn
+	t *webrtcTransport
+}
+
+func (c *relayPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	rt := c.t.rt.Load()
+	if rt == nil || !isRTP(b) {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	c.t.dst.Store(addr)
+	rt.relay(append([]byte(nil), b...), time.Now())
+	return len(b), nil
+}
+
+// webrtcTap sits below the FlexFEC interceptor: it books every packet the interceptor writes
+// with the run and keeps the plaintext of FEC packets for the Receiver
+type webrtcTap struct {
+	interceptor.NoOp
+	t *webrtcTransport
+}
+
+type webrtcTapFactory struct{ t *webrtcTransport }
+
+func (f webrtcTapFactory) NewInterceptor(string) (interceptor.Interceptor, error) {
+	return &webrtcTap{t: f.t}, nil
+}
+
+func (i *webrtcTap) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(h *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+		if rt := i.t.rt.Load(); rt != nil {
+			rt.sent(h, h.MarshalSize()+len(payload))
+		}
+		if info.SSRCForwardErrorCorrection != 0 && h.SSRC == info.SSRCForwardErrorCorrection {
+			i.t.mu.Lock()
+			i.t.fec[h.SequenceNumber] = rtp.Packet{Header: h.Clone(), Payload: append([]byte(nil), payload...)}
+			i.t.mu.Unlock()
+		}
+		return writer.Write(h, payload, a)
+	})
+}
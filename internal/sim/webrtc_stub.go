@@ -0,0 +1,13 @@
+//go:build !webrtc
+
+package sim
+
+import "errors"
+
+// WebRTCSupported reports whether this binary was built with -tags webrtc
+const WebRTCSupported = false
+
+// RunWebRTC needs pion/webrtc; see the webrtc build tag
+func RunWebRTC(sc Scenario, _ RunOptions) (RunResult, error) {
+	return RunResult{Scenario: sc.Name}, errors.New("webrtc runs need a build with -tags webrtc (see README)")
+}
@@ -0,0 +1,53 @@
+//go:build webrtc
+
+package sim
+
+import (
+	"testing"
+	"time"
+)
+
+// One short wall-clock run between the two PeerConnections: SDP negotiation of FlexFEC-03,
+// ICE/DTLS on loopback, the relay and the Receiver fed from the remote track
+func TestRunWebRTC(t *testing.T) {
+	if testing.Short() {
+		t.Skip("wall-clock run")
+	}
+	sc := Scenario{
+		Name:     "webrtc_smoke",
+		Duration: 2 * time.Second,
+		IDs:      testIDs,
+		Sender: SenderSpec{
+			PacketRateHz:  100,
+			PayloadBytes:  200,
+			StartSeq:      1,
+			StartTS:       1,
+			TimestampStep: 900,
+		},
+		K:               10,
+		StaticR:         2,
+		StatsInterval:   200 * time.Millisecond,
+		BWE:             &FloatSchedule{Default: 2e6},
+		RTTMs:           40,
+		JitterMs:        5,
+		PlayoutDeadline: 200 * time.Millisecond,
+		Link: LinkSpec{
+			BaseOneWayDelay: 20 * time.Millisecond,
+			CapacityBps:     &FloatSchedule{Default: 5e6},
+			Loss:            NewScheduledBernoulliLoss("", 0, &FloatSchedule{Default: 0.05}),
+		},
+	}
+	res, err := RunWebRTC(sc, RunOptions{Mode: ModeStatic, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SentMediaPkts == 0 || res.SentFECPkts == 0 {
+		t.Fatalf("sent %d media and %d FEC packets, want both", res.SentMediaPkts, res.SentFECPkts)
+	}
+	if res.RecvMediaPkts == 0 || res.RecvFECPkts == 0 {
+		t.Fatalf("received %d media and %d FEC packets, want both", res.RecvMediaPkts, res.RecvFECPkts)
+	}
+	if res.UniquePkts > res.SentMediaPkts {
+		t.Errorf("unique = %d, more than the %d media packets sent", res.UniquePkts, res.SentMediaPkts)
+	}
+}
@@ -0,0 +1,34 @@
+#!/usr/bin/env python3
+"""Example external controller for `ersim ... -mode external -policy-cmd`.
+
+The simulator writes one JSON object per line to stdin: an "init" message
+(scenario, seed, k, static_r, max_fec_packets, stats_interval_ms), then one
+"window" message per stats window (t_ms, loss_rate, rtt_ms, jitter_ms,
+target_bitrate_bps, current_bitrate_bps, true_loss, congestion_loss and the
+current "fec" configuration). Every window must be answered with one line:
+a FEC configuration such as {"num_fec_packets": 2} (optionally "enabled",
+"num_media_packets", "coverage_mode", "interleave_stride", "burst_span",
+"reason") or {} to keep the current one.
+
+This one protects 2x the reported loss, smoothed with an EWMA.
+"""
+import json
+import math
+import sys
+
+ALPHA = 0.3
+SCALE = 2.0
+
+k, max_r, loss = 10, 5, 0.0
+for line in sys.stdin:
+    msg = json.loads(line)
+    if msg["type"] == "init":
+        k, max_r = msg["k"], msg["max_fec_packets"]
+        continue
+
+    loss = ALPHA * msg["loss_rate"] + (1 - ALPHA) * loss
+    r = min(max_r, math.ceil(loss * SCALE * k - 1e-9))
+    if r == msg["fec"]["num_fec_packets"] and (r > 0) == msg["fec"].get("enabled", False):
+        print("{}", flush=True)
+    else:
+        print(json.dumps({"num_fec_packets": r, "reason": f"ewma loss {loss:.4f}"}), flush=True)